@@ -17,8 +17,14 @@ const (
 	filterUserAD       = "(&(objectClass=User))"
 	filterUserOpenLDAP = "(&(objectClass=person))"
 
-	// DepthOfLdapSearch For get AD struct
+	// DepthOfLdapSearch For get AD struct (default TreeOptions.MaxDepth)
 	DepthOfLdapSearch = 4
+
+	// UnlimitedDepth TreeOptions.MaxDepth value for reading the whole tree
+	UnlimitedDepth = -1
+
+	// pagingSize Page size for paged searches (AD MaxPageSize is 1000 by default)
+	pagingSize = 500
 )
 
 ////////////////////////////////////////////// Attr templates
@@ -0,0 +1,22 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// DN utils
+
+// isSubDN - check if dn is under group dn (case-insensitive, RFC 4514 escaping and whitespaces are handled)
+func isSubDN(group, dn string) bool {
+	parsedGroup, err := ldap.ParseDN(group)
+	if err != nil {
+		return false
+	}
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return false
+	}
+
+	return parsedGroup.AncestorOfFold(parsed)
+}
@@ -4,7 +4,6 @@ package ldapper
 import (
 	"crypto/tls"
 	"fmt"
-	"strings"
 
 	"github.com/go-ldap/ldap/v3"
 )
@@ -395,51 +394,13 @@ func ReadRootGroups(userName, passWord,
 	host string, port interface{},
 	baseDn string, useTls, openLdap bool) ([]GroupInfo, error) {
 
-	res := make([]GroupInfo, 0)
-
 	conn, err := NewLdapConn(userName, passWord, host, port, useTls)
 	if err != nil {
-		return res, err
+		return make([]GroupInfo, 0), err
 	}
 	defer func() { conn.Close() }()
 
-	var attributes = make([]string, 0)
-	if openLdap {
-		attributes = []string{"ou"}
-	} else {
-		attributes = []string{"name", "ou", "distinguishedName"}
-	}
-
-	searchRequest := ldap.NewSearchRequest(
-		baseDn,
-		ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
-		filterGroup,
-		attributes,
-		nil,
-	)
-
-	searchResult, err := conn.Connection.Search(searchRequest)
-	if err != nil {
-		return res, fmt.Errorf("bad search: %s", err.Error())
-	}
-
-	for _, entry := range searchResult.Entries {
-		var inf GroupInfo
-
-		inf.Ou = entry.GetAttributeValue("ou")
-
-		if openLdap {
-			inf.Name = inf.Ou
-			inf.DName = entry.DN
-		} else {
-			inf.Name = entry.GetAttributeValue("name")
-			inf.DName = entry.GetAttributeValue("distinguishedName")
-		}
-
-		res = append(res, inf)
-	}
-
-	return res, nil
+	return readRootGroups(conn, baseDn, openLdap)
 }
 
 // ReadRootGroupsWithTLSConfig Reading root AD dirs (ou) with passed tls config
@@ -447,14 +408,19 @@ func ReadRootGroupsWithTLSConfig(userName, passWord,
 	host string, port interface{},
 	baseDn string, tlsCfg *tls.Config, openLdap bool) ([]GroupInfo, error) {
 
-	res := make([]GroupInfo, 0)
-
 	conn, err := NewLdapConnWithTLSConfig(userName, passWord, host, port, tlsCfg)
 	if err != nil {
-		return res, err
+		return make([]GroupInfo, 0), err
 	}
 	defer func() { conn.Close() }()
 
+	return readRootGroups(conn, baseDn, openLdap)
+}
+
+// readRootGroups Reading root AD dirs (ou) using opened conn
+func readRootGroups(conn *LdapConn, baseDn string, openLdap bool) ([]GroupInfo, error) {
+	res := make([]GroupInfo, 0)
+
 	var attributes = make([]string, 0)
 	if openLdap {
 		attributes = []string{"ou"}
@@ -499,55 +465,13 @@ func ReadSubGroups(userName, passWord, grp string,
 	level int, host string, port interface{},
 	useTls, openLdap bool) ([]GroupInfo, error) {
 
-	res := make([]GroupInfo, 0)
-
 	conn, err := NewLdapConn(userName, passWord, host, port, useTls)
 	if err != nil {
-		return res, err
+		return make([]GroupInfo, 0), err
 	}
 	defer func() { conn.Close() }()
 
-	var attributes = make([]string, 0)
-	if openLdap {
-		attributes = []string{"ou"}
-	} else {
-		attributes = []string{"name", "ou", "distinguishedName"}
-	}
-
-	searchRequest := ldap.NewSearchRequest(
-		grp,
-		level, ldap.NeverDerefAliases, 0, 0, false,
-		filterGroup,
-		attributes,
-		nil,
-	)
-
-	searchResult, err := conn.Connection.Search(searchRequest)
-	if err != nil {
-		return res, fmt.Errorf("bad search: %s", err.Error())
-	}
-
-	for _, entry := range searchResult.Entries {
-		var inf GroupInfo
-		inf.Ou = entry.GetAttributeValue("ou")
-
-		if openLdap {
-			inf.Name = inf.Ou
-			inf.DName = entry.DN
-		} else {
-			inf.Name = entry.GetAttributeValue("name")
-			inf.DName = entry.GetAttributeValue("distinguishedName")
-		}
-
-		// Needed for recursive AD struct search
-		inf.Has = make([]GroupInfo, 0)
-
-		if strings.Contains(inf.DName, grp) && inf.DName != grp {
-			res = append(res, inf)
-		}
-	}
-
-	return res, nil
+	return readSubGroups(conn, grp, level, openLdap)
 }
 
 // ReadSubGroupsWithTLSConfig Reading AD subDirs in group with tls config
@@ -555,14 +479,19 @@ func ReadSubGroupsWithTLSConfig(userName, passWord, grp string,
 	level int, host string, port interface{},
 	tlsCfg *tls.Config, openLdap bool) ([]GroupInfo, error) {
 
-	res := make([]GroupInfo, 0)
-
 	conn, err := NewLdapConnWithTLSConfig(userName, passWord, host, port, tlsCfg)
 	if err != nil {
-		return res, err
+		return make([]GroupInfo, 0), err
 	}
 	defer func() { conn.Close() }()
 
+	return readSubGroups(conn, grp, level, openLdap)
+}
+
+// readSubGroups Reading AD subDirs in group using opened conn
+func readSubGroups(conn *LdapConn, grp string, level int, openLdap bool) ([]GroupInfo, error) {
+	res := make([]GroupInfo, 0)
+
 	var attributes = make([]string, 0)
 	if openLdap {
 		attributes = []string{"ou"}
@@ -598,7 +527,7 @@ func ReadSubGroupsWithTLSConfig(userName, passWord, grp string,
 		// Needed for recursive AD struct search
 		inf.Has = make([]GroupInfo, 0)
 
-		if strings.Contains(inf.DName, grp) && inf.DName != grp {
+		if isSubDN(grp, inf.DName) {
 			res = append(res, inf)
 		}
 	}
@@ -606,6 +535,24 @@ func ReadSubGroupsWithTLSConfig(userName, passWord, grp string,
 	return res, nil
 }
 
+// hasSubGroups Check if AD group has at least one subDir using opened conn
+func hasSubGroups(conn *LdapConn, grp string) bool {
+	searchRequest := ldap.NewSearchRequest(
+		grp,
+		ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 1, 0, false,
+		filterGroup,
+		[]string{"1.1"},
+		nil,
+	)
+
+	searchResult, err := conn.Connection.Search(searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return false
+	}
+
+	return searchResult != nil && len(searchResult.Entries) > 0
+}
+
 // ReadGroupUsers Reading all users from group (conn with InsecureSkipVerify: true)
 func ReadGroupUsers(userName, passWord, grp,
 	host string, port interface{},
@@ -770,38 +717,30 @@ func RecursiveADSearchWithTLSConfig(prevLevel *[]GroupInfo,
 	return &nextLevel
 }
 
-// ReadAdStruct Reading full AD structure (with depth 2) (conn with InsecureSkipVerify: true)
+// ReadAdStruct Reading full AD structure (with depth DepthOfLdapSearch by default, see TreeOptions)
+// (conn with InsecureSkipVerify: true)
 func ReadAdStruct(userName, passWord, host string, port interface{},
-	baseDn string, useTls, openLdap bool) (ADStruct, error) {
-	var res ADStruct
+	baseDn string, useTls, openLdap bool, opts ...TreeOptions) (ADStruct, error) {
 
-	firstLevel, err := ReadRootGroups(userName, passWord, host, port, baseDn, useTls, openLdap)
+	conn, err := NewLdapConn(userName, passWord, host, port, useTls)
 	if err != nil {
 		return ADStruct{}, err
 	}
+	defer func() { conn.Close() }()
 
-	if firstLevel != nil {
-		_ = RecursiveADSearch(&firstLevel, userName, passWord, host, port, useTls, openLdap, 2)
-		res.AD = firstLevel
-	}
-
-	return res, nil
+	return readAdStruct(conn, baseDn, openLdap, getTreeOptions(opts))
 }
 
-// ReadAdStructWithTLSConfig Reading full AD structure (with depth 2) with passed tls config
+// ReadAdStructWithTLSConfig Reading full AD structure (with depth DepthOfLdapSearch by default, see TreeOptions)
+// with passed tls config
 func ReadAdStructWithTLSConfig(userName, passWord, host string, port interface{},
-	baseDn string, tlsCfg *tls.Config, openLdap bool) (ADStruct, error) {
-	var res ADStruct
+	baseDn string, tlsCfg *tls.Config, openLdap bool, opts ...TreeOptions) (ADStruct, error) {
 
-	firstLevel, err := ReadRootGroupsWithTLSConfig(userName, passWord, host, port, baseDn, tlsCfg, openLdap)
+	conn, err := NewLdapConnWithTLSConfig(userName, passWord, host, port, tlsCfg)
 	if err != nil {
 		return ADStruct{}, err
 	}
+	defer func() { conn.Close() }()
 
-	if firstLevel != nil {
-		_ = RecursiveADSearchWithTLSConfig(&firstLevel, userName, passWord, host, port, tlsCfg, openLdap, 2)
-		res.AD = firstLevel
-	}
-
-	return res, nil
+	return readAdStruct(conn, baseDn, openLdap, getTreeOptions(opts))
 }
@@ -0,0 +1,314 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Tree options
+
+// getTreeOptions - get only 1st options object (default options if not passed)
+func getTreeOptions(opts []TreeOptions) TreeOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return TreeOptions{}
+}
+
+// depthAllowed - check if groups of passed tree level may be read
+func (o TreeOptions) depthAllowed(level int) bool {
+	switch {
+	case o.MaxDepth == UnlimitedDepth:
+		return true
+	case o.MaxDepth <= 0:
+		return level <= DepthOfLdapSearch
+	default:
+		return level <= o.MaxDepth
+	}
+}
+
+// compileDNPatterns - make case-insensitive regexps from dn patterns (* - any chars)
+func compileDNPatterns(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSpace(p)), `\*`, ".*")
+		res = append(res, regexp.MustCompile("(?i)^"+expr+"$"))
+	}
+	return res
+}
+
+// matchAny - check if dn matches one of patterns
+func matchAny(patterns []*regexp.Regexp, dn string) bool {
+	for _, p := range patterns {
+		if p.MatchString(dn) {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////// Tree walker
+
+// treeFilter - applying TreeOptions limits to read tree levels
+type treeFilter struct {
+	opts    TreeOptions
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newTreeFilter(opts TreeOptions) *treeFilter {
+	return &treeFilter{
+		opts:    opts,
+		include: compileDNPatterns(opts.Include),
+		exclude: compileDNPatterns(opts.Exclude),
+	}
+}
+
+// level - drop excluded groups and cut level by MaxChildren (returns true if level was cut)
+func (f *treeFilter) level(groups []GroupInfo) ([]GroupInfo, bool) {
+	res := make([]GroupInfo, 0, len(groups))
+	for _, g := range groups {
+		if matchAny(f.exclude, g.DName) {
+			continue
+		}
+		res = append(res, g)
+	}
+
+	if f.opts.MaxChildren > 0 && len(res) > f.opts.MaxChildren {
+		return res[:f.opts.MaxChildren], true
+	}
+
+	return res, false
+}
+
+// prune - keep only groups matching Include patterns (and their parents)
+func (f *treeFilter) prune(groups []GroupInfo) []GroupInfo {
+	if len(f.include) == 0 {
+		return groups
+	}
+
+	res := make([]GroupInfo, 0)
+	for _, g := range groups {
+		g.Has = f.prune(g.Has)
+		if len(g.Has) > 0 || matchAny(f.include, g.DName) {
+			res = append(res, g)
+		}
+	}
+
+	return res
+}
+
+////////////////////////////////////////////// Tree reading
+
+// readAdStruct Reading AD structure using opened conn (one conn for the whole tree)
+func readAdStruct(conn *LdapConn, baseDn string, openLdap bool, opts TreeOptions) (ADStruct, error) {
+	var res ADStruct
+
+	if opts.StartDN != "" {
+		baseDn = opts.StartDN
+	}
+	filter := newTreeFilter(opts)
+
+	firstLevel, err := readRootGroups(conn, baseDn, openLdap)
+	if err != nil {
+		return ADStruct{}, err
+	}
+
+	if firstLevel != nil {
+		firstLevel, _ = filter.level(firstLevel)
+		readTreeLevel(conn, &firstLevel, openLdap, 2, filter)
+		res.AD = filter.prune(firstLevel)
+	}
+
+	return res, nil
+}
+
+// readTreeLevel - run recursive search in AD (group->subgroup->etc.) with TreeOptions limits
+func readTreeLevel(conn *LdapConn, prevLevel *[]GroupInfo, openLdap bool, level int, filter *treeFilter) {
+	for k, v := range *prevLevel {
+		if !filter.opts.depthAllowed(level) {
+			(*prevLevel)[k].Truncated = hasSubGroups(conn, v.DName)
+			continue
+		}
+
+		nextLevel, _ := readSubGroups(conn, v.DName, ldap.ScopeSingleLevel, openLdap)
+		if nextLevel != nil {
+			nextLevel, (*prevLevel)[k].Truncated = filter.level(nextLevel)
+			readTreeLevel(conn, &nextLevel, openLdap, level+1, filter)
+			(*prevLevel)[k].Has = nextLevel
+		}
+	}
+}
+
+////////////////////////////////////////////// Subtree tree builder
+
+// treeNode - group with its children while assembling tree in memory
+type treeNode struct {
+	info     GroupInfo
+	children []*treeNode
+}
+
+// ReadAdStructBySubtree Reading full AD structure with one paged subtree search (tree is assembled in memory)
+// (conn with InsecureSkipVerify: true)
+func ReadAdStructBySubtree(userName, passWord, host string, port interface{},
+	baseDn string, useTls, openLdap bool, opts ...TreeOptions) (ADStruct, error) {
+
+	conn, err := NewLdapConn(userName, passWord, host, port, useTls)
+	if err != nil {
+		return ADStruct{}, err
+	}
+	defer func() { conn.Close() }()
+
+	return readAdStructBySubtree(conn, baseDn, openLdap, getTreeOptions(opts))
+}
+
+// ReadAdStructBySubtreeWithTLSConfig Reading full AD structure with one paged subtree search
+// (tree is assembled in memory) with passed tls config
+func ReadAdStructBySubtreeWithTLSConfig(userName, passWord, host string, port interface{},
+	baseDn string, tlsCfg *tls.Config, openLdap bool, opts ...TreeOptions) (ADStruct, error) {
+
+	conn, err := NewLdapConnWithTLSConfig(userName, passWord, host, port, tlsCfg)
+	if err != nil {
+		return ADStruct{}, err
+	}
+	defer func() { conn.Close() }()
+
+	return readAdStructBySubtree(conn, baseDn, openLdap, getTreeOptions(opts))
+}
+
+// readAdStructBySubtree Reading AD structure with one paged subtree search using opened conn
+func readAdStructBySubtree(conn *LdapConn, baseDn string, openLdap bool, opts TreeOptions) (ADStruct, error) {
+	var res ADStruct
+
+	if opts.StartDN != "" {
+		baseDn = opts.StartDN
+	}
+
+	groups, err := readAllGroups(conn, baseDn, openLdap)
+	if err != nil {
+		return ADStruct{}, err
+	}
+
+	res.AD, err = buildTree(baseDn, groups, newTreeFilter(opts))
+	if err != nil {
+		return ADStruct{}, err
+	}
+
+	return res, nil
+}
+
+// readAllGroups Reading all AD dirs under baseDn with one paged subtree search using opened conn
+func readAllGroups(conn *LdapConn, baseDn string, openLdap bool) ([]GroupInfo, error) {
+	res := make([]GroupInfo, 0)
+
+	var attributes = make([]string, 0)
+	if openLdap {
+		attributes = []string{"ou"}
+	} else {
+		attributes = []string{"name", "ou", "distinguishedName"}
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filterGroup,
+		attributes,
+		nil,
+	)
+
+	searchResult, err := conn.Connection.SearchWithPaging(searchRequest, pagingSize)
+	if err != nil {
+		return res, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	for _, entry := range searchResult.Entries {
+		var inf GroupInfo
+
+		inf.Ou = entry.GetAttributeValue("ou")
+
+		if openLdap {
+			inf.Name = inf.Ou
+		} else {
+			inf.Name = entry.GetAttributeValue("name")
+		}
+		inf.DName = entry.DN
+
+		res = append(res, inf)
+	}
+
+	return res, nil
+}
+
+// buildTree - assemble groups tree under baseDn by groups parent DNs.
+// Groups with unknown parents (not found in passed list) are dropped like in per-level search
+func buildTree(baseDn string, groups []GroupInfo, filter *treeFilter) ([]GroupInfo, error) {
+	base, err := ldap.ParseDN(baseDn)
+	if err != nil {
+		return nil, fmt.Errorf("bad base dn: %s", err.Error())
+	}
+
+	nodes := make(map[string]*treeNode, len(groups))
+	parents := make(map[*treeNode]*ldap.DN, len(groups))
+	order := make([]*treeNode, 0, len(groups))
+	for _, g := range groups {
+		dn, err := ldap.ParseDN(g.DName)
+		if err != nil || len(dn.RDNs) == 0 || dn.EqualFold(base) {
+			continue
+		}
+
+		node := &treeNode{info: g}
+		nodes[dnKey(dn)] = node
+		parents[node] = &ldap.DN{RDNs: dn.RDNs[1:]}
+		order = append(order, node)
+	}
+
+	roots := make([]*treeNode, 0)
+	for _, node := range order {
+		parent := parents[node]
+		if parent.EqualFold(base) {
+			roots = append(roots, node)
+			continue
+		}
+		if p, ok := nodes[dnKey(parent)]; ok {
+			p.children = append(p.children, node)
+		}
+	}
+
+	res, _ := filter.materialize(roots, 1)
+
+	return filter.prune(res), nil
+}
+
+// dnKey - map key for parsed dn (case-insensitive)
+func dnKey(dn *ldap.DN) string {
+	return strings.ToLower(dn.String())
+}
+
+// materialize - make groups tree level from assembled nodes with TreeOptions limits (returns true if level was cut)
+func (f *treeFilter) materialize(nodes []*treeNode, level int) ([]GroupInfo, bool) {
+	byDN := make(map[string]*treeNode, len(nodes))
+	infos := make([]GroupInfo, 0, len(nodes))
+	for _, node := range nodes {
+		byDN[node.info.DName] = node
+		infos = append(infos, node.info)
+	}
+
+	res, truncated := f.level(infos)
+	for k, v := range res {
+		children := byDN[v.DName].children
+		if !f.opts.depthAllowed(level + 1) {
+			res[k].Has = make([]GroupInfo, 0)
+			res[k].Truncated = len(children) > 0
+			continue
+		}
+
+		res[k].Has, res[k].Truncated = f.materialize(children, level+1)
+	}
+
+	return res, truncated
+}
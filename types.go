@@ -35,9 +35,20 @@ type GroupInfo struct {
 	DName string      `json:"distinguishedName"` // long department name
 	Ou    string      `json:"ou"`
 	Has   []GroupInfo `json:"has"` // list of subdirs (group children)
+
+	Truncated bool `json:"truncated,omitempty"` // some children were cut off by TreeOptions limits
 }
 
 // ADStruct Full AD struct.
 type ADStruct struct {
 	AD []GroupInfo `json:"ad_map"`
 }
+
+// TreeOptions Options for reading AD struct (depth, breadth, filters)
+type TreeOptions struct {
+	StartDN     string   // dn to start reading from (baseDn is used if empty)
+	MaxDepth    int      // max tree depth, root groups are level 1 (0 - DepthOfLdapSearch, UnlimitedDepth - no limit)
+	MaxChildren int      // max children per node (0 - no limit)
+	Include     []string // dn patterns (* - any chars, case-insensitive), only matching nodes and their parents are kept
+	Exclude     []string // dn patterns, matching nodes are dropped with all their children
+}
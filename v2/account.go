@@ -0,0 +1,322 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
+)
+
+////////////////////////////////////////////// Account status
+
+// accountStatusAD - decode AD account state (userAccountControl, lockoutTime, accountExpires, pwdLastSet)
+func accountStatusAD(entry *ldap.Entry, now time.Time) (res AccountStatus) {
+	uac, _ := strconv.ParseUint(entry.GetAttributeValue("userAccountControl"), 10, 32)
+	res.Flags = uint32(uac)
+
+	// computed flags are set by AD with lockout duration and password max age in mind
+	computedValue := entry.GetAttributeValue("msDS-User-Account-Control-Computed")
+	computed, _ := strconv.ParseUint(computedValue, 10, 32)
+
+	res.Enabled = res.Flags&UACAccountDisable == 0
+	res.PasswordNeverExpires = res.Flags&UACDontExpirePassword != 0
+	res.SmartcardRequired = res.Flags&UACSmartcardRequired != 0
+	res.PasswordExpired = (uint32(computed)|res.Flags)&UACPasswordExpired != 0
+
+	res.LockoutTime = fileTimeAttr(entry, "lockoutTime")
+	if computedValue != "" {
+		res.Locked = uint32(computed)&UACLockout != 0
+	} else {
+		res.Locked = res.LockoutTime != nil
+	}
+
+	res.AccountExpires = fileTimeAttr(entry, "accountExpires")
+	res.Expired = res.AccountExpires != nil && !res.AccountExpires.After(now)
+
+	res.PasswordLastSet = fileTimeAttr(entry, "pwdLastSet")
+	res.MustChangePassword = entry.GetAttributeValue("pwdLastSet") == "0"
+
+	return res
+}
+
+// accountStatusOpenLDAP - decode openLdap account state (ppolicy pwdAccountLockedTime, pwdChangedTime,
+// pwdReset and shadowExpire)
+func accountStatusOpenLDAP(entry *ldap.Entry, now time.Time) (res AccountStatus) {
+	res.Enabled = true
+
+	switch lockedTime := entry.GetAttributeValue("pwdAccountLockedTime"); lockedTime {
+	case "":
+	case ppolicyDisabledTime:
+		// locked by administrator until unlocked manually
+		res.Enabled = false
+	default:
+		res.Locked = true
+		if t, err := codec.ParseGeneralizedTime(lockedTime); err == nil {
+			res.LockoutTime = &t
+		}
+	}
+
+	if t, err := codec.ParseGeneralizedTime(entry.GetAttributeValue("pwdChangedTime")); err == nil {
+		res.PasswordLastSet = &t
+	}
+	res.MustChangePassword = strings.EqualFold(entry.GetAttributeValue("pwdReset"), "TRUE")
+
+	// shadowExpire - days since 1970-01-01 (-1 or no value - never)
+	if days, err := strconv.ParseInt(entry.GetAttributeValue("shadowExpire"), 10, 64); err == nil && days >= 0 {
+		accountExpires := time.Unix(days*24*60*60, 0).UTC()
+		res.AccountExpires = &accountExpires
+		res.Expired = !accountExpires.After(now)
+	}
+
+	return res
+}
+
+// fileTimeAttr - decode FILETIME attribute value (nil for absent, bad and "never" values)
+func fileTimeAttr(entry *ldap.Entry, attr string) *time.Time {
+	t, err := codec.ParseFileTime(entry.GetAttributeValue(attr))
+	if err != nil || t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+////////////////////////////////////////////// Group users filters
+
+// getGroupUsersOptions - get only 1st options object (default options if not passed)
+func getGroupUsersOptions(opts []GroupUsersOptions) GroupUsersOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return GroupUsersOptions{}
+}
+
+// userFilterAD - make AD users search filter with account state conditions
+func (o GroupUsersOptions) userFilterAD(now time.Time) string {
+	filter := filterUserAD
+	if o.ExcludeDisabled {
+		filter += filterEnabledAD
+	}
+	if o.ExcludeExpired {
+		filter += fmt.Sprintf(filterNotExpiredAD, codec.TimeToFileTime(now))
+	}
+
+	if filter == filterUserAD {
+		return filter
+	}
+	return "(&" + filter + ")"
+}
+
+// skipOpenLDAP - check if openLdap user must be skipped by account state
+func (o GroupUsersOptions) skipOpenLDAP(entry *ldap.Entry, now time.Time) bool {
+	if !o.ExcludeDisabled && !o.ExcludeExpired {
+		return false
+	}
+
+	status := accountStatusOpenLDAP(entry, now)
+
+	return (o.ExcludeDisabled && !status.Enabled) || (o.ExcludeExpired && status.Expired)
+}
+
+////////////////////////////////////////////// Account operations
+
+// DisableAccount Disable account (AD ACCOUNTDISABLE flag, openLdap ppolicy pwdAccountLockedTime=000001010000Z)
+func (conn *LdapConn) DisableAccount(ctx context.Context, userDN string) error {
+	if conn.options.OpenLDAP {
+		return conn.modifyAccountOpenLDAP(ctx, userDN, func(entry *ldap.Entry) *ldap.ModifyRequest {
+			if entry.GetAttributeValue("pwdAccountLockedTime") == ppolicyDisabledTime {
+				return nil
+			}
+			modifyRequest := ldap.NewModifyRequest(userDN, nil)
+			modifyRequest.Replace("pwdAccountLockedTime", []string{ppolicyDisabledTime})
+			return modifyRequest
+		})
+	}
+
+	return conn.modifyUAC(ctx, userDN, UACAccountDisable, 0)
+}
+
+// EnableAccount Enable disabled account (lockout is not changed, see UnlockAccount)
+func (conn *LdapConn) EnableAccount(ctx context.Context, userDN string) error {
+	if conn.options.OpenLDAP {
+		return conn.modifyAccountOpenLDAP(ctx, userDN, func(entry *ldap.Entry) *ldap.ModifyRequest {
+			if entry.GetAttributeValue("pwdAccountLockedTime") != ppolicyDisabledTime {
+				return nil
+			}
+			modifyRequest := ldap.NewModifyRequest(userDN, nil)
+			modifyRequest.Delete("pwdAccountLockedTime", nil)
+			return modifyRequest
+		})
+	}
+
+	return conn.modifyUAC(ctx, userDN, 0, UACAccountDisable)
+}
+
+// UnlockAccount Unlock locked out account (AD lockoutTime=0, openLdap ppolicy pwdAccountLockedTime removal),
+// disabled accounts stay disabled
+func (conn *LdapConn) UnlockAccount(ctx context.Context, userDN string) error {
+	if conn.options.OpenLDAP {
+		return conn.modifyAccountOpenLDAP(ctx, userDN, func(entry *ldap.Entry) *ldap.ModifyRequest {
+			lockedTime := entry.GetAttributeValue("pwdAccountLockedTime")
+			failures := entry.GetAttributeValues("pwdFailureTime")
+			if (lockedTime == "" || lockedTime == ppolicyDisabledTime) && len(failures) == 0 {
+				return nil
+			}
+
+			modifyRequest := ldap.NewModifyRequest(userDN, nil)
+			if lockedTime != "" && lockedTime != ppolicyDisabledTime {
+				modifyRequest.Delete("pwdAccountLockedTime", nil)
+			}
+			if len(failures) > 0 {
+				modifyRequest.Delete("pwdFailureTime", nil)
+			}
+			return modifyRequest
+		})
+	}
+
+	if err := validateDN(userDN); err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Replace("lockoutTime", []string{"0"})
+
+	return conn.modifyAccount(ctx, modifyRequest)
+}
+
+// SetAccountExpiry Set account expiry date (zero time - never expires).
+// AD accountExpires, openLdap shadowExpire (days precision, shadowAccount object class is required)
+func (conn *LdapConn) SetAccountExpiry(ctx context.Context, userDN string, expires time.Time) error {
+	if err := validateDN(userDN); err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	if conn.options.OpenLDAP {
+		var values []string
+		if !expires.IsZero() {
+			values = []string{strconv.FormatInt(shadowDays(expires), 10)}
+		}
+		modifyRequest.Replace("shadowExpire", values)
+	} else {
+		modifyRequest.Replace("accountExpires", []string{codec.FormatFileTime(expires)})
+	}
+
+	return conn.modifyAccount(ctx, modifyRequest)
+}
+
+// modifyUAC - read-modify-write of AD userAccountControl (set and clear flags, other flags are kept).
+// Old value is deleted in the same request, so concurrent change fails it (NoSuchAttribute) and it is
+// made again from the new value once
+func (conn *LdapConn) modifyUAC(ctx context.Context, userDN string, set, clear uint32) error {
+	if err := validateDN(userDN); err != nil {
+		return err
+	}
+	userDN, err := conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		entry, err := conn.readEntry(ctx, userDN, []string{"userAccountControl"})
+		if err != nil {
+			return err
+		}
+
+		modifyRequest, err := uacModifyRequest(userDN, entry.GetAttributeValue("userAccountControl"), set, clear)
+		if err != nil || modifyRequest == nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		err = conn.Connection.Modify(modifyRequest)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) && attempt < uacModifyAttempts {
+			continue
+		}
+
+		return accountModifyError(userDN, err)
+	}
+}
+
+// modifyAccountOpenLDAP - read openLdap account state attrs and apply modify request made from them
+// (nil request - nothing to change)
+func (conn *LdapConn) modifyAccountOpenLDAP(ctx context.Context, userDN string,
+	makeRequest func(entry *ldap.Entry) *ldap.ModifyRequest) error {
+
+	if err := validateDN(userDN); err != nil {
+		return err
+	}
+	userDN, err := conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return err
+	}
+
+	entry, err := conn.readEntry(ctx, userDN, []string{"pwdAccountLockedTime", "pwdFailureTime"})
+	if err != nil {
+		return err
+	}
+
+	modifyRequest := makeRequest(entry)
+	if modifyRequest == nil {
+		return nil
+	}
+
+	return conn.modifyAccount(ctx, modifyRequest)
+}
+
+// modifyAccount - run account modify request
+func (conn *LdapConn) modifyAccount(ctx context.Context, modifyRequest *ldap.ModifyRequest) error {
+	var err error
+	if modifyRequest.DN, err = conn.resolveDN(ctx, modifyRequest.DN); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return accountModifyError(modifyRequest.DN, conn.Connection.Modify(modifyRequest))
+}
+
+// accountModifyError - map account modify error (ErrNotFound for no such object)
+func accountModifyError(userDN string, err error) error {
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return fmt.Errorf("%w: %s", ErrNotFound, userDN)
+	}
+	if err != nil {
+		return fmt.Errorf("bad modify: %s", err.Error())
+	}
+
+	return nil
+}
+
+// uacModifyRequest - make userAccountControl modify request with set and cleared flags (nil if nothing changes):
+// current value delete and new value add, so request fails if current value is already changed
+func uacModifyRequest(userDN, current string, set, clear uint32) (*ldap.ModifyRequest, error) {
+	uac, err := strconv.ParseUint(current, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q has no valid userAccountControl", ErrInvalidInput, userDN)
+	}
+
+	flags := (uint32(uac) | set) &^ clear
+	if flags == uint32(uac) {
+		return nil, nil
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Delete("userAccountControl", []string{current})
+	modifyRequest.Add("userAccountControl", []string{strconv.FormatUint(uint64(flags), 10)})
+
+	return modifyRequest, nil
+}
+
+// shadowDays - days since 1970-01-01 (shadowExpire value) for time
+func shadowDays(t time.Time) int64 {
+	return t.Unix() / (24 * 60 * 60)
+}
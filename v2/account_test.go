@@ -0,0 +1,276 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
+)
+
+func TestAccountStatusAD(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	past := codec.FormatFileTime(now.AddDate(0, -1, 0))
+	future := codec.FormatFileTime(now.AddDate(0, 1, 0))
+
+	tests := []struct {
+		name     string
+		attrs    map[string][]string
+		expected AccountStatus
+	}{
+		{
+			name:     "normal",
+			attrs:    map[string][]string{"userAccountControl": {"512"}, "accountExpires": {"9223372036854775807"}},
+			expected: AccountStatus{Enabled: true, Flags: 512},
+		},
+		{
+			name:     "disabled, password never expires",
+			attrs:    map[string][]string{"userAccountControl": {"66050"}, "accountExpires": {"0"}},
+			expected: AccountStatus{PasswordNeverExpires: true, Flags: 66050},
+		},
+		{
+			name: "locked by computed flags",
+			attrs: map[string][]string{
+				"userAccountControl":                 {"512"},
+				"msDS-User-Account-Control-Computed": {"16"},
+			},
+			expected: AccountStatus{Enabled: true, Locked: true, Flags: 512},
+		},
+		{
+			name: "must change password",
+			attrs: map[string][]string{
+				"userAccountControl": {"512"},
+				"pwdLastSet":         {"0"},
+			},
+			expected: AccountStatus{Enabled: true, MustChangePassword: true, Flags: 512},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := ldap.NewEntry("CN=test,DC=test,DC=ru", tt.attrs)
+			require.Equal(t, tt.expected, accountStatusAD(entry, now))
+		})
+	}
+
+	expired := accountStatusAD(ldap.NewEntry("CN=test,DC=test,DC=ru", map[string][]string{
+		"userAccountControl": {"512"}, "accountExpires": {past},
+	}), now)
+	require.True(t, expired.Expired)
+	require.Equal(t, now.AddDate(0, -1, 0), *expired.AccountExpires)
+
+	notExpired := accountStatusAD(ldap.NewEntry("CN=test,DC=test,DC=ru", map[string][]string{
+		"userAccountControl": {"512"}, "accountExpires": {future},
+	}), now)
+	require.False(t, notExpired.Expired)
+}
+
+func TestAccountStatusOpenLDAP(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		attrs    map[string][]string
+		expected AccountStatus
+	}{
+		{
+			name:     "normal",
+			attrs:    map[string][]string{"shadowExpire": {"-1"}},
+			expected: AccountStatus{Enabled: true},
+		},
+		{
+			name:     "disabled",
+			attrs:    map[string][]string{"pwdAccountLockedTime": {ppolicyDisabledTime}},
+			expected: AccountStatus{},
+		},
+		{
+			name:     "must change password",
+			attrs:    map[string][]string{"pwdReset": {"TRUE"}},
+			expected: AccountStatus{Enabled: true, MustChangePassword: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := ldap.NewEntry("cn=test,dc=test,dc=ru", tt.attrs)
+			require.Equal(t, tt.expected, accountStatusOpenLDAP(entry, now))
+		})
+	}
+
+	locked := accountStatusOpenLDAP(ldap.NewEntry("cn=test,dc=test,dc=ru", map[string][]string{
+		"pwdAccountLockedTime": {"20240531120000Z"},
+		"shadowExpire":         {"19000"}, // 2022-01-08
+	}), now)
+	require.True(t, locked.Enabled)
+	require.True(t, locked.Locked)
+	require.Equal(t, time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), *locked.LockoutTime)
+	require.True(t, locked.Expired)
+}
+
+func TestGroupUsersOptionsFilterAD(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	require.Equal(t, filterUserAD, GroupUsersOptions{}.userFilterAD(now))
+
+	for _, opts := range []GroupUsersOptions{
+		{ExcludeDisabled: true},
+		{ExcludeExpired: true},
+		{ExcludeDisabled: true, ExcludeExpired: true},
+	} {
+		_, err := ldap.CompileFilter(opts.userFilterAD(now))
+		require.NoError(t, err, opts)
+	}
+}
+
+func TestUACModifyRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		set      uint32
+		clear    uint32
+		expected string // "" - no request
+		mustFail bool
+	}{
+		{name: "disable", current: "66048", set: UACAccountDisable, expected: "66050"},
+		{name: "already disabled", current: "66050", set: UACAccountDisable},
+		{name: "enable keeps other flags", current: "328194", clear: UACAccountDisable, expected: "328192"},
+		{name: "already enabled", current: "512", clear: UACAccountDisable},
+		{name: "no uac", current: "", set: UACAccountDisable, mustFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := uacModifyRequest("CN=user,DC=test,DC=ru", tt.current, tt.set, tt.clear)
+			if tt.mustFail {
+				require.ErrorIs(t, err, ErrInvalidInput)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expected == "" {
+				require.Nil(t, req)
+				return
+			}
+			// old value delete fails request if it is changed concurrently
+			require.Len(t, req.Changes, 2)
+			require.Equal(t, uint(ldap.DeleteAttribute), req.Changes[0].Operation)
+			require.Equal(t, "userAccountControl", req.Changes[0].Modification.Type)
+			require.Equal(t, []string{tt.current}, req.Changes[0].Modification.Vals)
+			require.Equal(t, uint(ldap.AddAttribute), req.Changes[1].Operation)
+			require.Equal(t, "userAccountControl", req.Changes[1].Modification.Type)
+			require.Equal(t, []string{tt.expected}, req.Changes[1].Modification.Vals)
+		})
+	}
+}
+
+func TestShadowDays(t *testing.T) {
+	require.Equal(t, int64(19000), shadowDays(time.Date(2022, 1, 8, 15, 0, 0, 0, time.UTC)))
+
+	// shadowExpire round trip (accountStatusOpenLDAP decoding)
+	status := accountStatusOpenLDAP(ldap.NewEntry("cn=test,dc=test,dc=ru", map[string][]string{
+		"shadowExpire": {"19000"},
+	}), time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Equal(t, time.Date(2022, 1, 8, 0, 0, 0, 0, time.UTC), *status.AccountExpires)
+	require.False(t, status.Expired)
+}
+
+func TestAccountOperationsValidation(t *testing.T) {
+	ctx := context.Background()
+
+	for _, options := range []LdapConnOptions{{}, {OpenLDAP: true}} {
+		conn, srv := newTestConn(t, options, func(req testRequest) []testMessage { return nil })
+		require.ErrorIs(t, conn.DisableAccount(ctx, "bad dn"), ErrInvalidInput)
+		require.ErrorIs(t, conn.EnableAccount(ctx, ""), ErrInvalidInput)
+		require.ErrorIs(t, conn.UnlockAccount(ctx, "bad dn"), ErrInvalidInput)
+		require.ErrorIs(t, conn.SetAccountExpiry(ctx, "bad dn", time.Time{}), ErrInvalidInput)
+		require.Empty(t, srv.Requests())
+	}
+}
+
+func TestAccountRequestsAD(t *testing.T) {
+	ctx := context.Background()
+	const readUAC = "search CN=user,DC=test,DC=ru (objectClass=*) [userAccountControl]"
+
+	// fake uac storage, concurrent changes are made right after reads
+	newUACServer := func(uac string, concurrent ...string) testHandler {
+		return func(req testRequest) []testMessage {
+			text := describeRequest(req.Op)
+			switch {
+			case text == readUAC:
+				res := []testMessage{entryMessage("CN=user,DC=test,DC=ru", map[string][]string{
+					"userAccountControl": {uac},
+				}), searchDone(ldap.LDAPResultSuccess)}
+				if len(concurrent) > 0 {
+					uac, concurrent = concurrent[0], concurrent[1:]
+				}
+				return res
+			case strings.HasPrefix(text, "modify CN=user,DC=test,DC=ru: delete userAccountControl"):
+				// changes: delete, add; change is {operation, {type, {values}}}
+				change := req.Op.Children[1].Children
+				if packetString(change[0].Children[1].Children[1].Children[0]) != uac {
+					return []testMessage{resultMessage(ldap.ApplicationModifyResponse, ldap.LDAPResultNoSuchAttribute)}
+				}
+				uac = packetString(change[1].Children[1].Children[1].Children[0])
+			case text == "modify CN=gone,DC=test,DC=ru: replace lockoutTime [0]":
+				return []testMessage{resultMessage(ldap.ApplicationModifyResponse, ldap.LDAPResultNoSuchObject)}
+			}
+			return nil
+		}
+	}
+
+	conn, srv := newTestConn(t, LdapConnOptions{}, newUACServer("512", "66048"))
+	require.NoError(t, conn.DisableAccount(ctx, "CN=user,DC=test,DC=ru"))
+	// already disabled, nothing to modify
+	require.NoError(t, conn.DisableAccount(ctx, "CN=user,DC=test,DC=ru"))
+	require.NoError(t, conn.UnlockAccount(ctx, "CN=user,DC=test,DC=ru"))
+	require.ErrorIs(t, conn.UnlockAccount(ctx, "CN=gone,DC=test,DC=ru"), ErrNotFound)
+	require.NoError(t, conn.SetAccountExpiry(ctx, "CN=user,DC=test,DC=ru", time.Time{}))
+	require.Equal(t, []string{
+		readUAC,
+		"modify CN=user,DC=test,DC=ru: delete userAccountControl [512]; add userAccountControl [514]",
+		readUAC,
+		"modify CN=user,DC=test,DC=ru: delete userAccountControl [66048]; add userAccountControl [66050]",
+		readUAC,
+		"modify CN=user,DC=test,DC=ru: replace lockoutTime [0]",
+		"modify CN=gone,DC=test,DC=ru: replace lockoutTime [0]",
+		"modify CN=user,DC=test,DC=ru: replace accountExpires [9223372036854775807]",
+	}, srv.Requests())
+
+	// value is changed concurrently every time: no endless retries
+	conn, srv = newTestConn(t, LdapConnOptions{}, newUACServer("512", "66048", "512", "66048"))
+	require.Error(t, conn.DisableAccount(ctx, "CN=user,DC=test,DC=ru"))
+	require.Len(t, srv.Requests(), 2*uacModifyAttempts)
+}
+
+func TestAccountRequestsOpenLDAP(t *testing.T) {
+	ctx := context.Background()
+	const readState = "search uid=user,dc=test,dc=ru (objectClass=*) [pwdAccountLockedTime pwdFailureTime]"
+
+	conn, srv := newTestConn(t, LdapConnOptions{OpenLDAP: true}, func(req testRequest) []testMessage {
+		if describeRequest(req.Op) == readState {
+			return []testMessage{entryMessage("uid=user,dc=test,dc=ru", map[string][]string{
+				"pwdAccountLockedTime": {"20240601000000Z"},
+				"pwdFailureTime":       {"20240531230000Z", "20240531235900Z"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		}
+		return nil
+	})
+
+	require.NoError(t, conn.DisableAccount(ctx, "uid=user,dc=test,dc=ru"))
+	// not disabled, nothing to enable
+	require.NoError(t, conn.EnableAccount(ctx, "uid=user,dc=test,dc=ru"))
+	require.NoError(t, conn.UnlockAccount(ctx, "uid=user,dc=test,dc=ru"))
+	require.NoError(t, conn.SetAccountExpiry(ctx, "uid=user,dc=test,dc=ru", time.Date(2022, 1, 8, 15, 0, 0, 0, time.UTC)))
+	require.Equal(t, []string{
+		readState,
+		"modify uid=user,dc=test,dc=ru: replace pwdAccountLockedTime [" + ppolicyDisabledTime + "]",
+		readState,
+		readState,
+		"modify uid=user,dc=test,dc=ru: delete pwdAccountLockedTime []; delete pwdFailureTime []",
+		"modify uid=user,dc=test,dc=ru: replace shadowExpire [19000]",
+	}, srv.Requests())
+}
@@ -0,0 +1,386 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+////////////////////////////////////////////// Cache struct
+
+// Cache Caching wrapper of conn lookups: per kind ttls, negative caching of not found results,
+// LRU eviction and de-duplication of concurrent identical lookups. Safe for concurrent use
+type Cache struct {
+	conn *LdapConn
+	opts CacheOptions
+	now  func() time.Time
+
+	mu    sync.Mutex
+	items map[string]*list.Element // cacheItem by key
+	lru   *list.List               // most recently used first
+	calls map[string]*cacheCall    // in-flight lookups by key
+	gen   uint64                   // invalidations counter
+	stats CacheStats
+}
+
+// cacheItem - cached lookup result with invalidation tags (dn keys and guids)
+type cacheItem struct {
+	key      string
+	value    any
+	err      error // ErrNotFound for negative results
+	tags     []string
+	expires  time.Time
+	notFound bool
+}
+
+// cacheCall - in-flight lookup, waiters get its result when done is closed
+type cacheCall struct {
+	done  chan struct{}
+	value any
+	err   error
+	gen   uint64 // invalidations counter at lookup start
+}
+
+// NewCache Create cache of conn lookups
+func NewCache(conn *LdapConn, opts ...CacheOptions) *Cache {
+	var cacheOpts CacheOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		cacheOpts = opts[0]
+	}
+
+	if cacheOpts.MaxEntries <= 0 {
+		cacheOpts.MaxEntries = defaultCacheMaxEntries
+	}
+	if cacheOpts.UserTTL <= 0 {
+		cacheOpts.UserTTL = defaultCacheUserTTL
+	}
+	if cacheOpts.GroupTTL <= 0 {
+		cacheOpts.GroupTTL = defaultCacheGroupTTL
+	}
+	if cacheOpts.NegativeTTL <= 0 {
+		cacheOpts.NegativeTTL = defaultCacheNegativeTTL
+	}
+	if cacheOpts.LoadTimeout <= 0 {
+		cacheOpts.LoadTimeout = defaultCacheLoadTimeout
+	}
+
+	return &Cache{
+		conn:  conn,
+		opts:  cacheOpts,
+		now:   time.Now,
+		items: make(map[string]*list.Element),
+		lru:   list.New(),
+		calls: make(map[string]*cacheCall),
+	}
+}
+
+////////////////////////////////////////////// Cached lookups
+
+// GetUserInfo Cached LdapConn.GetUserInfo (not found users, returned with empty dn, are cached with NegativeTTL)
+func (c *Cache) GetUserInfo(userName, baseDn string) (UserFullInfo, error) {
+	res, err := cacheGet(context.Background(), c, cacheKey("userInfo", baseDn, userName), c.opts.UserTTL,
+		func(context.Context) (UserFullInfo, error) {
+			res, err := c.conn.GetUserInfo(userName, baseDn)
+			if err == nil && res.DName == "" {
+				return res, fmt.Errorf("%w: %s", ErrNotFound, userName)
+			}
+			return res, err
+		},
+		func(u UserFullInfo) []string { return []string{dnKey(u.DName), guidTag(u.GUID)} },
+		[]string{dnKey(baseDn)},
+	)
+	if errors.Is(err, ErrNotFound) {
+		// GetUserInfo returns not found users without error
+		return res, nil
+	}
+	return res, err
+}
+
+// GetByGUID Cached LdapConn.GetByGUID
+func (c *Cache) GetByGUID(ctx context.Context, baseDn, guid string) (UserFullInfo, error) {
+	return cacheGet(ctx, c, cacheKey("guid", baseDn, guid), c.opts.UserTTL,
+		func(ctx context.Context) (UserFullInfo, error) { return c.conn.GetByGUID(ctx, baseDn, guid) },
+		func(u UserFullInfo) []string { return []string{dnKey(u.DName), guidTag(u.GUID)} },
+		[]string{dnKey(baseDn), guidTag(guid)},
+	)
+}
+
+// GetBySID Cached LdapConn.GetBySID
+func (c *Cache) GetBySID(ctx context.Context, baseDn, sid string) (UserFullInfo, error) {
+	return cacheGet(ctx, c, cacheKey("sid", baseDn, sid), c.opts.UserTTL,
+		func(ctx context.Context) (UserFullInfo, error) { return c.conn.GetBySID(ctx, baseDn, sid) },
+		func(u UserFullInfo) []string { return []string{dnKey(u.DName), guidTag(u.GUID)} },
+		[]string{dnKey(baseDn)},
+	)
+}
+
+// GetGroupUsers Cached LdapConn.GetGroupUsers
+func (c *Cache) GetGroupUsers(group string, opts ...GroupUsersOptions) ([]UserShortInfo, error) {
+	usersOpts := getGroupUsersOptions(opts)
+	key := cacheKey("groupUsers", group, fmt.Sprintf("%+v", usersOpts))
+	res, err := cacheGet(context.Background(), c, key, c.opts.GroupTTL,
+		func(context.Context) ([]UserShortInfo, error) { return c.conn.GetGroupUsers(group, usersOpts) },
+		func(users []UserShortInfo) []string { return usersTags(group, users) },
+		[]string{dnKey(group)},
+	)
+	return slices.Clone(res), err
+}
+
+// GetGroupMembers Cached LdapConn.GetGroupMembers
+func (c *Cache) GetGroupMembers(ctx context.Context, groupDN string, recursive bool) ([]UserShortInfo, error) {
+	res, err := cacheGet(ctx, c, cacheKey("groupMembers", groupDN, fmt.Sprint(recursive)), c.opts.GroupTTL,
+		func(ctx context.Context) ([]UserShortInfo, error) {
+			return c.conn.GetGroupMembers(ctx, groupDN, recursive)
+		},
+		func(users []UserShortInfo) []string { return usersTags(groupDN, users) },
+		[]string{dnKey(groupDN)},
+	)
+	return slices.Clone(res), err
+}
+
+// GetUserGroups Cached LdapConn.GetUserGroups
+func (c *Cache) GetUserGroups(ctx context.Context, userDN string, opts ...UserGroupsOptions) (UserGroups, error) {
+	var groupsOpts UserGroupsOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		groupsOpts = opts[0]
+	}
+
+	res, err := cacheGet(ctx, c, cacheKey("userGroups", userDN, fmt.Sprintf("%+v", groupsOpts)), c.opts.GroupTTL,
+		func(ctx context.Context) (UserGroups, error) { return c.conn.GetUserGroups(ctx, userDN, groupsOpts) },
+		func(g UserGroups) []string {
+			tags := []string{dnKey(userDN)}
+			for _, group := range g.All {
+				tags = append(tags, dnKey(group.DName), guidTag(group.GUID))
+			}
+			return tags
+		},
+		[]string{dnKey(userDN)},
+	)
+	res.Direct, res.All = slices.Clone(res.Direct), slices.Clone(res.All)
+	return res, err
+}
+
+////////////////////////////////////////////// Invalidation
+
+// Invalidate Drop cached results related to dns: results of these objects, of objects under them
+// (e.g. users of renamed ou), of their parents (e.g. ou users) and not found results under parents
+func (c *Cache) Invalidate(dns ...string) {
+	keys := make([]string, 0, len(dns))
+	for _, dn := range dns {
+		if dn != "" {
+			keys = append(keys, dnKey(dn))
+		}
+	}
+
+	c.invalidate(func(tag string) bool {
+		for _, key := range keys {
+			if tag == key || strings.HasSuffix(key, ","+tag) || strings.HasSuffix(tag, ","+key) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// InvalidateGUID Drop cached results related to objects with guids (AD objectGUID, openLdap entryUUID)
+func (c *Cache) InvalidateGUID(guids ...string) {
+	tags := make([]string, 0, len(guids))
+	for _, guid := range guids {
+		if guid != "" {
+			tags = append(tags, guidTag(guid))
+		}
+	}
+
+	c.invalidate(func(tag string) bool {
+		return slices.Contains(tags, tag)
+	})
+}
+
+// InvalidateAll Drop all cached results
+func (c *Cache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.lru.Init()
+	c.dropCalls()
+}
+
+// InvalidateChanges Drop cached results related to ChangeTracker changes
+func (c *Cache) InvalidateChanges(changes ChangeSet) {
+	dns := make([]string, 0, len(changes.Users)+len(changes.Groups))
+	guids := make([]string, 0, len(changes.Users)+len(changes.Groups))
+	for _, u := range changes.Users {
+		dns, guids = append(dns, u.DName), append(guids, u.GUID)
+	}
+	for _, g := range changes.Groups {
+		dns, guids = append(dns, g.DName), append(guids, g.GUID)
+	}
+
+	c.Invalidate(dns...)
+	c.InvalidateGUID(guids...)
+}
+
+// InvalidateWatch Drop cached results related to Watch events until events channel is closed
+// (run it in own goroutine), all results are dropped on WatchError as changes may be missed
+// and on WatchPresent as deleted entries are not listed
+func (c *Cache) InvalidateWatch(events <-chan WatchEvent) {
+	for e := range events {
+		// after error changes can be missed, after present phase deleted entries are unknown
+		if e.Type == WatchError || e.Type == WatchPresent {
+			c.InvalidateAll()
+			continue
+		}
+		c.Invalidate(e.DN, e.OldDN)
+		c.InvalidateGUID(e.UUID)
+	}
+}
+
+// Stats Cache hit/miss statistics
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := c.stats
+	res.Entries = c.lru.Len()
+	return res
+}
+
+// invalidate - drop items with any tag matching
+func (c *Cache) invalidate(match func(tag string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if slices.ContainsFunc(el.Value.(*cacheItem).tags, match) {
+			c.lru.Remove(el)
+			delete(c.items, key)
+		}
+	}
+	c.dropCalls()
+}
+
+// dropCalls - forget in-flight lookups on invalidation (c.mu is locked): their results may be read
+// before the change, so they are not stored and new lookups don't join them
+func (c *Cache) dropCalls() {
+	c.gen++
+	clear(c.calls)
+}
+
+////////////////////////////////////////////// Cache internals
+
+// cacheGet - get result from cache or load it (once for concurrent identical lookups).
+// Successful results are tagged by tags func, not found ones (ErrNotFound) by notFoundTags,
+// other errors are not cached. Lookup isn't bound to ctx of any caller (it runs with LoadTimeout),
+// every caller stops waiting on its own ctx cancel
+func cacheGet[T any](ctx context.Context, c *Cache, key string, ttl time.Duration,
+	load func(ctx context.Context) (T, error), tags func(T) []string, notFoundTags []string) (T, error) {
+
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		item := el.Value.(*cacheItem)
+		if c.now().Before(item.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			if item.notFound {
+				c.stats.NegativeHits++
+			}
+			c.mu.Unlock()
+			return item.value.(T), item.err
+		}
+		c.lru.Remove(el)
+		delete(c.items, key)
+	}
+
+	if call, ok := c.calls[key]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		return waitCacheCall[T](ctx, call)
+	}
+
+	call := &cacheCall{done: make(chan struct{}), gen: c.gen}
+	c.calls[key] = call
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	go func() {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.opts.LoadTimeout)
+		defer cancel()
+
+		value, err := load(loadCtx)
+		call.value, call.err = value, err
+
+		c.mu.Lock()
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+		switch {
+		case c.gen != call.gen:
+			// invalidated during lookup, result may be read before the change
+		case err == nil:
+			c.store(&cacheItem{key: key, value: value, tags: tags(value), expires: c.now().Add(ttl)})
+		case errors.Is(err, ErrNotFound):
+			c.store(&cacheItem{key: key, value: value, err: err, tags: notFoundTags,
+				expires: c.now().Add(c.opts.NegativeTTL), notFound: true})
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	return waitCacheCall[T](ctx, call)
+}
+
+// waitCacheCall - wait for in-flight lookup result or caller ctx cancel
+func waitCacheCall[T any](ctx context.Context, call *cacheCall) (T, error) {
+	select {
+	case <-call.done:
+		return call.value.(T), call.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// store - add item as most recently used, evict least recently used items over MaxEntries (c.mu is locked)
+func (c *Cache) store(item *cacheItem) {
+	item.tags = slices.DeleteFunc(item.tags, func(tag string) bool { return tag == "" })
+	c.items[item.key] = c.lru.PushFront(item)
+
+	for c.lru.Len() > c.opts.MaxEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*cacheItem).key)
+		c.stats.Evictions++
+	}
+}
+
+// cacheKey - lookup key of kind and params
+func cacheKey(kind string, params ...string) string {
+	return kind + "\x00" + strings.Join(params, "\x00")
+}
+
+// guidTag - invalidation tag of guid
+func guidTag(guid string) string {
+	if guid == "" {
+		return ""
+	}
+	return "guid:" + strings.ToLower(guid)
+}
+
+// usersTags - invalidation tags of users list of group
+func usersTags(group string, users []UserShortInfo) []string {
+	tags := make([]string, 0, 2*len(users)+1)
+	tags = append(tags, dnKey(group))
+	for _, u := range users {
+		tags = append(tags, dnKey(u.DName), guidTag(u.GUID))
+	}
+	return tags
+}
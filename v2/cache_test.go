@@ -0,0 +1,262 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCacheGet - cached lookup of user by dn with counted loads
+func testCacheGet(c *Cache, dn string, loads *int, err error) (UserFullInfo, error) {
+	return cacheGet(context.Background(), c, cacheKey("test", dn), time.Minute,
+		func(context.Context) (UserFullInfo, error) {
+			*loads++
+			return UserFullInfo{DName: dn}, err
+		},
+		func(u UserFullInfo) []string { return []string{dnKey(u.DName), guidTag(u.GUID)} },
+		[]string{dnKey("ou=people,dc=test")},
+	)
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	c := NewCache(nil, CacheOptions{NegativeTTL: 10 * time.Second})
+	c.now = func() time.Time { return now }
+	loads := 0
+
+	for range 3 {
+		res, err := testCacheGet(c, "cn=a,ou=people,dc=test", &loads, nil)
+		require.NoError(t, err)
+		require.Equal(t, "cn=a,ou=people,dc=test", res.DName)
+	}
+	require.Equal(t, 1, loads)
+
+	now = now.Add(2 * time.Minute)
+	_, _ = testCacheGet(c, "cn=a,ou=people,dc=test", &loads, nil)
+	require.Equal(t, 2, loads)
+
+	// negative results are cached with NegativeTTL, other errors are not cached
+	notFound := fmt.Errorf("%w: cn=b", ErrNotFound)
+	for range 2 {
+		_, err := testCacheGet(c, "cn=b,ou=people,dc=test", &loads, notFound)
+		require.ErrorIs(t, err, ErrNotFound)
+	}
+	require.Equal(t, 3, loads)
+	now = now.Add(11 * time.Second)
+	_, _ = testCacheGet(c, "cn=b,ou=people,dc=test", &loads, notFound)
+	require.Equal(t, 4, loads)
+
+	for range 2 {
+		_, err := testCacheGet(c, "cn=c,ou=people,dc=test", &loads, errors.New("network"))
+		require.Error(t, err)
+	}
+	require.Equal(t, 6, loads)
+
+	require.Equal(t, CacheStats{Hits: 3, NegativeHits: 1, Misses: 6, Entries: 2}, c.Stats())
+}
+
+func TestCacheLRU(t *testing.T) {
+	c := NewCache(nil, CacheOptions{MaxEntries: 2})
+	loads := 0
+
+	_, _ = testCacheGet(c, "cn=a,dc=test", &loads, nil)
+	_, _ = testCacheGet(c, "cn=b,dc=test", &loads, nil)
+	_, _ = testCacheGet(c, "cn=a,dc=test", &loads, nil) // a is recently used
+	_, _ = testCacheGet(c, "cn=c,dc=test", &loads, nil) // b is evicted
+	require.Equal(t, 3, loads)
+
+	_, _ = testCacheGet(c, "cn=a,dc=test", &loads, nil)
+	require.Equal(t, 3, loads)
+	_, _ = testCacheGet(c, "cn=b,dc=test", &loads, nil)
+	require.Equal(t, 4, loads)
+
+	stats := c.Stats()
+	require.Equal(t, uint64(2), stats.Evictions)
+	require.Equal(t, 2, stats.Entries)
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	c := NewCache(nil)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var loads int
+
+	load := func(context.Context) ([]UserShortInfo, error) {
+		loads++
+		close(started)
+		<-release
+		return []UserShortInfo{{Login: "a"}}, nil
+	}
+	get := func() ([]UserShortInfo, error) {
+		return cacheGet(context.Background(), c, "key", time.Minute, load,
+			func([]UserShortInfo) []string { return nil }, nil)
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]UserShortInfo, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = get()
+	}()
+	<-started
+
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = get()
+		}()
+	}
+	require.Eventually(t, func() bool { return c.Stats().Shared == 4 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, 1, loads)
+	for _, res := range results {
+		require.Equal(t, []UserShortInfo{{Login: "a"}}, res)
+	}
+
+	// waiter stops on its context cancel
+	ctx, cancel := context.WithCancel(context.Background())
+	c.calls["other"] = &cacheCall{done: make(chan struct{})}
+	cancel()
+	_, err := cacheGet(ctx, c, "other", time.Minute, load, func([]UserShortInfo) []string { return nil }, nil)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := NewCache(nil)
+	loads := 0
+	fill := func() {
+		loads = 0
+		for _, dn := range []string{"cn=a,ou=people,dc=test", "cn=b,ou=people,dc=test", "cn=c,ou=it,dc=test"} {
+			_, _ = testCacheGet(c, dn, &loads, nil)
+		}
+		_, _ = testCacheGet(c, "cn=x,ou=people,dc=test", &loads, ErrNotFound)
+	}
+
+	fill()
+	require.Equal(t, 4, loads)
+
+	// object itself and negative results of its ou
+	c.Invalidate("CN=A,OU=People,DC=test")
+	require.Equal(t, 2, c.Stats().Entries)
+
+	// new user under ou drops negative results of ou only
+	fill()
+	require.Equal(t, 2, loads)
+	c.Invalidate("cn=new,ou=people,dc=test")
+	require.Equal(t, 3, c.Stats().Entries)
+
+	// renamed ou drops its users
+	fill()
+	c.Invalidate("ou=people,dc=test")
+	require.Equal(t, 1, c.Stats().Entries)
+
+	c.InvalidateAll()
+	require.Equal(t, 0, c.Stats().Entries)
+
+	_, _ = cacheGet(context.Background(), c, "guid", time.Minute,
+		func(context.Context) (UserFullInfo, error) { return UserFullInfo{GUID: "ABC"}, nil },
+		func(u UserFullInfo) []string { return []string{dnKey(u.DName), guidTag(u.GUID)} }, nil)
+	c.InvalidateGUID("abc")
+	require.Equal(t, 0, c.Stats().Entries)
+
+	fill()
+	events := make(chan WatchEvent, 2)
+	events <- WatchEvent{Type: WatchModDN, DN: "cn=d,ou=it,dc=test", OldDN: "cn=c,ou=it,dc=test"}
+	events <- WatchEvent{Type: WatchDelete, DN: "cn=b,ou=people,dc=test"}
+	close(events)
+	c.InvalidateWatch(events)
+	require.Equal(t, 1, c.Stats().Entries)
+
+	fill()
+	c.InvalidateChanges(ChangeSet{Users: []UserFullInfo{{DName: "cn=a,ou=people,dc=test"}}})
+	require.Equal(t, 2, c.Stats().Entries)
+}
+
+func TestCacheLoadContext(t *testing.T) {
+	c := NewCache(nil)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	loadErr := make(chan error, 1)
+
+	load := func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+		loadErr <- ctx.Err()
+		return "value", nil
+	}
+	noTags := func(string) []string { return nil }
+
+	// first caller cancels, shared lookup goes on for others
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cacheGet(first, c, "key", time.Minute, load, noTags, nil)
+		firstErr <- err
+	}()
+	<-started
+
+	other := make(chan string, 1)
+	go func() {
+		res, _ := cacheGet(context.Background(), c, "key", time.Minute, load, noTags, nil)
+		other <- res
+	}()
+	require.Eventually(t, func() bool { return c.Stats().Shared == 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-firstErr, context.Canceled)
+
+	close(release)
+	require.Equal(t, "value", <-other)
+	require.NoError(t, <-loadErr)
+	require.Equal(t, 1, c.Stats().Entries)
+}
+
+func TestCacheInvalidateDuringLoad(t *testing.T) {
+	c := NewCache(nil)
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	var loads atomic.Int32
+
+	load := func(context.Context) (UserFullInfo, error) {
+		n := loads.Add(1)
+		started <- struct{}{}
+		<-release
+		return UserFullInfo{DName: "cn=a,dc=test", Title: fmt.Sprint(n)}, nil
+	}
+	tags := func(u UserFullInfo) []string { return []string{dnKey(u.DName)} }
+	get := func(results chan<- UserFullInfo) {
+		res, _ := cacheGet(context.Background(), c, "key", time.Minute, load, tags, nil)
+		results <- res
+	}
+
+	stale := make(chan UserFullInfo, 1)
+	go get(stale)
+	<-started
+
+	// change event while lookup is in flight: new lookups don't join it, its result is not stored
+	c.Invalidate("cn=a,dc=test")
+	fresh := make(chan UserFullInfo, 1)
+	go get(fresh)
+	<-started
+
+	close(release)
+	require.Equal(t, "1", (<-stale).Title)
+	require.Equal(t, "2", (<-fresh).Title)
+	require.Equal(t, uint64(0), c.Stats().Shared)
+
+	res, err := cacheGet(context.Background(), c, "key", time.Minute, load, tags, nil)
+	require.NoError(t, err)
+	require.Equal(t, "2", res.Title)
+	require.Equal(t, int32(2), loads.Load())
+}
@@ -0,0 +1,246 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Change tracking
+
+// ChangeTracker Incremental reader of entries created or modified since previous run
+// (AD uSNChanged of DC, openLdap entryCSN or modifyTimestamp). Not safe for concurrent use
+type ChangeTracker struct {
+	conn      *LdapConn
+	baseDN    string
+	filter    string
+	watermark Watermark
+}
+
+// NewChangeTracker Create change tracker for baseDN subtree starting from ChangeTrackerOptions.Watermark
+func (conn *LdapConn) NewChangeTracker(baseDN string, opts ...ChangeTrackerOptions) (*ChangeTracker, error) {
+	var trackerOpts ChangeTrackerOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		trackerOpts = opts[0]
+	}
+
+	if err := validateDN(baseDN); err != nil {
+		return nil, err
+	}
+	filter := trackerOpts.Filter
+	if filter == "" {
+		userFilter := filterPersonAD
+		if conn.options.OpenLDAP {
+			userFilter = filterUserOpenLDAP
+		}
+		filter = "(|" + userFilter + groupKindFilters[GroupKindOU] + ")"
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return nil, fmt.Errorf("%w: bad filter %q: %s", ErrInvalidInput, filter, err.Error())
+	}
+
+	return &ChangeTracker{
+		conn:      conn,
+		baseDN:    baseDN,
+		filter:    filter,
+		watermark: trackerOpts.Watermark.clone(),
+	}, nil
+}
+
+// Watermark Current watermark (moved by every successful Changes call)
+func (t *ChangeTracker) Watermark() Watermark {
+	return t.watermark.clone()
+}
+
+// Changes Read entries created or modified since current watermark and move it.
+// Entries other than users, ous, containers and groups (e.g. computers matched by custom filter) are dropped.
+// AD USN is local to DC, so the first run on other DC (e.g. after failover) reads all entries.
+// OpenLdap watermark is suffix contextCSN (entries modifyTimestamp if there is no contextCSN,
+// it has seconds precision, so changes made in the same second after the search can be missed)
+func (t *ChangeTracker) Changes(ctx context.Context) (res ChangeSet, err error) {
+	res.Users = make([]UserFullInfo, 0)
+	res.Groups = make([]GroupInfo, 0)
+
+	baseDN, err := t.conn.resolveDN(ctx, t.baseDN)
+	if err != nil {
+		return res, err
+	}
+
+	wm := t.watermark.clone()
+	filter := t.filter
+
+	var attributes []string
+	var contextCSN string
+	if t.conn.options.OpenLDAP {
+		attributes = slices.Concat(openLDAPUserAttrs, openLDAPChangeAttrs)
+		if cond := wm.openLDAPFilter(); cond != "" {
+			filter = "(&" + filter + cond + ")"
+		}
+
+		// contextCSN is read before search: entries changed during search are read again next time
+		if contextCSN, err = t.conn.readContextCSN(ctx, baseDN); err != nil {
+			return res, err
+		}
+	} else {
+		attributes = slices.Concat(ADUserAttrs, ADChangeAttrs)
+
+		// USN is read before search: entries changed during search are read again next time
+		dc, usn, err := t.conn.readHighestUSN(ctx)
+		if err != nil {
+			return res, err
+		}
+		if last, ok := wm.USN[dc]; ok {
+			filter = "(&" + filter + fmt.Sprintf(filterUSNChangedAD, last+1) + ")"
+		}
+		wm.USN[dc] = usn
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	err = t.conn.searchPaged(ctx, searchRequest, func(entry *ldap.Entry) error {
+		if t.conn.options.OpenLDAP {
+			wm.advance(entry)
+		}
+
+		switch {
+		case isUserEntry(entry):
+			res.Users = append(res.Users, t.conn.userFullInfoFromEntry(entry))
+		case isGroupEntry(entry):
+			inf := t.conn.groupInfoFromEntry(entry)
+			if inf.DName == "" {
+				inf.DName = entry.DN
+			}
+			res.Groups = append(res.Groups, inf)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("bad search: %s", err.Error())
+	}
+	if contextCSN != "" {
+		// entries CSNs are newer than contextCSN for entries changed during search
+		wm.EntryCSN = max(t.watermark.EntryCSN, contextCSN)
+	}
+
+	t.watermark = wm
+	res.Watermark = wm.clone()
+
+	return res, nil
+}
+
+// readHighestUSN - read AD DC name and its highestCommittedUSN from rootDSE
+func (conn *LdapConn) readHighestUSN(ctx context.Context) (string, int64, error) {
+	entry, err := conn.readEntry(ctx, "", ADRootDSEUSNAttrs)
+	if err != nil {
+		return "", 0, err
+	}
+
+	dc := strings.ToLower(entry.GetAttributeValue("dnsHostName"))
+	usn, err := strconv.ParseInt(entry.GetAttributeValue("highestCommittedUSN"), 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad highestCommittedUSN of %q: %s", dc, err.Error())
+	}
+
+	return dc, usn, nil
+}
+
+// readContextCSN - read openLdap contextCSN of naming context containing dn (the newest one of its values,
+// empty if there is no such naming context or it has no contextCSN)
+func (conn *LdapConn) readContextCSN(ctx context.Context, dn string) (string, error) {
+	rootDSE, err := conn.readEntry(ctx, "", []string{"namingContexts"})
+	if err != nil {
+		return "", err
+	}
+
+	suffix := ""
+	for _, nc := range rootDSE.GetAttributeValues("namingContexts") {
+		if (isSameDN(dn, nc) || isSubDN(nc, dn)) && len(nc) > len(suffix) {
+			suffix = nc
+		}
+	}
+	if suffix == "" {
+		return "", nil
+	}
+
+	entry, err := conn.readEntry(ctx, suffix, []string{"contextCSN"})
+	if err != nil {
+		return "", err
+	}
+
+	// one value per provider server id, CSNs of the same time are ordered as strings
+	values := entry.GetAttributeValues("contextCSN")
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	return slices.Max(values), nil
+}
+
+// isUserEntry - check if entry is a user (not computer, ou or group)
+func isUserEntry(entry *ldap.Entry) bool {
+	for _, c := range entry.GetAttributeValues("objectClass") {
+		switch strings.ToLower(c) {
+		case "person", "user":
+			return memberKindOf(entry) == MemberKindUser
+		}
+	}
+	return false
+}
+
+// isGroupEntry - check if entry is an ou, container, domain or group (ChangeSet.Groups entry)
+func isGroupEntry(entry *ldap.Entry) bool {
+	for _, c := range entry.GetAttributeValues("objectClass") {
+		switch strings.ToLower(c) {
+		case "organizationalunit", "container", "builtindomain", "domaindns", "domain",
+			"group", "groupofnames", "groupofuniquenames", "posixgroup":
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////// Watermark
+
+// clone - deep copy of watermark (USN map is never nil)
+func (w Watermark) clone() Watermark {
+	res := w
+	res.USN = make(map[string]int64, len(w.USN))
+	maps.Copy(res.USN, w.USN)
+	return res
+}
+
+// openLDAPFilter - filter of entries changed after watermark (empty for the first run)
+func (w Watermark) openLDAPFilter() string {
+	switch {
+	case w.EntryCSN != "":
+		return fmt.Sprintf(filterEntryCSN, ldap.EscapeFilter(w.EntryCSN))
+	case w.ModifyTimestamp != "":
+		return fmt.Sprintf(filterModifyTimestamp, ldap.EscapeFilter(w.ModifyTimestamp))
+	default:
+		return ""
+	}
+}
+
+// advance - move openLdap watermark to entry change marks if they are newer
+func (w *Watermark) advance(entry *ldap.Entry) {
+	// both CSN and generalized time (of one server) are ordered as strings
+	if csn := entry.GetAttributeValue("entryCSN"); csn > w.EntryCSN {
+		w.EntryCSN = csn
+	}
+	if ts := entry.GetAttributeValue("modifyTimestamp"); ts > w.ModifyTimestamp {
+		w.ModifyTimestamp = ts
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChangeTracker(t *testing.T) {
+	conn := &LdapConn{}
+
+	_, err := conn.NewChangeTracker("bad dn")
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.NewChangeTracker("dc=test", ChangeTrackerOptions{Filter: "(cn=a"})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	wm := Watermark{USN: map[string]int64{"dc1.test": 100}}
+	tracker, err := conn.NewChangeTracker("dc=test", ChangeTrackerOptions{Watermark: wm})
+	require.NoError(t, err)
+	require.Equal(t, "(|(&(objectCategory=person)(objectClass=user))(objectClass=organizationalUnit))", tracker.filter)
+
+	// tracker keeps its own copy
+	wm.USN["dc1.test"] = 200
+	require.Equal(t, int64(100), tracker.Watermark().USN["dc1.test"])
+}
+
+func TestWatermark(t *testing.T) {
+	var wm Watermark
+	require.Equal(t, "", wm.openLDAPFilter())
+
+	wm.advance(ldap.NewEntry("cn=a,dc=test", map[string][]string{
+		"modifyTimestamp": {"20240131120000Z"},
+		"entryCSN":        {"20240131120000.000001Z#000000#000#000000"},
+	}))
+	wm.advance(ldap.NewEntry("cn=b,dc=test", map[string][]string{
+		"modifyTimestamp": {"20240130120000Z"},
+		"entryCSN":        {"20240130120000.000001Z#000000#000#000000"},
+	}))
+	require.Equal(t, "20240131120000Z", wm.ModifyTimestamp)
+	// entries of watermark itself were read by previous run
+	require.Equal(t, "(&(entryCSN>=20240131120000.000001Z#000000#000#000000)"+
+		"(!(entryCSN=20240131120000.000001Z#000000#000#000000)))", wm.openLDAPFilter())
+
+	wm.EntryCSN = ""
+	require.Equal(t, "(&(modifyTimestamp>=20240131120000Z)(!(modifyTimestamp=20240131120000Z)))", wm.openLDAPFilter())
+
+	data, err := json.Marshal(Watermark{USN: map[string]int64{"dc1.test": 12345}})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"usn":{"dc1.test":12345}}`, string(data))
+
+	var parsed Watermark
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	require.Equal(t, int64(12345), parsed.USN["dc1.test"])
+
+	require.NotNil(t, Watermark{}.clone().USN)
+}
+
+func TestIsUserEntry(t *testing.T) {
+	tests := []struct {
+		classes []string
+		want    bool
+	}{
+		{[]string{"top", "person", "organizationalPerson", "user"}, true},
+		{[]string{"top", "person", "organizationalPerson", "inetOrgPerson"}, true},
+		{[]string{"top", "person", "organizationalPerson", "user", "computer"}, false},
+		{[]string{"top", "organizationalUnit"}, false},
+		{[]string{"top", "group"}, false},
+	}
+
+	for _, tt := range tests {
+		entry := ldap.NewEntry("cn=a,dc=test", map[string][]string{"objectClass": tt.classes})
+		require.Equal(t, tt.want, isUserEntry(entry), tt.classes)
+	}
+
+	require.True(t, isGroupEntry(ldap.NewEntry("ou=a,dc=test", map[string][]string{"objectClass": {"top", "organizationalUnit"}})))
+	require.True(t, isGroupEntry(ldap.NewEntry("cn=a,dc=test", map[string][]string{"objectClass": {"top", "group"}})))
+	require.False(t, isGroupEntry(ldap.NewEntry("cn=a,dc=test", map[string][]string{"objectClass": {"top", "user", "computer"}})))
+}
+
+func TestChangesAD(t *testing.T) {
+	const guid = "<GUID=33221100-5544-7766-8899-aabbccddeeff>"
+	var filters []string
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		switch packetString(req.Op.Children[0]) {
+		case guid:
+			return []testMessage{entryMessage("DC=test,DC=ru", nil), searchDone(ldap.LDAPResultSuccess)}
+		case "":
+			return []testMessage{entryMessage("", map[string][]string{
+				"dnsHostName": {"DC1.test.ru"}, "highestCommittedUSN": {"200"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		}
+
+		filter, _ := ldap.DecompileFilter(req.Op.Children[6])
+		filters = append(filters, filter)
+		return []testMessage{
+			entryMessage("CN=Ivanov,DC=test,DC=ru", map[string][]string{
+				"objectClass": {"top", "person", "organizationalPerson", "user"}, "cn": {"Ivanov"},
+			}),
+			entryMessage("CN=WS01,DC=test,DC=ru", map[string][]string{
+				"objectClass": {"top", "person", "organizationalPerson", "user", "computer"}, "cn": {"WS01"},
+			}),
+			entryMessage("OU=IT,DC=test,DC=ru", map[string][]string{
+				"objectClass": {"top", "organizationalUnit"}, "ou": {"IT"}, "name": {"IT"},
+				"distinguishedName": {"OU=IT,DC=test,DC=ru"},
+			}),
+			searchDone(ldap.LDAPResultSuccess),
+		}
+	})
+
+	// custom filter matches computers, they are neither users nor groups
+	tracker, err := conn.NewChangeTracker(guid, ChangeTrackerOptions{
+		Filter:    "(|(objectClass=user)(objectClass=organizationalUnit))",
+		Watermark: Watermark{USN: map[string]int64{"dc1.test.ru": 100}},
+	})
+	require.NoError(t, err)
+	res, err := tracker.Changes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, res.Users, 1)
+	require.Equal(t, "CN=Ivanov,DC=test,DC=ru", res.Users[0].DName)
+	require.Len(t, res.Groups, 1)
+	require.Equal(t, "OU=IT,DC=test,DC=ru", res.Groups[0].DName)
+	require.Equal(t, int64(200), res.Watermark.USN["dc1.test.ru"])
+	require.Equal(t, []string{"(&(|(objectClass=user)(objectClass=organizationalUnit))(uSNChanged>=101))"}, filters)
+
+	// extended base dn is resolved every run, tracker keeps it
+	require.Equal(t, guid, tracker.baseDN)
+	_, err = tracker.Changes(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "(&(|(objectClass=user)(objectClass=organizationalUnit))(uSNChanged>=201))", filters[1])
+	require.Len(t, srv.Requests(), 6)
+}
+
+func TestChangesOpenLDAP(t *testing.T) {
+	contextCSN := []string{"20240131120000.000001Z#000000#001#000000", "20240131130000.000001Z#000000#002#000000"}
+	var filters []string
+	conn, _ := newTestConn(t, LdapConnOptions{OpenLDAP: true}, func(req testRequest) []testMessage {
+		switch packetString(req.Op.Children[0]) {
+		case "":
+			return []testMessage{entryMessage("", map[string][]string{
+				"namingContexts": {"dc=other", "dc=test,dc=ru"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "dc=test,dc=ru":
+			return []testMessage{entryMessage("dc=test,dc=ru", map[string][]string{
+				"contextCSN": contextCSN,
+			}), searchDone(ldap.LDAPResultSuccess)}
+		}
+
+		filter, _ := ldap.DecompileFilter(req.Op.Children[6])
+		filters = append(filters, filter)
+		// entry is changed during search, after contextCSN is read
+		return []testMessage{
+			entryMessage("uid=ivanov,ou=IT,dc=test,dc=ru", map[string][]string{
+				"objectClass": {"top", "person", "inetOrgPerson"}, "uid": {"ivanov"},
+				"entryCSN":        {"20240131140000.000001Z#000000#001#000000"},
+				"modifyTimestamp": {"20240131140000Z"},
+			}),
+			searchDone(ldap.LDAPResultSuccess),
+		}
+	})
+
+	tracker, err := conn.NewChangeTracker("ou=IT,dc=test,dc=ru")
+	require.NoError(t, err)
+	res, err := tracker.Changes(context.Background())
+	require.NoError(t, err)
+	require.Len(t, res.Users, 1)
+	require.Equal(t, contextCSN[1], res.Watermark.EntryCSN)
+	require.Equal(t, "20240131140000Z", res.Watermark.ModifyTimestamp)
+	require.Equal(t, "(|(&(objectClass=person))(objectClass=organizationalUnit))", filters[0])
+
+	_, err = tracker.Changes(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "(&(|(&(objectClass=person))(objectClass=organizationalUnit))"+
+		"(&(entryCSN>="+contextCSN[1]+")(!(entryCSN="+contextCSN[1]+"))))", filters[1])
+}
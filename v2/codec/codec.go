@@ -0,0 +1,20 @@
+// Copyright 2020-2024 NGR Softlab
+
+// Package codec Converters for AD/LDAP attribute values (FILETIME, GeneralizedTime, objectSid, objectGUID)
+// to Go types and back (including search filter values).
+package codec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EscapeFilterBytes Escape binary value for search filter (every byte as \xx)
+func EscapeFilterBytes(value []byte) string {
+	var b strings.Builder
+	b.Grow(len(value) * 3)
+	for _, c := range value {
+		fmt.Fprintf(&b, "\\%02x", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,151 @@
+// Copyright 2020-2024 NGR Softlab
+package codec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		mustFail bool
+	}{
+		{name: "unix epoch", value: "116444736000000000", expected: time.Unix(0, 0).UTC()},
+		{name: "date", value: "133511328000000000", expected: time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{name: "sub second", value: "133511328000000001", expected: time.Date(2024, 1, 31, 0, 0, 0, 100, time.UTC)},
+		{name: "never (0)", value: "0", expected: time.Time{}},
+		{name: "never (max)", value: "9223372036854775807", expected: time.Time{}},
+		{name: "bad value", value: "yesterday", mustFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseFileTime(tt.value)
+			if tt.mustFail {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, tt.expected.Equal(res), res)
+
+			if tt.expected.IsZero() {
+				require.Equal(t, "9223372036854775807", FormatFileTime(res))
+			} else {
+				require.Equal(t, tt.value, FormatFileTime(res))
+			}
+		})
+	}
+
+	// far dates must not overflow
+	far := time.Date(9000, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.True(t, far.Equal(FileTimeToTime(TimeToFileTime(far))))
+}
+
+func TestGeneralizedTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		mustFail bool
+	}{
+		{name: "seconds", value: "20240131120000Z", expected: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{name: "ad fraction", value: "20240131120000.0Z", expected: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{name: "comma fraction", value: "20240131120000,5Z", expected: time.Date(2024, 1, 31, 12, 0, 0, 5e8, time.UTC)},
+		{name: "minutes", value: "202401311200Z", expected: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{name: "offset", value: "20240131150000+0300", expected: time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{name: "bad value", value: "2024-01-31", mustFail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseGeneralizedTime(tt.value)
+			if tt.mustFail {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, res)
+		})
+	}
+
+	require.Equal(t, "20240131120000Z",
+		FormatGeneralizedTime(time.Date(2024, 1, 31, 15, 0, 0, 0, time.FixedZone("MSK", 3*60*60))))
+}
+
+func TestSID(t *testing.T) {
+	// S-1-5-21-1004336348-1177238915-682003330-1105
+	raw := []byte{
+		0x01, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05,
+		0x15, 0x00, 0x00, 0x00, 0xdc, 0xf4, 0xdc, 0x3b,
+		0x83, 0x3d, 0x2b, 0x46, 0x82, 0x8b, 0xa6, 0x28,
+		0x51, 0x04, 0x00, 0x00,
+	}
+	str := "S-1-5-21-1004336348-1177238915-682003330-1105"
+
+	sid, err := DecodeSID(raw)
+	require.NoError(t, err)
+	require.Equal(t, str, sid.String())
+	require.Equal(t, uint32(1105), sid.RID())
+	require.Equal(t, raw, sid.Bytes())
+
+	parsed, err := ParseSID(str)
+	require.NoError(t, err)
+	require.Equal(t, sid, parsed)
+
+	primary := sid.WithRID(513)
+	require.Equal(t, "S-1-5-21-1004336348-1177238915-682003330-513", primary.String())
+	// source sid is not changed
+	require.Equal(t, uint32(1105), sid.RID())
+	require.Equal(t, `\01\05\00\00\00\00\00\05\15\00\00\00\dc\f4\dc\3b\83\3d\2b\46\82\8b\a6\28\01\02\00\00`,
+		primary.FilterValue())
+
+	wellKnown, err := ParseSID("S-1-5-32-544")
+	require.NoError(t, err)
+	require.Equal(t, "S-1-5-32-544", wellKnown.String())
+
+	for _, bad := range []string{"", "S-1", "X-1-5-21", "S-1-5-21-abc", "S-1-5-21-4294967296"} {
+		_, err = ParseSID(bad)
+		require.Error(t, err, bad)
+	}
+
+	_, err = DecodeSID(raw[:20])
+	require.Error(t, err)
+}
+
+func TestGUID(t *testing.T) {
+	raw := []byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	}
+	str := "33221100-5544-7766-8899-aabbccddeeff"
+
+	guid, err := DecodeGUID(raw)
+	require.NoError(t, err)
+	require.Equal(t, str, guid.String())
+	require.Equal(t, raw, guid.Bytes())
+	require.False(t, guid.IsZero())
+	require.Equal(t, `\00\11\22\33\44\55\66\77\88\99\aa\bb\cc\dd\ee\ff`, guid.FilterValue())
+
+	for _, s := range []string{str, "{33221100-5544-7766-8899-AABBCCDDEEFF}"} {
+		parsed, err := ParseGUID(s)
+		require.NoError(t, err, s)
+		require.Equal(t, guid, parsed)
+	}
+
+	for _, bad := range []string{"", "33221100-5544-7766-8899", "33221100554477668899aabbccddeeff", "zz221100-5544-7766-8899-aabbccddeeff"} {
+		_, err = ParseGUID(bad)
+		require.Error(t, err, bad)
+	}
+
+	_, err = DecodeGUID(raw[:15])
+	require.Error(t, err)
+}
+
+func TestEscapeFilterBytes(t *testing.T) {
+	require.Equal(t, `\01\05\ff`, EscapeFilterBytes([]byte{0x01, 0x05, 0xff}))
+}
@@ -0,0 +1,78 @@
+// Copyright 2020-2024 NGR Softlab
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+////////////////////////////////////////////// GUID
+
+// GUID Object guid (objectGUID) in binary (wire) form
+//
+// Binary form is mixed-endian: first three groups are little endian, last two are big endian,
+// so binary "00112233-4455-6677-8899-aabbccddeeff" is "33221100-5544-7766-8899-aabbccddeeff" string.
+type GUID [16]byte
+
+// DecodeGUID Decode binary guid (objectGUID attribute raw value)
+func DecodeGUID(b []byte) (GUID, error) {
+	var g GUID
+	if len(b) != len(g) {
+		return g, fmt.Errorf("bad guid: wrong length %d", len(b))
+	}
+	copy(g[:], b)
+
+	return g, nil
+}
+
+// ParseGUID Parse guid string form ("xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", braces are allowed)
+func ParseGUID(s string) (GUID, error) {
+	var g GUID
+
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "{"), "}")
+	parts := strings.Split(trimmed, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 ||
+		len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return g, fmt.Errorf("bad guid %q", s)
+	}
+
+	raw, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return g, fmt.Errorf("bad guid %q: %s", s, err.Error())
+	}
+
+	binary.LittleEndian.PutUint32(g[0:4], binary.BigEndian.Uint32(raw[0:4]))
+	binary.LittleEndian.PutUint16(g[4:6], binary.BigEndian.Uint16(raw[4:6]))
+	binary.LittleEndian.PutUint16(g[6:8], binary.BigEndian.Uint16(raw[6:8]))
+	copy(g[8:], raw[8:])
+
+	return g, nil
+}
+
+// String Guid string form (lowercase, without braces)
+func (g GUID) String() string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10],
+		g[10:16],
+	)
+}
+
+// Bytes Binary guid form (objectGUID attribute raw value)
+func (g GUID) Bytes() []byte {
+	return append([]byte{}, g[:]...)
+}
+
+// IsZero Check if guid is empty
+func (g GUID) IsZero() bool {
+	return g == GUID{}
+}
+
+// FilterValue Escaped binary guid for search filter (e.g. "(objectGUID=" + guid.FilterValue() + ")")
+func (g GUID) FilterValue() string {
+	return EscapeFilterBytes(g[:])
+}
@@ -0,0 +1,137 @@
+// Copyright 2020-2024 NGR Softlab
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////// SID
+
+// SID Security identifier (objectSid, tokenGroups, etc.)
+type SID struct {
+	Revision       byte
+	Authority      uint64   // identifier authority (48 bit)
+	SubAuthorities []uint32 // domain identifiers and RID (last one)
+}
+
+// maxSubAuthorities max sub authorities count in sid
+const maxSubAuthorities = 15
+
+// DecodeSID Decode binary sid (objectSid attribute raw value)
+func DecodeSID(b []byte) (SID, error) {
+	// revision (1), sub authority count (1), identifier authority (6, big endian), sub authorities (4 each, little endian)
+	if len(b) < 8 || len(b) != 8+4*int(b[1]) {
+		return SID{}, fmt.Errorf("bad sid: wrong length %d", len(b))
+	}
+
+	sid := SID{
+		Revision:       b[0],
+		Authority:      uint64(b[2])<<40 | uint64(binary.BigEndian.Uint32(b[4:8])) | uint64(b[3])<<32,
+		SubAuthorities: make([]uint32, b[1]),
+	}
+	for i := range sid.SubAuthorities {
+		sid.SubAuthorities[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+
+	return sid, nil
+}
+
+// ParseSID Parse sid string form (e.g. "S-1-5-21-1004336348-1177238915-682003330-513")
+func ParseSID(s string) (SID, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 3 || !strings.EqualFold(parts[0], "S") {
+		return SID{}, fmt.Errorf("bad sid %q", s)
+	}
+	if len(parts)-3 > maxSubAuthorities {
+		return SID{}, fmt.Errorf("bad sid %q: too many sub authorities", s)
+	}
+
+	revision, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return SID{}, fmt.Errorf("bad sid %q: %s", s, err.Error())
+	}
+
+	// authority can be in hex form (0x...) if it doesn't fit 32 bits
+	authority, err := strconv.ParseUint(parts[2], 0, 48)
+	if err != nil {
+		return SID{}, fmt.Errorf("bad sid %q: %s", s, err.Error())
+	}
+
+	sid := SID{
+		Revision:       byte(revision),
+		Authority:      authority,
+		SubAuthorities: make([]uint32, 0, len(parts)-3),
+	}
+	for _, part := range parts[3:] {
+		sub, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return SID{}, fmt.Errorf("bad sid %q: %s", s, err.Error())
+		}
+		sid.SubAuthorities = append(sid.SubAuthorities, uint32(sub))
+	}
+
+	return sid, nil
+}
+
+// String Sid string form (S-R-I-S-S...)
+func (s SID) String() string {
+	var b strings.Builder
+	b.WriteString("S-")
+	b.WriteString(strconv.FormatUint(uint64(s.Revision), 10))
+	b.WriteString("-")
+	if s.Authority >= 1<<32 {
+		fmt.Fprintf(&b, "0x%012X", s.Authority)
+	} else {
+		b.WriteString(strconv.FormatUint(s.Authority, 10))
+	}
+	for _, sub := range s.SubAuthorities {
+		b.WriteString("-")
+		b.WriteString(strconv.FormatUint(uint64(sub), 10))
+	}
+
+	return b.String()
+}
+
+// Bytes Binary sid form (objectSid attribute raw value)
+func (s SID) Bytes() []byte {
+	b := make([]byte, 8+4*len(s.SubAuthorities))
+	b[0] = s.Revision
+	b[1] = byte(len(s.SubAuthorities))
+	b[2] = byte(s.Authority >> 40)
+	b[3] = byte(s.Authority >> 32)
+	binary.BigEndian.PutUint32(b[4:8], uint32(s.Authority))
+	for i, sub := range s.SubAuthorities {
+		binary.LittleEndian.PutUint32(b[8+4*i:], sub)
+	}
+
+	return b
+}
+
+// RID Relative identifier (last sub authority, 0 if sid has no sub authorities)
+func (s SID) RID() uint32 {
+	if len(s.SubAuthorities) == 0 {
+		return 0
+	}
+	return s.SubAuthorities[len(s.SubAuthorities)-1]
+}
+
+// WithRID Make sid with the same domain part and passed RID (e.g. primary group sid from user sid)
+func (s SID) WithRID(rid uint32) SID {
+	res := s
+	res.SubAuthorities = append([]uint32{}, s.SubAuthorities...)
+	if len(res.SubAuthorities) == 0 {
+		res.SubAuthorities = append(res.SubAuthorities, rid)
+	} else {
+		res.SubAuthorities[len(res.SubAuthorities)-1] = rid
+	}
+
+	return res
+}
+
+// FilterValue Escaped binary sid for search filter (e.g. "(objectSid=" + sid.FilterValue() + ")")
+func (s SID) FilterValue() string {
+	return EscapeFilterBytes(s.Bytes())
+}
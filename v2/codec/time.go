@@ -0,0 +1,90 @@
+// Copyright 2020-2024 NGR Softlab
+package codec
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+////////////////////////////////////////////// FILETIME
+
+const (
+	// FileTimeNever AD "never" value (accountExpires, lockoutDuration, etc.), 0 means never too
+	FileTimeNever int64 = math.MaxInt64
+
+	// fileTimeUnixEpoch 1970-01-01 as FILETIME (100ns intervals since 1601-01-01)
+	fileTimeUnixEpoch int64 = 116444736000000000
+)
+
+// FileTimeToTime Convert FILETIME (100ns intervals since 1601-01-01 UTC) to time,
+// zero time for 0 and FileTimeNever
+func FileTimeToTime(v int64) time.Time {
+	if IsFileTimeNever(v) {
+		return time.Time{}
+	}
+
+	// split to seconds to avoid time.Duration overflow for dates far from 1970
+	v -= fileTimeUnixEpoch
+	return time.Unix(v/1e7, (v%1e7)*100).UTC()
+}
+
+// TimeToFileTime Convert time to FILETIME, FileTimeNever for zero time
+func TimeToFileTime(t time.Time) int64 {
+	if t.IsZero() {
+		return FileTimeNever
+	}
+
+	return t.Unix()*1e7 + int64(t.Nanosecond())/100 + fileTimeUnixEpoch
+}
+
+// IsFileTimeNever Check if FILETIME is "never" value (0, negative or FileTimeNever)
+func IsFileTimeNever(v int64) bool {
+	return v <= 0 || v == FileTimeNever
+}
+
+// ParseFileTime Parse FILETIME attribute value (e.g. accountExpires, pwdLastSet, lastLogonTimestamp),
+// zero time for "never" values
+func ParseFileTime(value string) (time.Time, error) {
+	v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad filetime %q: %s", value, err.Error())
+	}
+
+	return FileTimeToTime(v), nil
+}
+
+// FormatFileTime Format time as FILETIME attribute value (FileTimeNever for zero time)
+func FormatFileTime(t time.Time) string {
+	return strconv.FormatInt(TimeToFileTime(t), 10)
+}
+
+////////////////////////////////////////////// GeneralizedTime
+
+// generalizedTimeLayouts GeneralizedTime layouts (minutes or seconds, optional fraction, Z or offset)
+var generalizedTimeLayouts = []string{
+	"20060102150405Z0700",
+	"20060102150405.999999999Z0700",
+	"200601021504Z0700",
+	"2006010215Z0700",
+}
+
+// ParseGeneralizedTime Parse GeneralizedTime attribute value (e.g. whenCreated "20240131120000.0Z"), result is UTC
+func ParseGeneralizedTime(value string) (time.Time, error) {
+	// fraction can be separated with comma (X.680)
+	normalized := strings.Replace(strings.TrimSpace(value), ",", ".", 1)
+	for _, layout := range generalizedTimeLayouts {
+		if t, err := time.Parse(layout, normalized); err == nil {
+			return t.UTC(), nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("bad generalized time %q", value)
+}
+
+// FormatGeneralizedTime Format time as GeneralizedTime attribute value in UTC (e.g. "20240131120000Z")
+func FormatGeneralizedTime(t time.Time) string {
+	return t.UTC().Format("20060102150405Z")
+}
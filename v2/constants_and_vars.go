@@ -1,6 +1,11 @@
 // Copyright 2020-2024 NGR Softlab
 package ldapper
 
+import (
+	"errors"
+	"time"
+)
+
 ////////////////////////////////////////////// Constants
 
 const (
@@ -10,24 +15,266 @@ const (
 	// searchFilterUserOpenLDAP User search openLdap pattern
 	searchFilterUserOpenLDAP = "(|(&(objectClass=person)(cn=%s))(structuralObjectClass=organizationalRole))"
 
+	// filterAny any object pattern (for reading entry by dn)
+	filterAny = "(objectClass=*)"
+
 	// filterGroup department pattern
 	filterGroup = "(&(objectClass=organizationalUnit))"
 
+	// filterGroupsInChainAD all groups of member (nested too) AD pattern (LDAP_MATCHING_RULE_IN_CHAIN)
+	filterGroupsInChainAD = "(&(objectClass=group)(member:1.2.840.113556.1.4.1941:=%s))"
+	// filterGroupsOfMemberAD direct groups of member AD pattern
+	filterGroupsOfMemberAD = "(&(objectClass=group)(member=%s))"
+	// filterGroupsOfMemberOpenLDAP direct groups of member openLdap pattern
+	filterGroupsOfMemberOpenLDAP = "(|(&(objectClass=groupOfNames)(member=%[1]s))(&(objectClass=groupOfUniqueNames)(uniqueMember=%[1]s)))"
+	// filterPosixGroupsOfMember posix groups of member uid pattern
+	filterPosixGroupsOfMember = "(&(objectClass=posixGroup)(memberUid=%s))"
+	// filterPosixGroupByGID posix group by gidNumber pattern
+	filterPosixGroupByGID = "(&(objectClass=posixGroup)(gidNumber=%s))"
+	// filterPosixAccountByUID posix account by uid pattern
+	filterPosixAccountByUID = "(&(objectClass=posixAccount)(uid=%s))"
+	// filterBySID object by objectSid pattern (escaped binary sid)
+	filterBySID = "(objectSid=%s)"
+	// filterByManager direct reports by manager dn pattern (escaped dn)
+	filterByManager = "(manager=%s)"
+	// filterByGUIDAD object by objectGUID pattern (escaped binary guid)
+	filterByGUIDAD = "(objectGUID=%s)"
+	// filterByEntryUUID openLdap object by entryUUID pattern
+	filterByEntryUUID = "(entryUUID=%s)"
+	// filterBySambaSID openLdap (samba schema) object by sambaSID pattern
+	filterBySambaSID = "(sambaSID=%s)"
+
+	// filterUSNChangedAD entries changed after USN pattern (AD, USN of DC)
+	filterUSNChangedAD = "(uSNChanged>=%d)"
+	// filterEntryCSN openLdap entries changed after CSN pattern (entries with the same CSN are skipped)
+	filterEntryCSN = "(&(entryCSN>=%[1]s)(!(entryCSN=%[1]s)))"
+	// filterModifyTimestamp openLdap entries changed after time pattern (generalized time, entries with the same time are skipped)
+	filterModifyTimestamp = "(&(modifyTimestamp>=%[1]s)(!(modifyTimestamp=%[1]s)))"
+	// filterPersonAD AD user accounts pattern (without computers, they are users too)
+	filterPersonAD = "(&(objectCategory=person)(objectClass=user))"
+
+	// filterDirSyncAD default DirSync objects pattern
+	filterDirSyncAD = "(|(objectClass=user)(objectClass=group)(objectClass=organizationalUnit))"
+
+	// filterEnabledAD not disabled accounts AD pattern
+	filterEnabledAD = "(!(userAccountControl:1.2.840.113556.1.4.803:=2))"
+	// filterNotExpiredAD not expired accounts AD pattern (current time as FILETIME)
+	filterNotExpiredAD = "(|(!(accountExpires=*))(accountExpires=0)(accountExpires=9223372036854775807)(accountExpires>=%d))"
+
 	// filterUserAD, FilterUserLinux user obj in ou pattern
 	filterUserAD       = "(&(objectClass=User))"
 	filterUserOpenLDAP = "(&(objectClass=person))"
 
-	// DepthOfLdapSearch For get AD struct
+	// groupTypeSecurity AD groupType flag of security groups
+	groupTypeSecurity = 0x80000000
+
+	// DepthOfLdapSearch For get AD struct (default TreeOptions.MaxDepth)
 	DepthOfLdapSearch = 4
+
+	// UnlimitedDepth TreeOptions.MaxDepth value for reading the whole tree
+	UnlimitedDepth = -1
+
+	// filterChunkSize Max values count in one (|...) search filter
+	filterChunkSize = 100
+
+	// Cache defaults
+	defaultCacheMaxEntries  = 1000
+	defaultCacheUserTTL     = 5 * time.Minute
+	defaultCacheGroupTTL    = 5 * time.Minute
+	defaultCacheNegativeTTL = 30 * time.Second
+	defaultCacheLoadTimeout = time.Minute
+
+	// pagingSize Page size for paged searches (AD MaxPageSize is 1000 by default)
+	pagingSize = 500
+)
+
+////////////////////////////////////////////// Errors
+
+var (
+	// ErrNotFound Object (user, group, etc.) not found
+	ErrNotFound = errors.New("ldap object not found")
+	// ErrInvalidInput Bad params of write operation (checked before any request to server)
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrInsecureConnection Operation needs TLS connection (e.g. password change)
+	ErrInsecureConnection = errors.New("operation requires tls connection")
+	// ErrWrongPassword Old password is wrong
+	ErrWrongPassword = errors.New("wrong password")
+	// ErrNotSupported Operation (control) is not supported by server
+	ErrNotSupported = errors.New("operation not supported by server")
+	// ErrPasswordPolicy New password violates password policy (length, complexity, history, min age)
+	ErrPasswordPolicy = errors.New("password policy violation")
+)
+
+////////////////////////////////////////////// Group kinds
+
+const (
+	GroupKindOU           GroupKind = "ou"                // organizationalUnit
+	GroupKindContainer    GroupKind = "container"         // container, builtinDomain (AD CN=Users, CN=Builtin, etc.)
+	GroupKindDomain       GroupKind = "domain"            // domainDNS, domain
+	GroupKindSecurity     GroupKind = "securityGroup"     // AD security group
+	GroupKindDistribution GroupKind = "distributionGroup" // AD distribution group
+	GroupKindGroupOfNames GroupKind = "groupOfNames"      // openLdap groupOfNames, groupOfUniqueNames
+	GroupKindPosix        GroupKind = "posixGroup"        // posixGroup
+)
+
+// groupKindFilters search filter patterns for group kinds
+var groupKindFilters = map[GroupKind]string{
+	GroupKindOU:           "(objectClass=organizationalUnit)",
+	GroupKindContainer:    "(|(objectClass=container)(objectClass=builtinDomain))",
+	GroupKindDomain:       "(|(objectClass=domainDNS)(objectClass=domain))",
+	GroupKindSecurity:     "(&(objectClass=group)(groupType:1.2.840.113556.1.4.803:=2147483648))",
+	GroupKindDistribution: "(&(objectClass=group)(!(groupType:1.2.840.113556.1.4.803:=2147483648)))",
+	GroupKindGroupOfNames: "(|(objectClass=groupOfNames)(objectClass=groupOfUniqueNames))",
+	GroupKindPosix:        "(objectClass=posixGroup)",
+}
+
+////////////////////////////////////////////// Account flags
+
+const (
+	// AD userAccountControl flags
+	UACAccountDisable      uint32 = 0x0002
+	UACLockout             uint32 = 0x0010
+	UACPasswordNotRequired uint32 = 0x0020
+	UACNormalAccount       uint32 = 0x0200
+	UACDontExpirePassword  uint32 = 0x10000
+	UACSmartcardRequired   uint32 = 0x40000
+	UACPasswordExpired     uint32 = 0x800000
+
+	// maxSAMAccountNameLen AD sAMAccountName max length (pre-Windows 2000 logon name)
+	maxSAMAccountNameLen = 20
+	// samAccountNameBadChars chars not allowed in AD sAMAccountName
+	samAccountNameBadChars = "\"/\\[]:;|=,+*?<>@"
+
+	// AD extended error codes (win32 errors in diagnostic message)
+	adErrWrongPassword  = "00000056" // ERROR_INVALID_PASSWORD
+	adErrPasswordPolicy = "0000052D" // ERROR_PASSWORD_RESTRICTION
+	adErrMemberNotInGrp = "00000561" // ERROR_MEMBER_NOT_IN_GROUP
+	adErrMemberInGroup  = "00000562" // ERROR_MEMBER_IN_GROUP
+
+	// memberChunkSize max member values in one modify request (AD limits values per modify)
+	memberChunkSize = 1000
+
+	// csvTemplateField CSVMapping.DNTemplate placeholder pattern
+	csvTemplateField = `\{\{\s*([^{}]*?)\s*\}\}`
+
+	// ppolicyDisabledTime openLdap ppolicy pwdAccountLockedTime value for administratively locked accounts
+	ppolicyDisabledTime = "000001010000Z"
+
+	// uacModifyAttempts userAccountControl read-modify-write attempts (changed concurrently value is read again)
+	uacModifyAttempts = 2
+)
+
+////////////////////////////////////////////// Member kinds
+
+const (
+	MemberKindUser     MemberKind = "user"                     // user (person, posixAccount, etc.)
+	MemberKindComputer MemberKind = "computer"                 // AD computer
+	MemberKindContact  MemberKind = "contact"                  // AD contact (not a security principal)
+	MemberKindForeign  MemberKind = "foreignSecurityPrincipal" // AD principal from other domain/forest (cn is its sid)
+	MemberKindGroup    MemberKind = "group"                    // nested group (only for not recursive members listing)
+)
+
+////////////////////////////////////////////// Export formats
+
+const (
+	ExportCSV       ExportFormat = iota // comma separated values (RFC 4180)
+	ExportJSONLines                     // one json object per line
+)
+
+////////////////////////////////////////////// Diff kinds
+
+const (
+	DiffAdded    DiffKind = "added"
+	DiffRemoved  DiffKind = "removed"
+	DiffRenamed  DiffKind = "renamed"  // node name (rdn) changed
+	DiffMoved    DiffKind = "moved"    // node parent changed
+	DiffModified DiffKind = "modified" // user fields changed
+)
+
+////////////////////////////////////////////// Watch modes and events
+
+const (
+	WatchAuto             WatchMode = iota // WatchSyncrepl if server supports it, WatchPersistentSearch otherwise
+	WatchSyncrepl                          // RFC 4533 content synchronization (refreshAndPersist), resumable by cookie
+	WatchPersistentSearch                  // persistent search (draft-ietf-ldapext-psearch), only changes after start
+)
+
+const (
+	WatchAdd    WatchEventType = "add"
+	WatchModify WatchEventType = "modify"
+	WatchDelete WatchEventType = "delete"
+	WatchModDN  WatchEventType = "moddn"
+	WatchError  WatchEventType = "error" // watch failed (e.g. connection lost), channel is closed after it
+	// WatchPresent syncrepl refresh present phase end (resume by cookie): WatchEvent.Present lists all entries
+	WatchPresent WatchEventType = "present"
+)
+
+const (
+	// ControlTypePersistentSearch persistent search request control
+	ControlTypePersistentSearch = "2.16.840.1.113730.3.4.3"
+	// ControlTypeEntryChangeNotification persistent search entry change response control
+	ControlTypeEntryChangeNotification = "2.16.840.1.113730.3.4.7"
+
+	// persistent search change types
+	psearchAdd    = 1
+	psearchDelete = 2
+	psearchModify = 4
+	psearchModDN  = 8
+)
+
+////////////////////////////////////////////// Import statuses
+
+const (
+	ImportPlanned    ImportStatus = "planned"     // dry-run: record is valid and can be applied
+	ImportApplied    ImportStatus = "applied"     // record is applied
+	ImportFailed     ImportStatus = "failed"      // record is not valid or failed on server
+	ImportSkipped    ImportStatus = "skipped"     // record is not processed (import stopped before it)
+	ImportRolledBack ImportStatus = "rolled_back" // record was applied and undone by rollback
+)
+
+////////////////////////////////////////////// CSV import row statuses
+
+const (
+	CSVRowCreated CSVRowStatus = "created" // user is created (or will be created)
+	CSVRowUpdated CSVRowStatus = "updated" // existing user is updated (or will be updated)
+	CSVRowSkipped CSVRowStatus = "skipped" // user exists and CSVImportOptions.Update is not set
+	CSVRowFailed  CSVRowStatus = "failed"  // row is not valid or failed on server
+)
+
+////////////////////////////////////////////// Nested groups methods
+
+const (
+	NestedGroupsAuto        NestedGroupsMethod = iota // NestedGroupsInChain for AD, NestedGroupsClientSide for openLdap
+	NestedGroupsInChain                               // AD LDAP_MATCHING_RULE_IN_CHAIN (1.2.840.113556.1.4.1941) search
+	NestedGroupsTokenGroups                           // AD tokenGroups attribute (computed sids of all groups)
+	NestedGroupsClientSide                            // group by group traversal with cycle detection (any server)
 )
 
 ////////////////////////////////////////////// Attr templates
 
 var (
+	// object classes of created entries
+	ADUserClasses       = []string{"top", "person", "organizationalPerson", "user"}
+	openLDAPUserClasses = []string{"top", "person", "organizationalPerson", "inetOrgPerson"}
+	OUClasses           = []string{"top", "organizationalUnit"}
+
 	testBaseDNAttr = []string{"cn"}
 
-	openLDAPUserAttrs = []string{"cn", "departmentNumber", "mobile", "mail", "title", "jpegPhoto"}
-	ADUserAttrs       = []string{
+	openLDAPUserAttrs = []string{
+		"cn",
+		"departmentNumber",
+		"mobile",
+		"mail",
+		"title",
+		"jpegPhoto",
+		"pwdAccountLockedTime",
+		"pwdChangedTime",
+		"pwdReset",
+		"shadowExpire",
+		"entryUUID",
+		"sambaSID",
+	}
+	ADUserAttrs = []string{
 		"cn",
 		"department",
 		"mobile",
@@ -42,11 +289,48 @@ var (
 		"postalCode",
 		"co",
 		"company",
+		"userAccountControl",
+		"msDS-User-Account-Control-Computed",
+		"lockoutTime",
+		"accountExpires",
+		"pwdLastSet",
+		"objectGUID",
+		"objectSid",
+	}
+
+	openLDAPAccountStatusAttrs = []string{"pwdAccountLockedTime", "pwdChangedTime", "pwdReset", "shadowExpire"}
+	ADAccountStatusAttrs       = []string{
+		"userAccountControl", "msDS-User-Account-Control-Computed", "lockoutTime", "accountExpires", "pwdLastSet",
 	}
 
-	openLDAPGroupUserAttrs = []string{"cn", "departmentNumber", "mail", "uid", "title"}
-	ADGroupUserAttrs       = []string{"cn", "mail", "userPrincipalName", "title", "department"}
+	// defaultExportColumns users export columns (UserShortInfo fields)
+	defaultExportColumns = []string{"name", "login", "mail", "title", "department"}
+
+	openLDAPGroupUserAttrs = []string{"cn", "departmentNumber", "mail", "uid", "title", "entryUUID"}
+	ADGroupUserAttrs       = []string{"cn", "mail", "userPrincipalName", "title", "department", "objectGUID"}
+
+	openLDAPUserGroupsAttrs = []string{"uid", "gidNumber"}
+	ADUserGroupsAttrs       = []string{"memberOf", "primaryGroupID", "objectSid"}
+
+	openLDAPMemberAttrs = []string{"cn", "departmentNumber", "mail", "uid", "title", "objectClass", "entryUUID"}
+	ADMemberAttrs       = []string{"cn", "mail", "userPrincipalName", "title", "department", "objectClass", "objectGUID"}
+
+	openLDAPOrgAttrs = []string{"cn", "departmentNumber", "mail", "uid", "title", "manager", "entryUUID"}
+	ADOrgAttrs       = []string{"cn", "mail", "userPrincipalName", "title", "department", "manager", "objectGUID"}
+
+	// change tracking attrs (AD rootDSE and entries, openLdap operational attrs)
+	ADRootDSEUSNAttrs   = []string{"dnsHostName", "highestCommittedUSN"}
+	ADChangeAttrs       = []string{"objectClass", "uSNChanged", "name", "ou", "distinguishedName", "groupType"}
+	openLDAPChangeAttrs = []string{"objectClass", "modifyTimestamp", "entryCSN", "ou"}
+
+	// rollbackSkipAttrs attrs of deleted entry not restored by rollback (set by server)
+	rollbackSkipAttrs = []string{
+		"objectGUID", "objectSid", "distinguishedName", "name", "instanceType", "whenCreated", "whenChanged",
+		"uSNCreated", "uSNChanged", "dSCorePropagationData", "sAMAccountType", "memberOf", "badPwdCount",
+		"badPasswordTime", "lastLogon", "lastLogoff", "lastLogonTimestamp", "logonCount", "pwdLastSet",
+		"lockoutTime", "isCriticalSystemObject", "objectCategory",
+	}
 
-	openLDAPGroupAttrs = []string{"ou"}
-	ADGroupAttrs       = []string{"name", "ou", "distinguishedName"}
+	openLDAPGroupAttrs = []string{"ou", "cn", "objectClass", "entryUUID"}
+	ADGroupAttrs       = []string{"name", "ou", "distinguishedName", "objectClass", "groupType", "objectGUID"}
 )
@@ -0,0 +1,215 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"fmt"
+	"strings"
+)
+
+////////////////////////////////////////////// Struct diff
+
+// Diff Compare two struct snapshots (GetStruct results). Nodes are matched by guid
+// (AD objectGUID, openLdap entryUUID) when every node of both snapshots has it, otherwise all nodes
+// are matched by dn (e.g. for old snapshots without guids). Nodes matched by dn can't be reported
+// as renamed or moved (they are removed and added)
+func Diff(oldStruct, newStruct ADStruct) StructDiff {
+	byGUID := allNodesHaveGUID(oldStruct.AD) && allNodesHaveGUID(newStruct.AD)
+	oldNodes, oldOrder := flattenStruct(oldStruct.AD, byGUID)
+	newNodes, newOrder := flattenStruct(newStruct.AD, byGUID)
+
+	res := StructDiff{Changes: make([]StructChange, 0)}
+	for _, key := range oldOrder {
+		if _, ok := newNodes[key]; !ok {
+			n := oldNodes[key]
+			res.Changes = append(res.Changes, StructChange{
+				Kind: DiffRemoved, GUID: n.GUID, OldDN: n.DName, OldName: n.Name,
+			})
+		}
+	}
+
+	for _, key := range newOrder {
+		n := newNodes[key]
+		old, ok := oldNodes[key]
+		if !ok {
+			res.Changes = append(res.Changes, StructChange{
+				Kind: DiffAdded, GUID: n.GUID, NewDN: n.DName, NewName: n.Name,
+			})
+			continue
+		}
+
+		change := StructChange{GUID: n.GUID, OldDN: old.DName, NewDN: n.DName, OldName: old.Name, NewName: n.Name}
+		if old.Name != n.Name {
+			change.Kind = DiffRenamed
+			res.Changes = append(res.Changes, change)
+		}
+		if old.parent != n.parent {
+			change.Kind = DiffMoved
+			res.Changes = append(res.Changes, change)
+		}
+	}
+
+	return res
+}
+
+// String Diff as human-readable text (one change per line)
+func (d StructDiff) String() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		switch c.Kind {
+		case DiffAdded:
+			fmt.Fprintf(&b, "+ %s\n", c.NewDN)
+		case DiffRemoved:
+			fmt.Fprintf(&b, "- %s\n", c.OldDN)
+		case DiffRenamed:
+			fmt.Fprintf(&b, "~ renamed %q -> %q (%s)\n", c.OldName, c.NewName, c.NewDN)
+		case DiffMoved:
+			fmt.Fprintf(&b, "> moved %s -> %s\n", c.OldDN, c.NewDN)
+		}
+	}
+	return b.String()
+}
+
+// structNode - flattened tree node with parent key
+type structNode struct {
+	GroupInfo
+	parent string
+}
+
+// flattenStruct - flatten tree to nodes by key (see nodeKey), keys are returned in tree order
+func flattenStruct(groups []GroupInfo, byGUID bool) (map[string]structNode, []string) {
+	nodes := make(map[string]structNode)
+	order := make([]string, 0)
+
+	var walk func(groups []GroupInfo, parent string)
+	walk = func(groups []GroupInfo, parent string) {
+		for _, g := range groups {
+			key := nodeKey(g.GUID, g.DName, byGUID)
+			if _, ok := nodes[key]; ok {
+				continue
+			}
+			nodes[key] = structNode{GroupInfo: g, parent: parent}
+			order = append(order, key)
+			walk(g.Has, key)
+		}
+	}
+	walk(groups, "")
+
+	return nodes, order
+}
+
+// nodeKey - snapshot node identity: guid if nodes are matched by guid, dn otherwise
+func nodeKey(guid, dn string, byGUID bool) string {
+	if byGUID {
+		return "guid:" + strings.ToLower(guid)
+	}
+	return "dn:" + dnKey(dn)
+}
+
+// allNodesHaveGUID - check if every node of tree has guid
+func allNodesHaveGUID(groups []GroupInfo) bool {
+	for _, g := range groups {
+		if g.GUID == "" || !allNodesHaveGUID(g.Has) {
+			return false
+		}
+	}
+	return true
+}
+
+////////////////////////////////////////////// Users diff
+
+// DiffUsers Compare two user lists (GetGroupUsers results). Users are matched by guid
+// when every user of both lists has it, otherwise all users are matched by login (or dn for users without login)
+func DiffUsers(oldUsers, newUsers []UserShortInfo) UsersDiff {
+	byGUID := allUsersHaveGUID(oldUsers) && allUsersHaveGUID(newUsers)
+	oldByKey := make(map[string]UserShortInfo, len(oldUsers))
+	for _, u := range oldUsers {
+		oldByKey[userKey(u, byGUID)] = u
+	}
+	newByKey := make(map[string]UserShortInfo, len(newUsers))
+	for _, u := range newUsers {
+		newByKey[userKey(u, byGUID)] = u
+	}
+
+	res := UsersDiff{Changes: make([]UserChange, 0)}
+	for _, u := range oldUsers {
+		if _, ok := newByKey[userKey(u, byGUID)]; !ok {
+			res.Changes = append(res.Changes, UserChange{Kind: DiffRemoved, Login: u.Login, DN: u.DName})
+		}
+	}
+
+	for _, u := range newUsers {
+		old, ok := oldByKey[userKey(u, byGUID)]
+		if !ok {
+			res.Changes = append(res.Changes, UserChange{Kind: DiffAdded, Login: u.Login, DN: u.DName})
+			continue
+		}
+
+		if fields := userFieldChanges(old, u); len(fields) > 0 {
+			res.Changes = append(res.Changes, UserChange{Kind: DiffModified, Login: u.Login, DN: u.DName, Fields: fields})
+		}
+	}
+
+	return res
+}
+
+// String Diff as human-readable text (one change per line)
+func (d UsersDiff) String() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		switch c.Kind {
+		case DiffAdded:
+			fmt.Fprintf(&b, "+ %s (%s)\n", c.Login, c.DN)
+		case DiffRemoved:
+			fmt.Fprintf(&b, "- %s (%s)\n", c.Login, c.DN)
+		case DiffModified:
+			fields := make([]string, 0, len(c.Fields))
+			for _, f := range c.Fields {
+				fields = append(fields, fmt.Sprintf("%s %q -> %q", f.Field, f.Old, f.New))
+			}
+			fmt.Fprintf(&b, "~ %s: %s\n", c.Login, strings.Join(fields, "; "))
+		}
+	}
+	return b.String()
+}
+
+// userKey - user identity in lists: guid if users are matched by guid, login or dn otherwise
+func userKey(u UserShortInfo, byGUID bool) string {
+	if byGUID {
+		return "guid:" + strings.ToLower(u.GUID)
+	}
+	if u.Login != "" {
+		return "login:" + strings.ToLower(u.Login)
+	}
+	return "dn:" + dnKey(u.DName)
+}
+
+// allUsersHaveGUID - check if every user of list has guid
+func allUsersHaveGUID(users []UserShortInfo) bool {
+	for _, u := range users {
+		if u.GUID == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// userFieldChanges - changed fields of user (json names)
+func userFieldChanges(old, u UserShortInfo) []FieldChange {
+	res := make([]FieldChange, 0)
+	for _, f := range []struct {
+		name     string
+		old, new string
+	}{
+		{"name", old.Name, u.Name},
+		{"login", old.Login, u.Login},
+		{"mail", old.Mail, u.Mail},
+		{"title", old.Title, u.Title},
+		{"department", old.Department, u.Department},
+		{"distinguishedName", old.DName, u.DName},
+	} {
+		if f.old != f.new {
+			res = append(res, FieldChange{Field: f.name, Old: f.old, New: f.new})
+		}
+	}
+	return res
+}
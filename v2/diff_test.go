@@ -0,0 +1,112 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	oldStruct := ADStruct{AD: []GroupInfo{
+		{Name: "Sales", DName: "OU=Sales,DC=test", GUID: "1", Has: []GroupInfo{
+			{Name: "East", DName: "OU=East,OU=Sales,DC=test", GUID: "2", Has: []GroupInfo{
+				{Name: "Moscow", DName: "OU=Moscow,OU=East,OU=Sales,DC=test", GUID: "3"},
+			}},
+			{Name: "Old", DName: "OU=Old,OU=Sales,DC=test", GUID: "4"},
+		}},
+		{Name: "IT", DName: "OU=IT,DC=test", GUID: "5"},
+	}}
+	newStruct := ADStruct{AD: []GroupInfo{
+		{Name: "Sales", DName: "OU=Sales,DC=test", GUID: "1"},
+		{Name: "IT", DName: "OU=IT,DC=test", GUID: "5", Has: []GroupInfo{
+			{Name: "Eastern", DName: "OU=Eastern,OU=IT,DC=test", GUID: "2", Has: []GroupInfo{
+				{Name: "Moscow", DName: "OU=Moscow,OU=Eastern,OU=IT,DC=test", GUID: "3"},
+			}},
+			{Name: "New", DName: "OU=New,OU=IT,DC=test", GUID: "6"},
+		}},
+	}}
+
+	diff := Diff(oldStruct, newStruct)
+	require.Equal(t, []StructChange{
+		{Kind: DiffRemoved, GUID: "4", OldDN: "OU=Old,OU=Sales,DC=test", OldName: "Old"},
+		{Kind: DiffRenamed, GUID: "2", OldDN: "OU=East,OU=Sales,DC=test", NewDN: "OU=Eastern,OU=IT,DC=test", OldName: "East", NewName: "Eastern"},
+		{Kind: DiffMoved, GUID: "2", OldDN: "OU=East,OU=Sales,DC=test", NewDN: "OU=Eastern,OU=IT,DC=test", OldName: "East", NewName: "Eastern"},
+		{Kind: DiffAdded, GUID: "6", NewDN: "OU=New,OU=IT,DC=test", NewName: "New"},
+	}, diff.Changes)
+
+	require.Equal(t, `- OU=Old,OU=Sales,DC=test
+~ renamed "East" -> "Eastern" (OU=Eastern,OU=IT,DC=test)
+> moved OU=East,OU=Sales,DC=test -> OU=Eastern,OU=IT,DC=test
++ OU=New,OU=IT,DC=test
+`, diff.String())
+
+	require.Empty(t, Diff(oldStruct, oldStruct).Changes)
+
+	// snapshot without guids (e.g. taken by older version): all nodes are matched by dn
+	noGUIDStruct := ADStruct{AD: []GroupInfo{
+		{Name: "Sales", DName: "OU=Sales,DC=test", Has: []GroupInfo{
+			{Name: "East", DName: "OU=East,OU=Sales,DC=test"},
+		}},
+		{Name: "IT", DName: "ou=it,dc=test"},
+	}}
+	withGUIDStruct := ADStruct{AD: []GroupInfo{
+		{Name: "Sales", DName: "OU=Sales,DC=test", GUID: "1", Has: []GroupInfo{
+			{Name: "East", DName: "OU=East,OU=Sales,DC=test", GUID: "2"},
+		}},
+		{Name: "IT", DName: "OU=IT,DC=test", GUID: "5", Has: []GroupInfo{
+			{Name: "New", DName: "OU=New,OU=IT,DC=test", GUID: "6"},
+		}},
+	}}
+	require.Equal(t, []StructChange{
+		{Kind: DiffAdded, GUID: "6", NewDN: "OU=New,OU=IT,DC=test", NewName: "New"},
+	}, Diff(noGUIDStruct, withGUIDStruct).Changes)
+	require.Equal(t, []StructChange{
+		{Kind: DiffRemoved, GUID: "6", OldDN: "OU=New,OU=IT,DC=test", OldName: "New"},
+	}, Diff(withGUIDStruct, noGUIDStruct).Changes)
+}
+
+func TestDiffUsers(t *testing.T) {
+	oldUsers := []UserShortInfo{
+		{Name: "Ivan", Login: "ivan@test", Title: "Dev", DName: "CN=Ivan,DC=test", GUID: "1"},
+		{Name: "Petr", Login: "petr@test", DName: "CN=Petr,DC=test", GUID: "2"},
+		{Name: "Anna", Login: "anna@test", DName: "CN=Anna,DC=test", GUID: "3"},
+	}
+	newUsers := []UserShortInfo{
+		{Name: "Ivan", Login: "ivan.i@test", Title: "Lead", Department: "IT", DName: "CN=Ivan,DC=test", GUID: "1"},
+		{Name: "Anna", Login: "ANNA@test", DName: "CN=Anna,DC=test", GUID: "3"},
+		{Name: "Olga", Login: "olga@test", DName: "CN=Olga,DC=test", GUID: "4"},
+	}
+
+	diff := DiffUsers(oldUsers, newUsers)
+	require.Equal(t, []UserChange{
+		{Kind: DiffRemoved, Login: "petr@test", DN: "CN=Petr,DC=test"},
+		{Kind: DiffModified, Login: "ivan.i@test", DN: "CN=Ivan,DC=test", Fields: []FieldChange{
+			{Field: "login", Old: "ivan@test", New: "ivan.i@test"},
+			{Field: "title", Old: "Dev", New: "Lead"},
+			{Field: "department", Old: "", New: "IT"},
+		}},
+		{Kind: DiffModified, Login: "ANNA@test", DN: "CN=Anna,DC=test", Fields: []FieldChange{
+			{Field: "login", Old: "anna@test", New: "ANNA@test"},
+		}},
+		{Kind: DiffAdded, Login: "olga@test", DN: "CN=Olga,DC=test"},
+	}, diff.Changes)
+
+	require.Equal(t, `- petr@test (CN=Petr,DC=test)
+~ ivan.i@test: login "ivan@test" -> "ivan.i@test"; title "Dev" -> "Lead"; department "" -> "IT"
+~ ANNA@test: login "anna@test" -> "ANNA@test"
++ olga@test (CN=Olga,DC=test)
+`, diff.String())
+
+	// list without guids: all users are matched by login
+	noGUIDUsers := []UserShortInfo{
+		{Name: "Ivan", Login: "ivan@test", DName: "CN=Ivan,DC=test"},
+		{Name: "Anna", Login: "anna@test", DName: "CN=Anna,DC=test"},
+	}
+	require.Equal(t, []UserChange{
+		{Kind: DiffModified, Login: "ivan@test", DN: "CN=Ivan,DC=test", Fields: []FieldChange{
+			{Field: "title", Old: "", New: "Dev"},
+		}},
+		{Kind: DiffAdded, Login: "petr@test", DN: "CN=Petr,DC=test"},
+	}, DiffUsers(noGUIDUsers, oldUsers).Changes)
+}
@@ -0,0 +1,147 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
+)
+
+////////////////////////////////////////////// AD DirSync
+
+// DirSync Read AD changes since cookie (nil cookie - all objects) with DirSync control:
+// changed entries with changed attrs only, deleted objects and member values added/removed.
+// baseDN must be a naming context root (e.g. DomainDN). Returned cookie is passed to the next call
+func (conn *LdapConn) DirSync(ctx context.Context, baseDN string, cookie []byte,
+	opts ...DirSyncOptions) (res DirSyncResult, err error) {
+
+	var syncOpts DirSyncOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		syncOpts = opts[0]
+	}
+
+	if conn.options.OpenLDAP {
+		return res, fmt.Errorf("%w: dirsync is supported only by AD", ErrNotSupported)
+	}
+	if err = validateDN(baseDN); err != nil {
+		return res, err
+	}
+	filter := syncOpts.Filter
+	if filter == "" {
+		filter = filterDirSyncAD
+	}
+	if _, err = ldap.CompileFilter(filter); err != nil {
+		return res, fmt.Errorf("%w: bad filter %q: %s", ErrInvalidInput, filter, err.Error())
+	}
+	if baseDN, err = conn.resolveDN(ctx, baseDN); err != nil {
+		return res, err
+	}
+
+	flags := ldap.DirSyncIncrementalValues
+	if syncOpts.ObjectSecurity {
+		flags |= ldap.DirSyncObjectSecurity
+	}
+	control := ldap.NewRequestControlDirSync(flags, 0, cookie)
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		syncOpts.Attributes,
+		[]ldap.Control{control},
+	)
+
+	res.Changed = make([]DirSyncEntry, 0)
+	res.Deleted = make([]DeletedObject, 0)
+	res.Cookie = cookie
+
+	for {
+		searchResult, err := conn.search(ctx, searchRequest)
+		if err != nil {
+			return res, fmt.Errorf("bad dirsync: %s", err.Error())
+		}
+
+		for _, entry := range searchResult.Entries {
+			changed, deleted := parseDirSyncEntry(entry)
+			if deleted != nil {
+				res.Deleted = append(res.Deleted, *deleted)
+			} else {
+				res.Changed = append(res.Changed, changed)
+			}
+		}
+
+		response, ok := ldap.FindControl(searchResult.Controls, ldap.ControlTypeDirSync).(*ldap.ControlDirSync)
+		if !ok {
+			return res, fmt.Errorf("bad dirsync: no dirsync control in response")
+		}
+		res.Cookie = response.Cookie
+
+		// not zero flags - server has more changes
+		if response.Flags == 0 {
+			return res, nil
+		}
+		control.SetCookie(response.Cookie)
+	}
+}
+
+// parseDirSyncEntry - make changed entry or deleted object (isDeleted) from DirSync entry.
+// Incremental values of multi-valued attrs come as "member;range=1-1" (added) and "member;range=0-0" (removed)
+func parseDirSyncEntry(entry *ldap.Entry) (DirSyncEntry, *DeletedObject) {
+	var guid string
+	if parsed, err := codec.DecodeGUID(entry.GetRawAttributeValue("objectGUID")); err == nil {
+		guid = parsed.String()
+	}
+
+	if strings.EqualFold(entry.GetAttributeValue("isDeleted"), "TRUE") {
+		return DirSyncEntry{}, &DeletedObject{
+			DN:              entry.DN,
+			GUID:            guid,
+			LastKnownParent: entry.GetAttributeValue("lastKnownParent"),
+		}
+	}
+
+	res := DirSyncEntry{DN: entry.DN, GUID: guid, Attributes: make(map[string][]string)}
+	for _, attr := range entry.Attributes {
+		name, options, _ := strings.Cut(attr.Name, ";")
+		if strings.EqualFold(name, "objectGUID") {
+			continue
+		}
+
+		values := make([]string, 0, len(attr.ByteValues))
+		for _, v := range attr.ByteValues {
+			values = append(values, dirSyncValueString(name, v))
+		}
+
+		switch {
+		case !strings.HasPrefix(strings.ToLower(options), "range="):
+			res.Attributes[name] = values
+		case strings.EqualFold(options, "range=0-0"):
+			if res.Removed == nil {
+				res.Removed = make(map[string][]string)
+			}
+			res.Removed[name] = append(res.Removed[name], values...)
+		default:
+			if res.Added == nil {
+				res.Added = make(map[string][]string)
+			}
+			res.Added[name] = append(res.Added[name], values...)
+		}
+	}
+
+	return res, nil
+}
+
+// dirSyncValueString - attr value as string (objectSid is decoded, other binary values are base64 encoded)
+func dirSyncValueString(attr string, value []byte) string {
+	if strings.EqualFold(attr, "objectSid") {
+		if sid, err := codec.DecodeSID(value); err == nil {
+			return sid.String()
+		}
+	}
+	return rawValueString(value)
+}
@@ -0,0 +1,100 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirSyncValidation(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := (&LdapConn{options: LdapConnOptions{OpenLDAP: true}}).DirSync(ctx, "dc=test", nil)
+	require.ErrorIs(t, err, ErrNotSupported)
+
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	_, err = conn.DirSync(ctx, "bad dn", nil)
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.DirSync(ctx, "dc=test", nil, DirSyncOptions{Filter: "(cn=a"})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestDirSync(t *testing.T) {
+	// server has changes for two responses (not zero flags - more changes)
+	var cookies []string
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		control, _ := ldap.FindControl(req.Controls, ldap.ControlTypeDirSync).(*ldap.ControlDirSync)
+		cookies = append(cookies, string(control.Cookie))
+
+		switch string(control.Cookie) {
+		case "prev":
+			return []testMessage{
+				entryMessage("CN=Ivan,DC=test", map[string][]string{"description": {"new"}}),
+				searchDone(ldap.LDAPResultSuccess, ldap.NewRequestControlDirSync(1, 0, []byte("next"))),
+			}
+		case "next":
+			return []testMessage{
+				entryMessage("CN=Petr DEL:1,CN=Deleted Objects,DC=test", map[string][]string{"isDeleted": {"TRUE"}}),
+				searchDone(ldap.LDAPResultSuccess, ldap.NewRequestControlDirSync(0, 0, []byte("last"))),
+			}
+		default:
+			return []testMessage{searchDone(ldap.LDAPResultSuccess)}
+		}
+	})
+
+	res, err := conn.DirSync(context.Background(), "DC=test", []byte("prev"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"prev", "next"}, cookies)
+	require.Equal(t, []byte("last"), res.Cookie)
+	require.Equal(t, []DirSyncEntry{{
+		DN: "CN=Ivan,DC=test", Attributes: map[string][]string{"description": {"new"}},
+	}}, res.Changed)
+	require.Equal(t, []DeletedObject{{DN: "CN=Petr DEL:1,CN=Deleted Objects,DC=test"}}, res.Deleted)
+	require.Equal(t, []string{
+		"search DC=test " + filterDirSyncAD + " []",
+		"search DC=test " + filterDirSyncAD + " []",
+	}, srv.Requests())
+
+	// response without dirsync control
+	_, err = conn.DirSync(context.Background(), "DC=test", []byte("unknown"))
+	require.ErrorContains(t, err, "no dirsync control")
+}
+
+func TestParseDirSyncEntry(t *testing.T) {
+	guid := string([]byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0})
+	sid := string([]byte{1, 2, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 1, 0, 0, 0})
+
+	changed, deleted := parseDirSyncEntry(&ldap.Entry{DN: "CN=Admins,DC=test", Attributes: []*ldap.EntryAttribute{
+		ldap.NewEntryAttribute("objectGUID", []string{guid}),
+		ldap.NewEntryAttribute("description", []string{"admins"}),
+		ldap.NewEntryAttribute("objectSid", []string{sid}),
+		ldap.NewEntryAttribute("member;range=1-1", []string{"CN=Ivan,DC=test", "CN=Olga,DC=test"}),
+		ldap.NewEntryAttribute("member;range=0-0", []string{"CN=Petr,DC=test"}),
+	}})
+	require.Nil(t, deleted)
+	require.Equal(t, DirSyncEntry{
+		DN:         "CN=Admins,DC=test",
+		GUID:       "12345678-1234-5678-1234-56789abcdef0",
+		Attributes: map[string][]string{"description": {"admins"}, "objectSid": {"S-1-5-21-1"}},
+		Added:      map[string][]string{"member": {"CN=Ivan,DC=test", "CN=Olga,DC=test"}},
+		Removed:    map[string][]string{"member": {"CN=Petr,DC=test"}},
+	}, changed)
+
+	_, deleted = parseDirSyncEntry(&ldap.Entry{DN: "CN=Ivan\\0ADEL:12345678-1234-5678-1234-56789abcdef0,CN=Deleted Objects,DC=test",
+		Attributes: []*ldap.EntryAttribute{
+			ldap.NewEntryAttribute("objectGUID", []string{guid}),
+			ldap.NewEntryAttribute("isDeleted", []string{"TRUE"}),
+			ldap.NewEntryAttribute("lastKnownParent", []string{"OU=Sales,DC=test"}),
+		}})
+	require.Equal(t, &DeletedObject{
+		DN:              "CN=Ivan\\0ADEL:12345678-1234-5678-1234-56789abcdef0,CN=Deleted Objects,DC=test",
+		GUID:            "12345678-1234-5678-1234-56789abcdef0",
+		LastKnownParent: "OU=Sales,DC=test",
+	}, deleted)
+}
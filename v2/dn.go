@@ -0,0 +1,146 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// DN utils
+
+// ParseDN Parse dn string (RFC 4514 escaping and whitespaces are handled)
+func ParseDN(dn string) (*ldap.DN, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, fmt.Errorf("bad dn %q: %s", dn, err.Error())
+	}
+
+	return parsed, nil
+}
+
+// CanonicalDN Get canonical dn form (normalized and lowercased), equal dns have equal canonical forms
+func CanonicalDN(dn string) (string, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+
+	return canonicalDN(parsed), nil
+}
+
+// ParentDN Get parent dn in normalized form ("" for one rdn dn)
+func ParentDN(dn string) (string, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+	if len(parsed.RDNs) == 0 {
+		return "", fmt.Errorf("bad dn %q: empty dn has no parent", dn)
+	}
+
+	parent := ldap.DN{RDNs: parsed.RDNs[1:]}
+
+	return parent.String(), nil
+}
+
+// RDN Get first rdn of dn in normalized form (e.g. "ou=Sales" for "OU=Sales,DC=test,DC=ru")
+func RDN(dn string) (string, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+	if len(parsed.RDNs) == 0 {
+		return "", fmt.Errorf("bad dn %q: empty dn has no rdn", dn)
+	}
+
+	return parsed.RDNs[0].String(), nil
+}
+
+// RDNValue Get unescaped value of first rdn attribute (e.g. "Sales" for "OU=Sales,DC=test,DC=ru")
+func RDNValue(dn string) (string, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+	if len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return "", fmt.Errorf("bad dn %q: empty dn has no rdn", dn)
+	}
+
+	return parsed.RDNs[0].Attributes[0].Value, nil
+}
+
+// EqualDN Check if dns are equal (case-insensitive)
+func EqualDN(dn, other string) (bool, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return false, err
+	}
+	parsedOther, err := ParseDN(other)
+	if err != nil {
+		return false, err
+	}
+
+	return parsed.EqualFold(parsedOther), nil
+}
+
+// IsAncestorDN Check if ancestor dn is a parent (at any level) of dn (case-insensitive, equal dns are not ancestors)
+func IsAncestorDN(ancestor, dn string) (bool, error) {
+	parsedAncestor, err := ParseDN(ancestor)
+	if err != nil {
+		return false, err
+	}
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return false, err
+	}
+
+	return parsedAncestor.AncestorOfFold(parsed), nil
+}
+
+// IsParentDN Check if parent dn is a direct parent of dn (case-insensitive)
+func IsParentDN(parent, dn string) (bool, error) {
+	parsedParent, err := ParseDN(parent)
+	if err != nil {
+		return false, err
+	}
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return false, err
+	}
+
+	return len(parsed.RDNs) == len(parsedParent.RDNs)+1 && parsedParent.AncestorOfFold(parsed), nil
+}
+
+// DomainDN Get domain root dn of dn (trailing dc rdns in normalized form, "" if dn has no dc rdns)
+func DomainDN(dn string) (string, error) {
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return "", err
+	}
+
+	i := len(parsed.RDNs)
+	for i > 0 {
+		rdn := parsed.RDNs[i-1]
+		if len(rdn.Attributes) != 1 || !strings.EqualFold(rdn.Attributes[0].Type, "dc") {
+			break
+		}
+		i--
+	}
+
+	domain := ldap.DN{RDNs: parsed.RDNs[i:]}
+
+	return domain.String(), nil
+}
+
+// canonicalDN - canonical form of parsed dn
+func canonicalDN(dn *ldap.DN) string {
+	return strings.ToLower(dn.String())
+}
+
+// isSubDN - check if dn is under group dn (false for bad dns)
+func isSubDN(group, dn string) bool {
+	ok, err := IsAncestorDN(group, dn)
+	return err == nil && ok
+}
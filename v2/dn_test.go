@@ -0,0 +1,119 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAncestorDN(t *testing.T) {
+	tests := []struct {
+		name     string
+		ancestor string
+		dn       string
+		expected bool
+		mustFail bool
+	}{
+		{
+			name:     "child",
+			ancestor: "OU=Sales,DC=corp",
+			dn:       "OU=East,OU=Sales,DC=corp",
+			expected: true,
+		},
+		{
+			name:     "different case and spaces",
+			ancestor: "ou=sales, dc=corp",
+			dn:       "OU=East,OU=Sales,DC=corp",
+			expected: true,
+		},
+		{
+			name:     "same dn",
+			ancestor: "OU=Sales,DC=corp",
+			dn:       "ou=sales,dc=corp",
+			expected: false,
+		},
+		{
+			name:     "substring only",
+			ancestor: "OU=Sales,DC=corp",
+			dn:       "OU=Sales,DC=corp,DC=other",
+			expected: false,
+		},
+		{
+			name:     "escaped comma",
+			ancestor: `OU=Sales\, East,DC=corp`,
+			dn:       `OU=Team,OU=Sales\2C East,DC=corp`,
+			expected: true,
+		},
+		{
+			name:     "bad dn",
+			ancestor: "OU=Sales,DC=corp",
+			dn:       "bad dn",
+			mustFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := IsAncestorDN(tt.ancestor, tt.dn)
+			if tt.mustFail {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, res)
+			}
+		})
+	}
+}
+
+func TestDNParts(t *testing.T) {
+	dn := `OU=Sales\, East , OU=Depts,DC=corp`
+
+	parent, err := ParentDN(dn)
+	require.NoError(t, err)
+	require.Equal(t, "ou=Depts,dc=corp", parent)
+
+	rdn, err := RDN(dn)
+	require.NoError(t, err)
+	require.Equal(t, `ou=Sales\, East`, rdn)
+
+	value, err := RDNValue(dn)
+	require.NoError(t, err)
+	require.Equal(t, "Sales, East", value)
+
+	canonical, err := CanonicalDN(dn)
+	require.NoError(t, err)
+	require.Equal(t, `ou=sales\, east,ou=depts,dc=corp`, canonical)
+
+	isParent, err := IsParentDN("ou=depts, dc=corp", dn)
+	require.NoError(t, err)
+	require.True(t, isParent)
+
+	isParent, err = IsParentDN("dc=corp", dn)
+	require.NoError(t, err)
+	require.False(t, isParent)
+
+	equal, err := EqualDN(dn, `ou=sales\2c east,ou=depts,dc=CORP`)
+	require.NoError(t, err)
+	require.True(t, equal)
+}
+
+func TestDomainDN(t *testing.T) {
+	tests := []struct {
+		name     string
+		dn       string
+		expected string
+	}{
+		{name: "user", dn: "CN=Ivan Ivanov,OU=Sales,DC=Corp,DC=test,DC=ru", expected: "dc=Corp,dc=test,dc=ru"},
+		{name: "domain", dn: "dc=test,dc=ru", expected: "dc=test,dc=ru"},
+		{name: "dc in the middle", dn: "cn=admin,dc=old,ou=people,o=test", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := DomainDN(tt.dn)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, res)
+		})
+	}
+}
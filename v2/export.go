@@ -0,0 +1,50 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/ldif"
+)
+
+////////////////////////////////////////////// LDIF export
+
+// ExportLDIF Write all entries matching filter in baseDN subtree to w as LDIF (streamed with paging),
+// returns count of written entries. Empty filter - all entries, empty attrs - all user attrs
+func (conn *LdapConn) ExportLDIF(ctx context.Context, baseDN, filter string, attrs []string, w io.Writer) (int, error) {
+	if err := validateDN(baseDN); err != nil {
+		return 0, err
+	}
+	if filter == "" {
+		filter = filterAny
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return 0, fmt.Errorf("%w: bad filter %q: %s", ErrInvalidInput, filter, err.Error())
+	}
+	baseDN, err := conn.resolveDN(ctx, baseDN)
+	if err != nil {
+		return 0, err
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attrs,
+		nil,
+	)
+
+	writer := ldif.NewWriter(w)
+	err = conn.searchPaged(ctx, searchRequest, writer.WriteEntry)
+	if err != nil {
+		// already written entries are kept
+		return writer.Records(), errors.Join(fmt.Errorf("bad export: %s", err.Error()), writer.Flush())
+	}
+
+	return writer.Records(), writer.Flush()
+}
@@ -0,0 +1,44 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportLDIF(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		switch pagingCookie(req) {
+		case "":
+			return []testMessage{
+				entryMessage("CN=Ivanov,DC=test,DC=ru", map[string][]string{"cn": {"Ivanov"}}),
+				searchDone(ldap.LDAPResultSuccess, nextPage("page 2")),
+			}
+		case "page 2":
+			return []testMessage{
+				entryMessage("CN=Petrov,DC=test,DC=ru", map[string][]string{"cn": {"Petrov"}}),
+				searchDone(ldap.LDAPResultSuccess, nextPage("page 3")),
+			}
+		default:
+			return []testMessage{searchDone(ldap.LDAPResultBusy)}
+		}
+	})
+
+	// entries of read pages are written on error
+	var buf bytes.Buffer
+	count, err := conn.ExportLDIF(context.Background(), "DC=test,DC=ru", "", []string{"cn"}, &buf)
+	require.Error(t, err)
+	require.Equal(t, 2, count)
+	require.Equal(t, "version: 1\n\n"+
+		"dn: CN=Ivanov,DC=test,DC=ru\ncn: Ivanov\n\n"+
+		"dn: CN=Petrov,DC=test,DC=ru\ncn: Petrov\n", buf.String())
+	require.Equal(t, []string{
+		"search DC=test,DC=ru (objectClass=*) [cn]",
+		"search DC=test,DC=ru (objectClass=*) [cn]",
+		"search DC=test,DC=ru (objectClass=*) [cn]",
+	}, srv.Requests())
+}
@@ -0,0 +1,361 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
+)
+
+////////////////////////////////////////////// Users export
+
+// ExportUsers Write users found in baseDN subtree to w as CSV or JSON Lines (streamed with paging),
+// returns count of written users
+func (conn *LdapConn) ExportUsers(ctx context.Context, baseDN string, w io.Writer, opts ...UsersExportOptions) (int, error) {
+	var exportOpts UsersExportOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		exportOpts = opts[0]
+	}
+
+	if err := validateDN(baseDN); err != nil {
+		return 0, err
+	}
+	filter := exportOpts.Filter
+	if filter == "" {
+		filter = conn.userFilter()
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return 0, fmt.Errorf("%w: bad filter %q: %s", ErrInvalidInput, filter, err.Error())
+	}
+
+	names := exportOpts.Columns
+	if len(names) == 0 {
+		names = defaultExportColumns
+	}
+	if len(exportOpts.Headers) > 0 && len(exportOpts.Headers) != len(names) {
+		return 0, fmt.Errorf("%w: %d headers for %d columns", ErrInvalidInput, len(exportOpts.Headers), len(names))
+	}
+
+	baseDN, err := conn.resolveDN(ctx, baseDN)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := conn.exportColumns(names)
+	writer, err := newRowWriter(w, exportOpts, names, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		columnsAttrs(columns),
+		nil,
+	)
+
+	count := 0
+	err = conn.searchPaged(ctx, searchRequest, func(entry *ldap.Entry) error {
+		row := make([][]string, len(columns))
+		for i, c := range columns {
+			row[i] = c.value(entry)
+		}
+		count++
+		return writer.write(row)
+	})
+	if err != nil {
+		// already written rows are kept
+		return count, errors.Join(fmt.Errorf("bad export: %s", err.Error()), writer.flush())
+	}
+
+	return count, writer.flush()
+}
+
+// userFilter - all users filter for conn server type
+func (conn *LdapConn) userFilter() string {
+	if conn.options.OpenLDAP {
+		return filterUserOpenLDAP
+	}
+	return filterUserAD
+}
+
+////////////////////////////////////////////// Export columns
+
+// exportColumn - export column with attrs to read and value getter
+type exportColumn struct {
+	attrs []string
+	value func(entry *ldap.Entry) []string
+	multi bool // raw attr column, its values can be many (JSON Lines array)
+}
+
+// exportColumns - resolve column names: special columns, UserFullInfo fields (json names) and raw attrs
+func (conn *LdapConn) exportColumns(names []string) []exportColumn {
+	res := make([]exportColumn, 0, len(names))
+	for _, name := range names {
+		res = append(res, conn.exportColumn(name))
+	}
+	return res
+}
+
+// exportColumn - resolve one column name
+func (conn *LdapConn) exportColumn(name string) exportColumn {
+	openLDAP := conn.options.OpenLDAP
+
+	switch name {
+	case "distinguishedName":
+		return exportColumn{value: func(entry *ldap.Entry) []string { return []string{entry.DN} }}
+	case "name":
+		return rawColumn("cn")
+	case "login":
+		if openLDAP {
+			return rawColumn("uid")
+		}
+		return rawColumn("userPrincipalName")
+	case "objectGUID":
+		if openLDAP {
+			return rawColumn("entryUUID")
+		}
+		return exportColumn{attrs: []string{"objectGUID"}, value: func(entry *ldap.Entry) []string {
+			guid, err := codec.DecodeGUID(entry.GetRawAttributeValue("objectGUID"))
+			if err != nil {
+				return nil
+			}
+			return []string{guid.String()}
+		}}
+	case "objectSid":
+		if openLDAP {
+			return rawColumn("sambaSID")
+		}
+		return exportColumn{attrs: []string{"objectSid"}, value: func(entry *ldap.Entry) []string {
+			sid, err := codec.DecodeSID(entry.GetRawAttributeValue("objectSid"))
+			if err != nil {
+				return nil
+			}
+			return []string{sid.String()}
+		}}
+	case "enabled", "locked", "expired":
+		return conn.statusColumn(name)
+	}
+
+	if f, ok := userFieldByName(name); ok {
+		return rawColumn(f.attr(openLDAP))
+	}
+
+	res := rawColumn(name)
+	res.multi = true
+	return res
+}
+
+// statusColumn - account state column ("true"/"false")
+func (conn *LdapConn) statusColumn(name string) exportColumn {
+	attrs := ADAccountStatusAttrs
+	if conn.options.OpenLDAP {
+		attrs = openLDAPAccountStatusAttrs
+	}
+
+	return exportColumn{attrs: attrs, value: func(entry *ldap.Entry) []string {
+		var status AccountStatus
+		if conn.options.OpenLDAP {
+			status = accountStatusOpenLDAP(entry, time.Now())
+		} else {
+			status = accountStatusAD(entry, time.Now())
+		}
+
+		var v bool
+		switch name {
+		case "enabled":
+			v = status.Enabled
+		case "locked":
+			v = status.Locked
+		case "expired":
+			v = status.Expired
+		}
+		return []string{strconv.FormatBool(v)}
+	}}
+}
+
+// rawColumn - column of raw attr values (not utf-8 values are base64 encoded)
+func rawColumn(attr string) exportColumn {
+	return exportColumn{attrs: []string{attr}, value: func(entry *ldap.Entry) []string {
+		values := entry.GetRawAttributeValues(attr)
+		res := make([]string, 0, len(values))
+		for _, v := range values {
+			res = append(res, rawValueString(v))
+		}
+		return res
+	}}
+}
+
+// rawValueString - attr value as string (not utf-8 values are base64 encoded)
+func rawValueString(value []byte) string {
+	if utf8.Valid(value) {
+		return string(value)
+	}
+	return base64.StdEncoding.EncodeToString(value)
+}
+
+// columnsAttrs - attrs to read for columns (without duplicates)
+func columnsAttrs(columns []exportColumn) []string {
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, c := range columns {
+		for _, attr := range c.attrs {
+			if !seen[strings.ToLower(attr)] {
+				seen[strings.ToLower(attr)] = true
+				res = append(res, attr)
+			}
+		}
+	}
+	if len(res) == 0 {
+		// dn only
+		res = append(res, "1.1")
+	}
+	return res
+}
+
+////////////////////////////////////////////// Row writers
+
+// rowWriter - export rows writer (CSV or JSON Lines)
+type rowWriter interface {
+	write(row [][]string) error
+	flush() error
+}
+
+// newRowWriter - create writer for export format (CSV header is written)
+func newRowWriter(w io.Writer, opts UsersExportOptions, names []string, columns []exportColumn) (rowWriter, error) {
+	separator := opts.Separator
+	if separator == "" {
+		separator = "; "
+	}
+
+	switch opts.Format {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if opts.Comma != 0 {
+			writer.Comma = opts.Comma
+		}
+		res := &csvWriter{w: writer, separator: separator, excelSafe: opts.ExcelSafe}
+
+		if !opts.NoHeader {
+			headers := opts.Headers
+			if len(headers) == 0 {
+				headers = names
+			}
+			if err := res.writeRecord(headers); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case ExportJSONLines:
+		multi := make([]bool, len(columns))
+		for i, c := range columns {
+			multi[i] = c.multi
+		}
+		return &jsonLinesWriter{w: bufio.NewWriter(w), names: names, multi: multi}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown export format %d", ErrInvalidInput, opts.Format)
+	}
+}
+
+// csvWriter - CSV rows writer
+type csvWriter struct {
+	w         *csv.Writer
+	separator string
+	excelSafe bool
+}
+
+func (c *csvWriter) write(row [][]string) error {
+	record := make([]string, len(row))
+	for i, values := range row {
+		record[i] = strings.Join(values, c.separator)
+	}
+	return c.writeRecord(record)
+}
+
+func (c *csvWriter) writeRecord(record []string) error {
+	if c.excelSafe {
+		for i, v := range record {
+			record[i] = excelSafe(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonLinesWriter - JSON Lines rows writer (keys in columns order). Column shape doesn't depend on row:
+// raw attr columns are arrays, others are strings (first value, "" if there is none)
+type jsonLinesWriter struct {
+	w     *bufio.Writer
+	names []string
+	multi []bool
+}
+
+func (j *jsonLinesWriter) write(row [][]string) error {
+	var b strings.Builder
+	b.WriteString("{")
+	for i, values := range row {
+		if i > 0 {
+			b.WriteString(",")
+		}
+
+		key, err := json.Marshal(j.names[i])
+		if err != nil {
+			return err
+		}
+
+		var value []byte
+		switch {
+		case j.multi[i]:
+			if values == nil {
+				values = []string{}
+			}
+			value, err = json.Marshal(values)
+		case len(values) == 0:
+			value, err = json.Marshal("")
+		default:
+			value, err = json.Marshal(values[0])
+		}
+		if err != nil {
+			return err
+		}
+
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+
+	_, err := j.w.WriteString(b.String())
+	return err
+}
+
+func (j *jsonLinesWriter) flush() error {
+	return j.w.Flush()
+}
+
+// excelSafe - prefix values which spreadsheets treat as formulas with ' (CSV injection protection)
+func excelSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,162 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportUsersValidation(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	ctx := context.Background()
+	var buf bytes.Buffer
+
+	_, err := conn.ExportUsers(ctx, "bad dn", &buf)
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.ExportUsers(ctx, "dc=test", &buf, UsersExportOptions{Filter: "(cn=a"})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.ExportUsers(ctx, "dc=test", &buf, UsersExportOptions{Columns: []string{"name"}, Headers: []string{"a", "b"}})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.ExportUsers(ctx, "dc=test", &buf, UsersExportOptions{Format: ExportFormat(10)})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestExportUsers(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		switch pagingCookie(req) {
+		case "":
+			return []testMessage{
+				entryMessage("CN=Ivanov,DC=test,DC=ru", map[string][]string{
+					"cn": {"Ivanov"}, "mail": {"ivanov@test.ru"}, "proxyAddresses": {"smtp:a@test", "smtp:b@test"},
+				}),
+				searchDone(ldap.LDAPResultSuccess, nextPage("page 2")),
+			}
+		case "page 2":
+			return []testMessage{
+				entryMessage("CN=Petrov,DC=test,DC=ru", map[string][]string{
+					"cn": {"Petrov"}, "proxyAddresses": {"smtp:p@test"},
+				}),
+				entryMessage("CN=Sidorov,DC=test,DC=ru", map[string][]string{"cn": {"Sidorov"}}),
+				searchDone(ldap.LDAPResultSuccess, nextPage("page 3")),
+			}
+		default:
+			return []testMessage{searchDone(ldap.LDAPResultBusy)}
+		}
+	})
+
+	// rows of read pages are written on error, column shape doesn't depend on values count
+	var buf bytes.Buffer
+	count, err := conn.ExportUsers(context.Background(), "DC=test,DC=ru", &buf, UsersExportOptions{
+		Format:  ExportJSONLines,
+		Columns: []string{"name", "mail", "proxyAddresses"},
+	})
+	require.Error(t, err)
+	require.Equal(t, 3, count)
+	require.Equal(t, `{"name":"Ivanov","mail":"ivanov@test.ru","proxyAddresses":["smtp:a@test","smtp:b@test"]}
+{"name":"Petrov","mail":"","proxyAddresses":["smtp:p@test"]}
+{"name":"Sidorov","mail":"","proxyAddresses":[]}
+`, buf.String())
+
+	require.Equal(t, []string{
+		"search DC=test,DC=ru " + filterUserAD + " [cn mail proxyAddresses]",
+		"search DC=test,DC=ru " + filterUserAD + " [cn mail proxyAddresses]",
+		"search DC=test,DC=ru " + filterUserAD + " [cn mail proxyAddresses]",
+	}, srv.Requests())
+}
+
+func TestExportColumns(t *testing.T) {
+	entry := ldap.NewEntry("cn=Ivan,dc=test", map[string][]string{
+		"cn":                {"Ivan"},
+		"userPrincipalName": {"ivan@test"},
+		"uid":               {"ivan"},
+		"department":        {"IT"},
+		"departmentNumber":  {"42"},
+		"proxyAddresses":    {"smtp:a@test", "smtp:b@test"},
+		"objectSid":         {string([]byte{1, 3, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0})},
+		"binary":            {string([]byte{0xff, 0xfe})},
+	})
+
+	ad := &LdapConn{}
+	openLDAP := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+
+	tests := []struct {
+		conn   *LdapConn
+		column string
+		want   []string
+	}{
+		{ad, "distinguishedName", []string{"cn=Ivan,dc=test"}},
+		{ad, "name", []string{"Ivan"}},
+		{ad, "login", []string{"ivan@test"}},
+		{openLDAP, "login", []string{"ivan"}},
+		{ad, "department", []string{"IT"}},
+		{openLDAP, "department", []string{"42"}},
+		{ad, "proxyAddresses", []string{"smtp:a@test", "smtp:b@test"}},
+		{ad, "objectSid", []string{"S-1-5-21-1-2"}},
+		{ad, "binary", []string{"//4="}},
+		{ad, "missing", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.column, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.conn.exportColumn(tt.column).value(entry))
+		})
+	}
+
+	require.Equal(t, []string{"1.1"}, columnsAttrs(ad.exportColumns([]string{"distinguishedName"})))
+	require.Equal(t, []string{"cn", "userPrincipalName"}, columnsAttrs(ad.exportColumns([]string{"name", "login", "CN"})))
+}
+
+func TestRowWriters(t *testing.T) {
+	names := []string{"name", "proxyAddresses"}
+	columns := (&LdapConn{}).exportColumns(names)
+	row := [][]string{{"=cmd|' /C calc'!A0"}, {"a@test", "b@test"}}
+
+	var buf bytes.Buffer
+	w, err := newRowWriter(&buf, UsersExportOptions{ExcelSafe: true, Comma: ';', Headers: []string{"Name", "Mail"}}, names, columns)
+	require.NoError(t, err)
+	require.NoError(t, w.write(row))
+	require.NoError(t, w.flush())
+	require.Equal(t, "Name;Mail\n'=cmd|' /C calc'!A0;\"a@test; b@test\"\n", buf.String())
+
+	buf.Reset()
+	w, err = newRowWriter(&buf, UsersExportOptions{NoHeader: true, Separator: "|"}, names, columns)
+	require.NoError(t, err)
+	require.NoError(t, w.write(row))
+	require.NoError(t, w.flush())
+	require.Equal(t, "=cmd|' /C calc'!A0,a@test|b@test\n", buf.String())
+
+	buf.Reset()
+	w, err = newRowWriter(&buf, UsersExportOptions{Format: ExportJSONLines}, names, columns)
+	require.NoError(t, err)
+	require.NoError(t, w.write(row))
+	require.NoError(t, w.write([][]string{{"b"}, {"c@test"}}))
+	require.NoError(t, w.write([][]string{nil, nil}))
+	require.NoError(t, w.flush())
+	require.Equal(t, `{"name":"=cmd|' /C calc'!A0","proxyAddresses":["a@test","b@test"]}
+{"name":"b","proxyAddresses":["c@test"]}
+{"name":"","proxyAddresses":[]}
+`, buf.String())
+}
+
+func TestExcelSafe(t *testing.T) {
+	for in, want := range map[string]string{
+		"":        "",
+		"=1+2":    "'=1+2",
+		"+7 999":  "'+7 999",
+		"-1":      "'-1",
+		"@SUM(1)": "'@SUM(1)",
+		"\tx":     "'\tx",
+		"a=b":     "a=b",
+	} {
+		require.Equal(t, want, excelSafe(in))
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Group members management
+
+// AddToGroup Add members to group (already added members are skipped).
+// Member attr is chosen by group kind: member (AD, groupOfNames), uniqueMember or memberUid (posixGroup)
+func (conn *LdapConn) AddToGroup(ctx context.Context, groupDN string, memberDNs ...string) error {
+	if err := validateMembersRequest(groupDN, memberDNs); err != nil {
+		return err
+	}
+
+	groupDN, attr, err := conn.groupMemberAttr(ctx, groupDN)
+	if err != nil {
+		return err
+	}
+	values, err := conn.memberValues(ctx, attr, memberDNs)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.modifyMembers(ctx, groupDN, attr, values, true)
+	return err
+}
+
+// RemoveFromGroup Remove members from group (not members are skipped)
+func (conn *LdapConn) RemoveFromGroup(ctx context.Context, groupDN string, memberDNs ...string) error {
+	if err := validateMembersRequest(groupDN, memberDNs); err != nil {
+		return err
+	}
+
+	groupDN, attr, err := conn.groupMemberAttr(ctx, groupDN)
+	if err != nil {
+		return err
+	}
+	values, err := conn.memberValues(ctx, attr, memberDNs)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.modifyMembers(ctx, groupDN, attr, values, false)
+	return err
+}
+
+// SetGroupMembers Make group members equal to memberDNs with minimal changes (new members are added before
+// old ones are removed), returns applied change (partially applied one on error)
+func (conn *LdapConn) SetGroupMembers(ctx context.Context, groupDN string, memberDNs []string) (res MembersChange, err error) {
+	res = MembersChange{Added: make([]string, 0), Removed: make([]string, 0)}
+
+	if err = validateDN(groupDN); err != nil {
+		return res, err
+	}
+	for _, dn := range memberDNs {
+		if err = validateDN(dn); err != nil {
+			return res, err
+		}
+	}
+
+	groupDN, attr, err := conn.groupMemberAttr(ctx, groupDN)
+	if err != nil {
+		return res, err
+	}
+	desired, err := conn.memberValues(ctx, attr, memberDNs)
+	if err != nil {
+		return res, err
+	}
+	current, err := conn.currentMemberValues(ctx, groupDN, attr)
+	if err != nil {
+		return res, err
+	}
+
+	change, err := diffMembers(attr, current, desired)
+	if err != nil {
+		return res, err
+	}
+
+	if res.Added, err = conn.modifyMembers(ctx, groupDN, attr, change.Added, true); err != nil {
+		return res, err
+	}
+	if res.Removed, err = conn.modifyMembers(ctx, groupDN, attr, change.Removed, false); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// groupMemberAttr - get resolved group dn and member attr of group by its kind
+func (conn *LdapConn) groupMemberAttr(ctx context.Context, groupDN string) (string, string, error) {
+	groupDN, err := conn.resolveDN(ctx, groupDN)
+	if err != nil {
+		return "", "", err
+	}
+	if !conn.options.OpenLDAP {
+		return groupDN, "member", nil
+	}
+
+	group, err := conn.readEntry(ctx, groupDN, []string{"objectClass"})
+	if err != nil {
+		return "", "", err
+	}
+
+	classes := make(map[string]bool)
+	for _, c := range group.GetAttributeValues("objectClass") {
+		classes[strings.ToLower(c)] = true
+	}
+
+	// rfc2307bis groups are groupOfNames and posixGroup both, member is used for them
+	switch {
+	case classes["groupofnames"]:
+		return groupDN, "member", nil
+	case classes["groupofuniquenames"]:
+		return groupDN, "uniqueMember", nil
+	case classes["posixgroup"]:
+		return groupDN, "memberUid", nil
+	default:
+		return "", "", fmt.Errorf("%w: %q is not a group", ErrInvalidInput, groupDN)
+	}
+}
+
+// memberValues - make member attr values for member dns (real dns of extended dn forms, uids for memberUid)
+func (conn *LdapConn) memberValues(ctx context.Context, attr string, memberDNs []string) ([]string, error) {
+	res := make([]string, 0, len(memberDNs))
+	if attr != "memberUid" {
+		for _, dn := range memberDNs {
+			dn, err := conn.resolveDN(ctx, dn)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, dn)
+		}
+		return res, nil
+	}
+
+	for _, dn := range memberDNs {
+		entry, err := conn.readEntry(ctx, dn, []string{"uid"})
+		if err != nil {
+			return nil, err
+		}
+		uid := entry.GetAttributeValue("uid")
+		if uid == "" {
+			return nil, fmt.Errorf("%w: member %q has no uid", ErrInvalidInput, dn)
+		}
+		res = append(res, uid)
+	}
+
+	return res, nil
+}
+
+// currentMemberValues - read all current member attr values of group
+func (conn *LdapConn) currentMemberValues(ctx context.Context, groupDN, attr string) ([]string, error) {
+	if !conn.options.OpenLDAP {
+		return conn.getRangedValues(ctx, groupDN, attr)
+	}
+
+	group, err := conn.readEntry(ctx, groupDN, []string{attr})
+	if err != nil {
+		return nil, err
+	}
+
+	return group.GetAttributeValues(attr), nil
+}
+
+// modifyMembers - add (remove) member values by chunks, already added (not found) values are skipped.
+// Returns actually applied values (applied before error on error)
+func (conn *LdapConn) modifyMembers(ctx context.Context, groupDN, attr string, values []string, add bool) ([]string, error) {
+	applied := make([]string, 0, len(values))
+	for len(values) > 0 {
+		chunk := values[:min(len(values), memberChunkSize)]
+		values = values[len(chunk):]
+
+		err := conn.applyMembers(ctx, groupDN, attr, chunk, add)
+		if conn.isMembersNoop(err, add) && len(chunk) > 1 {
+			// whole request fails if some values are already there (not there), apply them one by one
+			for _, v := range chunk {
+				err = conn.applyMembers(ctx, groupDN, attr, []string{v}, add)
+				if err != nil && !conn.isMembersNoop(err, add) {
+					return applied, fmt.Errorf("bad members modify: %s", err.Error())
+				}
+				if err == nil {
+					applied = append(applied, v)
+				}
+			}
+			continue
+		}
+		if err != nil && !conn.isMembersNoop(err, add) {
+			return applied, fmt.Errorf("bad members modify: %s", err.Error())
+		}
+		if err == nil {
+			applied = append(applied, chunk...)
+		}
+	}
+
+	return applied, nil
+}
+
+// applyMembers - run one members modify request
+func (conn *LdapConn) applyMembers(ctx context.Context, groupDN, attr string, values []string, add bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(groupDN, nil)
+	if add {
+		modifyRequest.Add(attr, values)
+	} else {
+		modifyRequest.Delete(attr, values)
+	}
+
+	return conn.Connection.Modify(modifyRequest)
+}
+
+// isMembersNoop - check if members modify error means "already a member" ("not a member") for add (remove)
+func (conn *LdapConn) isMembersNoop(err error, add bool) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToUpper(err.Error())
+	if add {
+		return ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists) ||
+			ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) ||
+			(!conn.options.OpenLDAP && strings.Contains(msg, adErrMemberInGroup))
+	}
+
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute) ||
+		(!conn.options.OpenLDAP && strings.Contains(msg, adErrMemberNotInGrp))
+}
+
+// diffMembers - get values to add and to remove for making current members equal to desired ones.
+// Desired dns must be real ones: extended dn form never matches current member, so it would be removed
+func diffMembers(attr string, current, desired []string) (MembersChange, error) {
+	res := MembersChange{Added: make([]string, 0), Removed: make([]string, 0)}
+	for _, v := range desired {
+		if attr != "memberUid" && isExtendedDNForm(v) {
+			return res, fmt.Errorf("%w: member %q is not resolved", ErrInvalidInput, v)
+		}
+	}
+
+	currentKeys := make(map[string]bool, len(current))
+	for _, v := range current {
+		currentKeys[memberKey(attr, v)] = true
+	}
+
+	desiredKeys := make(map[string]bool, len(desired))
+	for _, v := range desired {
+		key := memberKey(attr, v)
+		if desiredKeys[key] {
+			continue
+		}
+		desiredKeys[key] = true
+
+		if !currentKeys[key] {
+			res.Added = append(res.Added, v)
+		}
+	}
+
+	for _, v := range current {
+		if !desiredKeys[memberKey(attr, v)] {
+			// stored value is used for removing (uniqueMember can have uid suffix)
+			res.Removed = append(res.Removed, v)
+		}
+	}
+
+	return res, nil
+}
+
+// memberKey - member value comparison key (dn key for dn values, uid as is)
+func memberKey(attr, value string) string {
+	switch attr {
+	case "memberUid":
+		return value
+	case "uniqueMember":
+		value = uniqueMemberDN(value)
+	}
+
+	return dnKey(value)
+}
+
+// uniqueMemberDN - dn of uniqueMember value (nameAndOptionalUID syntax: dn#'0101'B)
+func uniqueMemberDN(value string) string {
+	if i := strings.LastIndex(value, "#'"); i > 0 {
+		return value[:i]
+	}
+	return value
+}
+
+// validateMembersRequest - check group and members dns
+func validateMembersRequest(groupDN string, memberDNs []string) error {
+	if err := validateDN(groupDN); err != nil {
+		return err
+	}
+	if len(memberDNs) == 0 {
+		return fmt.Errorf("%w: empty members list", ErrInvalidInput)
+	}
+	for _, dn := range memberDNs {
+		if err := validateDN(dn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
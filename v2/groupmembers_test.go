@@ -0,0 +1,190 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffMembers(t *testing.T) {
+	tests := []struct {
+		name     string
+		attr     string
+		current  []string
+		desired  []string
+		expected MembersChange
+		mustFail bool
+	}{
+		{
+			name:    "dn members",
+			attr:    "member",
+			current: []string{"CN=Ivanov,DC=test,DC=ru", "CN=Petrov,DC=test,DC=ru"},
+			desired: []string{"cn=ivanov, dc=test, dc=ru", "CN=Sidorov,DC=test,DC=ru", "CN=Sidorov,DC=test,DC=ru"},
+			expected: MembersChange{
+				Added:   []string{"CN=Sidorov,DC=test,DC=ru"},
+				Removed: []string{"CN=Petrov,DC=test,DC=ru"},
+			},
+		},
+		{
+			name:    "unique members",
+			attr:    "uniqueMember",
+			current: []string{"cn=ivanov,dc=test,dc=ru#'0101'B", "cn=petrov,dc=test,dc=ru"},
+			desired: []string{"cn=ivanov,dc=test,dc=ru"},
+			expected: MembersChange{
+				Added:   []string{},
+				Removed: []string{"cn=petrov,dc=test,dc=ru"},
+			},
+		},
+		{
+			name:    "posix uids",
+			attr:    "memberUid",
+			current: []string{"ivanov", "petrov"},
+			desired: []string{"petrov", "Ivanov"},
+			expected: MembersChange{
+				Added:   []string{"Ivanov"},
+				Removed: []string{"ivanov"},
+			},
+		},
+		{
+			name:     "no changes",
+			attr:     "member",
+			current:  []string{"CN=Ivanov,DC=test,DC=ru"},
+			desired:  []string{"CN=Ivanov,DC=test,DC=ru"},
+			expected: MembersChange{Added: []string{}, Removed: []string{}},
+		},
+		{
+			// <GUID=...> of Ivanov doesn't match his dn, Ivanov would be removed
+			name:     "unresolved extended dn",
+			attr:     "member",
+			current:  []string{"CN=Ivanov,DC=test,DC=ru", "CN=Petrov,DC=test,DC=ru"},
+			desired:  []string{"<GUID=33221100-5544-7766-8899-aabbccddeeff>", "CN=Petrov,DC=test,DC=ru"},
+			mustFail: true,
+		},
+		{
+			name:    "extended dn resolved",
+			attr:    "member",
+			current: []string{"CN=Ivanov,DC=test,DC=ru", "CN=Petrov,DC=test,DC=ru"},
+			desired: []string{"cn=ivanov,dc=test,dc=ru", "CN=Petrov,DC=test,DC=ru"},
+			expected: MembersChange{
+				Added:   []string{},
+				Removed: []string{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := diffMembers(tt.attr, tt.current, tt.desired)
+			if tt.mustFail {
+				require.ErrorIs(t, err, ErrInvalidInput)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, res)
+			}
+		})
+	}
+}
+
+func TestIsMembersNoop(t *testing.T) {
+	ad := &LdapConn{}
+	openLDAP := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+
+	exists := ldap.NewError(ldap.LDAPResultAttributeOrValueExists, errors.New("value exists"))
+	adInGroup := ldap.NewError(ldap.LDAPResultEntryAlreadyExists, errors.New("00000562: UpdErr: DSID-031A11C4"))
+	noAttr := ldap.NewError(ldap.LDAPResultNoSuchAttribute, errors.New("no such attribute"))
+	adNotInGroup := ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("00000561: SvcErr: DSID-031A1236"))
+	other := ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("access denied"))
+
+	require.True(t, ad.isMembersNoop(exists, true))
+	require.True(t, ad.isMembersNoop(adInGroup, true))
+	require.True(t, openLDAP.isMembersNoop(exists, true))
+	require.False(t, ad.isMembersNoop(exists, false))
+
+	require.True(t, openLDAP.isMembersNoop(noAttr, false))
+	require.True(t, ad.isMembersNoop(adNotInGroup, false))
+	require.False(t, openLDAP.isMembersNoop(adNotInGroup, false))
+
+	require.False(t, ad.isMembersNoop(other, true))
+	require.False(t, ad.isMembersNoop(nil, true))
+}
+
+func TestMembersValidation(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	ctx := context.Background()
+
+	require.ErrorIs(t, conn.AddToGroup(ctx, "CN=Admins,DC=test,DC=ru"), ErrInvalidInput)
+	require.ErrorIs(t, conn.AddToGroup(ctx, "bad dn", "CN=Ivanov,DC=test,DC=ru"), ErrInvalidInput)
+	require.ErrorIs(t, conn.RemoveFromGroup(ctx, "CN=Admins,DC=test,DC=ru", "bad dn"), ErrInvalidInput)
+
+	res, err := conn.SetGroupMembers(ctx, "CN=Admins,DC=test,DC=ru", []string{"bad dn"})
+	require.ErrorIs(t, err, ErrInvalidInput)
+	require.Equal(t, MembersChange{Added: []string{}, Removed: []string{}}, res)
+
+	// members are resolved before diff (openLdap has no extended dn forms)
+	openLDAP := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+	_, err = openLDAP.memberValues(ctx, "member", []string{"<SID=S-1-5-32-544>"})
+	require.ErrorIs(t, err, ErrInvalidInput)
+	values, err := conn.memberValues(ctx, "member", []string{"CN=Ivanov,DC=test,DC=ru"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"CN=Ivanov,DC=test,DC=ru"}, values)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestMembersRequests(t *testing.T) {
+	ctx := context.Background()
+
+	// chunk with already added member fails as a whole and is applied one by one
+	ad, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		if strings.Contains(describeRequest(req.Op), "CN=Petrov") {
+			return []testMessage{resultMessage(ldap.ApplicationModifyResponse, ldap.LDAPResultEntryAlreadyExists)}
+		}
+		return nil
+	})
+	require.NoError(t, ad.AddToGroup(ctx, "CN=Admins,DC=test,DC=ru", "CN=Ivanov,DC=test,DC=ru", "CN=Petrov,DC=test,DC=ru"))
+	require.Equal(t, []string{
+		"modify CN=Admins,DC=test,DC=ru: add member [CN=Ivanov,DC=test,DC=ru CN=Petrov,DC=test,DC=ru]",
+		"modify CN=Admins,DC=test,DC=ru: add member [CN=Ivanov,DC=test,DC=ru]",
+		"modify CN=Admins,DC=test,DC=ru: add member [CN=Petrov,DC=test,DC=ru]",
+	}, srv.Requests())
+
+	// posixGroup members are uids
+	openLDAP, srv := newTestConn(t, LdapConnOptions{OpenLDAP: true}, func(req testRequest) []testMessage {
+		switch describeRequest(req.Op) {
+		case "search cn=admins,dc=test,dc=ru (objectClass=*) [objectClass]":
+			return []testMessage{entryMessage("cn=admins,dc=test,dc=ru", map[string][]string{
+				"objectClass": {"top", "posixGroup"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "search uid=ivanov,dc=test,dc=ru (objectClass=*) [uid]":
+			return []testMessage{entryMessage("uid=ivanov,dc=test,dc=ru", map[string][]string{
+				"uid": {"ivanov"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "search uid=sidorov,dc=test,dc=ru (objectClass=*) [uid]":
+			return []testMessage{entryMessage("uid=sidorov,dc=test,dc=ru", map[string][]string{
+				"uid": {"sidorov"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "search cn=admins,dc=test,dc=ru (objectClass=*) [memberUid]":
+			return []testMessage{entryMessage("cn=admins,dc=test,dc=ru", map[string][]string{
+				"memberUid": {"ivanov", "petrov"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		}
+		return nil
+	})
+	res, err := openLDAP.SetGroupMembers(ctx, "cn=admins,dc=test,dc=ru",
+		[]string{"uid=ivanov,dc=test,dc=ru", "uid=sidorov,dc=test,dc=ru"})
+	require.NoError(t, err)
+	require.Equal(t, MembersChange{Added: []string{"sidorov"}, Removed: []string{"petrov"}}, res)
+	require.Equal(t, []string{
+		"search cn=admins,dc=test,dc=ru (objectClass=*) [objectClass]",
+		"search uid=ivanov,dc=test,dc=ru (objectClass=*) [uid]",
+		"search uid=sidorov,dc=test,dc=ru (objectClass=*) [uid]",
+		"search cn=admins,dc=test,dc=ru (objectClass=*) [memberUid]",
+		"modify cn=admins,dc=test,dc=ru: add memberUid [sidorov]",
+		"modify cn=admins,dc=test,dc=ru: delete memberUid [petrov]",
+	}, srv.Requests())
+}
@@ -0,0 +1,474 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/ldif"
+)
+
+////////////////////////////////////////////// LDIF import
+
+// ImportLDIF Apply LDIF records (content and add/modify/delete/modrdn change records) in order.
+// All records are parsed and validated before the first request. Import stops on the first failed record
+// (with rollback of applied ones if ImportOptions.Rollback) unless ImportOptions.ContinueOnError
+func (conn *LdapConn) ImportLDIF(ctx context.Context, r io.Reader, opts ...ImportOptions) (res ImportResult, err error) {
+	var importOpts ImportOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		importOpts = opts[0]
+	}
+	if importOpts.ContinueOnError && importOpts.Rollback {
+		return res, fmt.Errorf("%w: rollback can't be used with continue on error", ErrInvalidInput)
+	}
+
+	records, err := ldif.NewReader(r).ReadAll()
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+
+	res.Records = make([]RecordResult, len(records))
+	valid := make([]bool, len(records))
+	var firstErr error
+	for i, rec := range records {
+		res.Records[i] = RecordResult{Line: rec.Line, DN: rec.DN, Type: string(rec.Type), Status: ImportSkipped}
+
+		err = validateRecord(rec, importOpts.Rollback)
+		if err != nil {
+			res.fail(i, err)
+			firstErr = cmp.Or(firstErr, recordError(rec, err))
+			continue
+		}
+		valid[i] = true
+	}
+	if firstErr != nil && !importOpts.ContinueOnError {
+		return res, firstErr
+	}
+
+	undo := make([]undoRecord, 0)
+	state := newDryRunState()
+	for i, rec := range records {
+		if !valid[i] {
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return res, err
+		}
+
+		if importOpts.DryRun {
+			err = conn.checkRecord(ctx, state, rec)
+		} else {
+			var inverse *ldif.Record
+			inverse, err = conn.applyRecord(ctx, rec, importOpts.Rollback)
+			if err == nil && inverse != nil {
+				undo = append(undo, undoRecord{index: i, inverse: inverse})
+			}
+		}
+
+		if err == nil {
+			res.Records[i].Status = ImportApplied
+			if importOpts.DryRun {
+				res.Records[i].Status = ImportPlanned
+			}
+			res.Applied++
+			continue
+		}
+
+		res.fail(i, err)
+		firstErr = cmp.Or(firstErr, recordError(rec, err))
+		if importOpts.ContinueOnError {
+			continue
+		}
+
+		if importOpts.Rollback {
+			if rollbackErr := conn.rollback(ctx, undo, &res); rollbackErr != nil {
+				return res, errors.Join(firstErr, rollbackErr)
+			}
+		}
+		return res, firstErr
+	}
+
+	return res, nil
+}
+
+// undoRecord - inverse record of applied one
+type undoRecord struct {
+	index   int // applied record index
+	inverse *ldif.Record
+}
+
+// rollback - apply inverse records in reverse order
+func (conn *LdapConn) rollback(ctx context.Context, undo []undoRecord, res *ImportResult) error {
+	var errs []error
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		if _, err := conn.applyRecord(ctx, u.inverse, false); err != nil {
+			res.Records[u.index].Error = fmt.Sprintf("rollback: %s", err.Error())
+			errs = append(errs, fmt.Errorf("rollback of record at line %d: %w", res.Records[u.index].Line, err))
+			continue
+		}
+		res.Records[u.index].Status = ImportRolledBack
+		res.Applied--
+		res.RolledBack++
+	}
+
+	return errors.Join(errs...)
+}
+
+// fail - mark record as failed
+func (res *ImportResult) fail(i int, err error) {
+	res.Records[i].Status = ImportFailed
+	res.Records[i].Error = err.Error()
+	res.Failed++
+}
+
+// recordError - error of record with its position
+func recordError(rec *ldif.Record, err error) error {
+	return fmt.Errorf("record at line %d (%s %s): %w", rec.Line, rec.Type, rec.DN, err)
+}
+
+////////////////////////////////////////////// Records checks
+
+// validateRecord - check record dns (and rollback possibility if rollback is needed)
+func validateRecord(rec *ldif.Record, rollback bool) error {
+	if err := validateDN(rec.DN); err != nil {
+		return err
+	}
+
+	if rec.Type == ldif.ChangeModRDN {
+		rdn, err := ldap.ParseDN(rec.NewRDN)
+		if err != nil || len(rdn.RDNs) != 1 {
+			return fmt.Errorf("%w: bad newrdn %q", ErrInvalidInput, rec.NewRDN)
+		}
+		if rec.NewSuperior != "" {
+			if err = validateDN(rec.NewSuperior); err != nil {
+				return err
+			}
+		}
+		if rollback && isExtendedDNForm(rec.DN) {
+			return fmt.Errorf("%w: modrdn of extended dn form can't be rolled back", ErrInvalidInput)
+		}
+	}
+
+	if rollback && rec.Type == ldif.ChangeDelete {
+		for _, c := range rec.Controls {
+			if c.OID == ldap.ControlTypeSubtreeDelete {
+				return fmt.Errorf("%w: subtree delete can't be rolled back", ErrInvalidInput)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkRecord - dry-run check of record target (add - entry doesn't exist, others - entry exists)
+// after earlier records of the same file, passed record changes are kept in state
+func (conn *LdapConn) checkRecord(ctx context.Context, state *dryRunState, rec *ldif.Record) error {
+	exists, serverDN, err := conn.entryExists(ctx, state, rec.DN)
+	switch {
+	case err != nil:
+		return err
+	case rec.Type == ldif.ChangeAdd && exists:
+		return fmt.Errorf("entry already exists: %s", rec.DN)
+	case rec.Type != ldif.ChangeAdd && !exists:
+		return fmt.Errorf("%w: %s", ErrNotFound, rec.DN)
+	}
+
+	if rec.Type == ldif.ChangeModRDN && rec.NewSuperior != "" {
+		exists, _, err = conn.entryExists(ctx, state, rec.NewSuperior)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrNotFound, rec.NewSuperior)
+		}
+	}
+
+	state.apply(rec, serverDN)
+	return nil
+}
+
+// entryExists - check if entry exists after earlier dry-run records (server is read if they don't tell),
+// dn of entry on server is returned too (it differs for entries under renamed ones)
+func (conn *LdapConn) entryExists(ctx context.Context, state *dryRunState, dn string) (bool, string, error) {
+	serverDN, exists, known := state.locate(dn)
+	if known {
+		return exists, serverDN, nil
+	}
+
+	_, err := conn.readEntry(ctx, serverDN, []string{"1.1"})
+	if errors.Is(err, ErrNotFound) {
+		return false, serverDN, nil
+	}
+	if err != nil {
+		return false, serverDN, err
+	}
+
+	return true, serverDN, nil
+}
+
+// dryRunState - changes of checked dry-run records
+type dryRunState struct {
+	exists  map[string]bool   // dn key -> entry exists after checked records (added, renamed to - true; deleted, renamed from - false)
+	renamed map[string]string // dn key of renamed entry -> its dn on server
+}
+
+// newDryRunState - create empty dry-run state
+func newDryRunState() *dryRunState {
+	return &dryRunState{exists: make(map[string]bool), renamed: make(map[string]string)}
+}
+
+// locate - get dn of entry on server and its existence if it is known by checked records
+// (nearest changed entry among dn and its ancestors decides)
+func (s *dryRunState) locate(dn string) (serverDN string, exists, known bool) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || isExtendedDNForm(dn) {
+		return dn, false, false
+	}
+
+	for i := range parsed.RDNs {
+		key := dnKey((&ldap.DN{RDNs: parsed.RDNs[i:]}).String())
+		changed, ok := s.exists[key]
+		switch {
+		case !ok:
+			continue
+		case i == 0:
+			return s.renamed[key], changed, true
+		case !changed:
+			// ancestor is deleted or renamed, so are its children
+			return dn, false, true
+		}
+
+		old, ok := s.renamed[key]
+		if !ok {
+			// ancestor is added by checked records, it has no children on server
+			return dn, false, true
+		}
+		// ancestor is renamed, its children are on server under old dn
+		return (&ldap.DN{RDNs: parsed.RDNs[:i]}).String() + "," + old, false, false
+	}
+
+	return dn, false, false
+}
+
+// apply - keep changes of checked record (serverDN - dn of record entry on server)
+func (s *dryRunState) apply(rec *ldif.Record, serverDN string) {
+	key := dnKey(rec.DN)
+	switch rec.Type {
+	case ldif.ChangeAdd:
+		s.exists[key] = true
+		delete(s.renamed, key)
+	case ldif.ChangeDelete:
+		s.exists[key] = false
+		delete(s.renamed, key)
+	case ldif.ChangeModRDN:
+		newDN, err := modRDNTarget(rec)
+		if err != nil {
+			return
+		}
+		s.exists[key] = false
+		delete(s.renamed, key)
+
+		newKey := dnKey(newDN)
+		s.exists[newKey] = true
+		if serverDN != "" {
+			s.renamed[newKey] = serverDN
+		} else {
+			delete(s.renamed, newKey)
+		}
+	}
+}
+
+////////////////////////////////////////////// Records applying
+
+// applyRecord - apply record, inverse record is made before applying if withUndo
+func (conn *LdapConn) applyRecord(ctx context.Context, rec *ldif.Record, withUndo bool) (*ldif.Record, error) {
+	var inverse *ldif.Record
+	if withUndo {
+		var err error
+		inverse, err = conn.inverseRecord(ctx, rec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	controls := make([]ldap.Control, 0, len(rec.Controls))
+	for _, c := range rec.Controls {
+		controls = append(controls, ldap.NewControlString(c.OID, c.Criticality, c.Value))
+	}
+
+	var err error
+	switch rec.Type {
+	case ldif.ChangeAdd:
+		addRequest := ldap.NewAddRequest(rec.DN, controls)
+		for _, attr := range rec.Attributes {
+			addRequest.Attribute(attr.Name, attr.Values)
+		}
+		err = conn.Connection.Add(addRequest)
+	case ldif.ChangeModify:
+		modifyRequest := ldap.NewModifyRequest(rec.DN, controls)
+		for _, m := range rec.Modifications {
+			switch m.Op {
+			case ldif.ModAdd:
+				modifyRequest.Add(m.Name, m.Values)
+			case ldif.ModDelete:
+				modifyRequest.Delete(m.Name, m.Values)
+			case ldif.ModReplace:
+				modifyRequest.Replace(m.Name, m.Values)
+			}
+		}
+		err = conn.Connection.Modify(modifyRequest)
+	case ldif.ChangeDelete:
+		err = conn.Connection.Del(ldap.NewDelRequest(rec.DN, controls))
+	case ldif.ChangeModRDN:
+		modifyDNRequest := ldap.NewModifyDNRequest(rec.DN, rec.NewRDN, rec.DeleteOldRDN, rec.NewSuperior)
+		modifyDNRequest.Controls = controls
+		err = conn.Connection.ModifyDN(modifyDNRequest)
+	default:
+		err = fmt.Errorf("unknown changetype %q", rec.Type)
+	}
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, rec.DN)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return inverse, nil
+}
+
+// inverseRecord - make record undoing passed one (current entry state is read for delete and modify)
+func (conn *LdapConn) inverseRecord(ctx context.Context, rec *ldif.Record) (*ldif.Record, error) {
+	switch rec.Type {
+	case ldif.ChangeAdd:
+		return &ldif.Record{Line: rec.Line, DN: rec.DN, Type: ldif.ChangeDelete}, nil
+
+	case ldif.ChangeDelete:
+		entry, err := conn.readEntry(ctx, rec.DN, []string{"*"})
+		if err != nil {
+			return nil, err
+		}
+		return inverseDelete(rec, entry), nil
+
+	case ldif.ChangeModify:
+		names := make([]string, 0, len(rec.Modifications))
+		for _, m := range rec.Modifications {
+			names = append(names, m.Name)
+		}
+		entry, err := conn.readEntry(ctx, rec.DN, names)
+		if err != nil {
+			return nil, err
+		}
+		return inverseModify(rec, entry), nil
+
+	case ldif.ChangeModRDN:
+		return inverseModRDN(rec)
+	}
+
+	return nil, fmt.Errorf("unknown changetype %q", rec.Type)
+}
+
+// inverseDelete - add record restoring deleted entry (attrs set by server are skipped)
+func inverseDelete(rec *ldif.Record, entry *ldap.Entry) *ldif.Record {
+	skip := make(map[string]bool, len(rollbackSkipAttrs))
+	for _, name := range rollbackSkipAttrs {
+		skip[strings.ToLower(name)] = true
+	}
+
+	res := &ldif.Record{Line: rec.Line, DN: rec.DN, Type: ldif.ChangeAdd}
+	for _, attr := range entry.Attributes {
+		if skip[strings.ToLower(attr.Name)] {
+			continue
+		}
+
+		values := make([]string, 0, len(attr.ByteValues))
+		for _, v := range attr.ByteValues {
+			values = append(values, string(v))
+		}
+		res.Attributes = append(res.Attributes, ldif.Attribute{Name: attr.Name, Values: values})
+	}
+
+	return res
+}
+
+// inverseModify - modify record replacing changed attrs with their current values
+func inverseModify(rec *ldif.Record, entry *ldap.Entry) *ldif.Record {
+	res := &ldif.Record{Line: rec.Line, DN: rec.DN, Type: ldif.ChangeModify}
+
+	seen := make(map[string]bool)
+	for _, m := range rec.Modifications {
+		key := strings.ToLower(m.Name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		values := make([]string, 0)
+		for _, attr := range entry.Attributes {
+			if strings.EqualFold(attr.Name, m.Name) {
+				for _, v := range attr.ByteValues {
+					values = append(values, string(v))
+				}
+			}
+		}
+		res.Modifications = append(res.Modifications, ldif.Modification{
+			Op:        ldif.ModReplace,
+			Attribute: ldif.Attribute{Name: m.Name, Values: values},
+		})
+	}
+
+	return res
+}
+
+// inverseModRDN - modrdn record moving entry back
+func inverseModRDN(rec *ldif.Record) (*ldif.Record, error) {
+	newDN, err := modRDNTarget(rec)
+	if err != nil {
+		return nil, err
+	}
+	parsed, _ := ldap.ParseDN(rec.DN)
+	parent := ldap.DN{RDNs: parsed.RDNs[1:]}
+
+	res := &ldif.Record{
+		Line:         rec.Line,
+		DN:           newDN,
+		Type:         ldif.ChangeModRDN,
+		NewRDN:       parsed.RDNs[0].String(),
+		DeleteOldRDN: true,
+	}
+	if rec.NewSuperior != "" {
+		res.NewSuperior = parent.String()
+	}
+
+	return res, nil
+}
+
+// modRDNTarget - dn of entry after modrdn record
+func modRDNTarget(rec *ldif.Record) (string, error) {
+	parsed, err := ldap.ParseDN(rec.DN)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return "", fmt.Errorf("%w: bad dn %q", ErrInvalidInput, rec.DN)
+	}
+
+	newParent := (&ldap.DN{RDNs: parsed.RDNs[1:]}).String()
+	if rec.NewSuperior != "" {
+		newParent = rec.NewSuperior
+	}
+
+	newDN := rec.NewRDN
+	if newParent != "" {
+		newDN += "," + newParent
+	}
+
+	return newDN, nil
+}
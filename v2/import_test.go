@@ -0,0 +1,197 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/ldif"
+)
+
+func TestImportLDIFValidation(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	ctx := context.Background()
+
+	_, err := conn.ImportLDIF(ctx, strings.NewReader(""), ImportOptions{ContinueOnError: true, Rollback: true})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.ImportLDIF(ctx, strings.NewReader("dn: cn=a,dc=test\ncn:: ???\n"))
+	require.ErrorIs(t, err, ErrInvalidInput)
+	var parseErr *ldif.ParseError
+	require.ErrorAs(t, err, &parseErr)
+
+	res, err := conn.ImportLDIF(ctx, strings.NewReader(`dn: cn=a,dc=test
+changetype: delete
+
+dn: bad dn
+changetype: delete
+
+dn: cn=b,dc=test
+changetype: modrdn
+newrdn: cn=c,dc=test
+deleteoldrdn: 1
+`))
+	require.ErrorIs(t, err, ErrInvalidInput)
+	require.Contains(t, err.Error(), "line 4")
+	require.Equal(t, 2, res.Failed)
+	require.Equal(t, 0, res.Applied)
+	require.Equal(t, []ImportStatus{ImportSkipped, ImportFailed, ImportFailed},
+		[]ImportStatus{res.Records[0].Status, res.Records[1].Status, res.Records[2].Status})
+
+	_, err = conn.ImportLDIF(ctx, strings.NewReader(`dn: ou=old,dc=test
+control: 1.2.840.113556.1.4.805 true
+changetype: delete
+`), ImportOptions{Rollback: true})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestImportLDIFRollback(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		switch describeRequest(req.Op) {
+		case "search cn=a,dc=test (objectClass=*) [title]":
+			return []testMessage{entryMessage("cn=a,dc=test", map[string][]string{
+				"title": {"Old"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "search cn=c,dc=test (objectClass=*) [*]":
+			return []testMessage{searchDone(ldap.LDAPResultNoSuchObject)}
+		}
+		return nil
+	})
+
+	res, err := conn.ImportLDIF(context.Background(), strings.NewReader(`dn: cn=a,dc=test
+changetype: modify
+replace: title
+title: Boss
+-
+
+dn: cn=b,dc=test
+changetype: add
+cn: b
+
+dn: cn=c,dc=test
+changetype: delete
+`), ImportOptions{Rollback: true})
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Equal(t, 0, res.Applied)
+	require.Equal(t, 2, res.RolledBack)
+	require.Equal(t, 1, res.Failed)
+	require.Equal(t, []ImportStatus{ImportRolledBack, ImportRolledBack, ImportFailed},
+		[]ImportStatus{res.Records[0].Status, res.Records[1].Status, res.Records[2].Status})
+
+	// applied records are undone in reverse order
+	require.Equal(t, []string{
+		"search cn=a,dc=test (objectClass=*) [title]",
+		"modify cn=a,dc=test: replace title [Boss]",
+		"add cn=b,dc=test: cn [b]",
+		"search cn=c,dc=test (objectClass=*) [*]",
+		"delete cn=b,dc=test",
+		"modify cn=a,dc=test: replace title [Old]",
+	}, srv.Requests())
+}
+
+func TestInverseRecords(t *testing.T) {
+	modify := &ldif.Record{DN: "cn=a,dc=test", Type: ldif.ChangeModify, Modifications: []ldif.Modification{
+		{Op: ldif.ModReplace, Attribute: ldif.Attribute{Name: "title", Values: []string{"Boss"}}},
+		{Op: ldif.ModAdd, Attribute: ldif.Attribute{Name: "mail", Values: []string{"a@test"}}},
+		{Op: ldif.ModDelete, Attribute: ldif.Attribute{Name: "Title", Values: []string{"Old"}}},
+	}}
+	entry := ldap.NewEntry("cn=a,dc=test", map[string][]string{"title": {"Old", "Older"}})
+	require.Equal(t, []ldif.Modification{
+		{Op: ldif.ModReplace, Attribute: ldif.Attribute{Name: "title", Values: []string{"Old", "Older"}}},
+		{Op: ldif.ModReplace, Attribute: ldif.Attribute{Name: "mail", Values: []string{}}},
+	}, inverseModify(modify, entry).Modifications)
+
+	deleted := ldap.NewEntry("cn=a,dc=test", map[string][]string{
+		"objectClass": {"top", "person"},
+		"cn":          {"a"},
+		"objectGUID":  {"\x00\x11"},
+	})
+	restore := inverseDelete(&ldif.Record{DN: "cn=a,dc=test", Type: ldif.ChangeDelete}, deleted)
+	require.Equal(t, ldif.ChangeAdd, restore.Type)
+	require.ElementsMatch(t, []ldif.Attribute{
+		{Name: "objectClass", Values: []string{"top", "person"}},
+		{Name: "cn", Values: []string{"a"}},
+	}, restore.Attributes)
+
+	moved, err := inverseModRDN(&ldif.Record{
+		DN: "CN=Ivan,OU=Sales,DC=test", Type: ldif.ChangeModRDN,
+		NewRDN: "CN=Petr", DeleteOldRDN: true, NewSuperior: "OU=IT,DC=test",
+	})
+	require.NoError(t, err)
+	require.Equal(t, &ldif.Record{
+		DN: "CN=Petr,OU=IT,DC=test", Type: ldif.ChangeModRDN,
+		NewRDN: "cn=Ivan", DeleteOldRDN: true, NewSuperior: "ou=Sales,dc=test",
+	}, moved)
+
+	renamed, err := inverseModRDN(&ldif.Record{
+		DN: "CN=Ivan,OU=Sales,DC=test", Type: ldif.ChangeModRDN, NewRDN: "CN=Petr",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "CN=Petr,ou=Sales,dc=test", renamed.DN)
+	require.Empty(t, renamed.NewSuperior)
+}
+
+func TestDryRunState(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		if describeRequest(req.Op) == "search cn=Ivan,ou=Sales,dc=test (objectClass=*) [1.1]" {
+			return []testMessage{entryMessage("cn=Ivan,ou=Sales,dc=test", nil), searchDone(ldap.LDAPResultSuccess)}
+		}
+		return []testMessage{searchDone(ldap.LDAPResultNoSuchObject)}
+	})
+	ctx := context.Background()
+	state := newDryRunState()
+	check := func(rec *ldif.Record) error {
+		return conn.checkRecord(ctx, state, rec)
+	}
+
+	// earlier checked records of server entries
+	state.apply(&ldif.Record{DN: "ou=Sales,dc=test", Type: ldif.ChangeModRDN, NewRDN: "ou=East"}, "ou=Sales,dc=test")
+	state.apply(&ldif.Record{DN: "cn=Old,dc=test", Type: ldif.ChangeDelete}, "cn=Old,dc=test")
+	state.apply(&ldif.Record{DN: "ou=IT,dc=test", Type: ldif.ChangeDelete}, "ou=IT,dc=test")
+
+	// add then modify, modrdn and delete of added entry
+	require.NoError(t, check(&ldif.Record{DN: "ou=IT,dc=test", Type: ldif.ChangeAdd}))
+	require.NoError(t, check(&ldif.Record{DN: "OU=IT,DC=test", Type: ldif.ChangeModify}))
+	require.Error(t, check(&ldif.Record{DN: "ou=IT,dc=test", Type: ldif.ChangeAdd}))
+
+	// delete then add
+	require.ErrorIs(t, check(&ldif.Record{DN: "cn=Old,dc=test", Type: ldif.ChangeModify}), ErrNotFound)
+	require.NoError(t, check(&ldif.Record{DN: "cn=Old,dc=test", Type: ldif.ChangeAdd}))
+
+	// modrdn onto just added superior, renamed entry is found by new dn only
+	require.NoError(t, check(&ldif.Record{DN: "cn=Old,dc=test", Type: ldif.ChangeModRDN,
+		NewRDN: "cn=New", DeleteOldRDN: true, NewSuperior: "ou=IT,dc=test"}))
+	require.NoError(t, check(&ldif.Record{DN: "cn=New,ou=IT,dc=test", Type: ldif.ChangeDelete}))
+	require.ErrorIs(t, check(&ldif.Record{DN: "cn=Old,dc=test", Type: ldif.ChangeDelete}), ErrNotFound)
+
+	// children of added entry aren't on server, children of deleted or renamed entries are gone
+	require.ErrorIs(t, check(&ldif.Record{DN: "cn=x,ou=IT,dc=test", Type: ldif.ChangeModify}), ErrNotFound)
+	require.ErrorIs(t, check(&ldif.Record{DN: "cn=x,ou=Sales,dc=test", Type: ldif.ChangeModify}), ErrNotFound)
+
+	// children of renamed entry are read on server by old dn
+	serverDN, _, known := state.locate("cn=Ivan,ou=East,dc=test")
+	require.False(t, known)
+	require.Equal(t, "cn=Ivan,ou=Sales,dc=test", serverDN)
+	serverDN, exists, known := state.locate("ou=East,dc=test")
+	require.True(t, known)
+	require.True(t, exists)
+	require.Equal(t, "ou=Sales,dc=test", serverDN)
+
+	// untouched entries are read on server
+	_, _, known = state.locate("cn=Ivan,dc=other")
+	require.False(t, known)
+	require.ErrorIs(t, check(&ldif.Record{DN: "cn=Ivan,dc=other", Type: ldif.ChangeDelete}), ErrNotFound)
+	require.NoError(t, check(&ldif.Record{DN: "cn=Ivan,ou=East,dc=test", Type: ldif.ChangeModify}))
+
+	// all other checks are decided by earlier records
+	require.Equal(t, []string{
+		"search cn=Ivan,dc=other (objectClass=*) [1.1]",
+		"search cn=Ivan,ou=Sales,dc=test (objectClass=*) [1.1]",
+	}, srv.Requests())
+}
@@ -0,0 +1,436 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// csvTemplateRe - CSVMapping.DNTemplate placeholder regexp
+var csvTemplateRe = regexp.MustCompile(csvTemplateField)
+
+////////////////////////////////////////////// CSV users import
+
+// ImportUsersCSV Create users from CSV rows (first row is header) mapped to attrs by mapping.
+// All rows are parsed and validated before the first request, the server is only read
+// unless CSVImportOptions.Apply. Failed rows don't stop import, they are listed in the report
+func (conn *LdapConn) ImportUsersCSV(ctx context.Context, r io.Reader, mapping CSVMapping,
+	opts ...CSVImportOptions) (res CSVImportResult, err error) {
+
+	var importOpts CSVImportOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		importOpts = opts[0]
+	}
+
+	templateFields, err := mapping.validate()
+	if err != nil {
+		return res, err
+	}
+
+	reader := csv.NewReader(r)
+	if importOpts.Comma != 0 {
+		reader.Comma = importOpts.Comma
+	}
+	reader.TrimLeadingSpace = true
+	// rows with wrong fields count are reported as failed, not stopping import
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return res, fmt.Errorf("%w: empty csv", ErrInvalidInput)
+	}
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+	}
+	columns, err := mapping.columnIndex(header)
+	if err != nil {
+		return res, err
+	}
+
+	users := make([]csvUser, 0)
+	seen := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return res, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(header) {
+			err = fmt.Errorf("%w: %d fields in row, %d in header", ErrInvalidInput, len(record), len(header))
+			res.add(CSVRowResult{Row: line, Status: CSVRowFailed}, err)
+			continue
+		}
+
+		u, err := conn.newCSVUser(mapping, templateFields, columns, record, importOpts)
+		u.row = line
+		if err == nil {
+			err = checkCSVDuplicate(seen, u)
+		}
+		if err != nil {
+			res.add(CSVRowResult{Row: line, DN: u.user.DN, Status: CSVRowFailed}, err)
+			continue
+		}
+		u.index = len(res.Rows)
+		users = append(users, u)
+		res.Rows = append(res.Rows, CSVRowResult{Row: line, DN: u.user.DN, Status: CSVRowSkipped})
+	}
+
+	parents := make(map[string]bool)
+	for i, u := range users {
+		if err = ctx.Err(); err != nil {
+			// not processed rows stay skipped
+			res.Skipped += len(users) - i
+			return res, err
+		}
+
+		status, err := conn.importCSVUser(ctx, u, importOpts, parents, &res)
+		res.set(u.index, status, err)
+	}
+
+	return res, nil
+}
+
+// importCSVUser - create (update) one user, creates missing parent ous if needed
+func (conn *LdapConn) importCSVUser(ctx context.Context, u csvUser, opts CSVImportOptions,
+	parents map[string]bool, res *CSVImportResult) (CSVRowStatus, error) {
+
+	// mapped attrs are read for update to skip unchanged ones
+	attrs := []string{"1.1"}
+	var modifyRequest *ldap.ModifyRequest
+	if opts.Update {
+		var err error
+		if modifyRequest, err = conn.csvUserModifyRequest(u); err != nil {
+			return CSVRowFailed, err
+		}
+		if len(modifyRequest.Changes) > 0 {
+			attrs = make([]string, 0, len(modifyRequest.Changes))
+			for _, change := range modifyRequest.Changes {
+				attrs = append(attrs, change.Modification.Type)
+			}
+		}
+	}
+
+	entry, err := conn.readEntry(ctx, u.user.DN, attrs)
+	if err == nil {
+		if !opts.Update {
+			return CSVRowSkipped, nil
+		}
+		return conn.updateCSVUser(ctx, entry, modifyRequest, opts.Apply)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return CSVRowFailed, err
+	}
+
+	if err = conn.ensureParentOUs(ctx, u.user.DN, opts, parents, res); err != nil {
+		return CSVRowFailed, err
+	}
+	if !opts.Apply {
+		return CSVRowCreated, nil
+	}
+
+	err = conn.Connection.Add(u.addRequest)
+	if err != nil {
+		return CSVRowFailed, fmt.Errorf("bad add: %s", err.Error())
+	}
+
+	return CSVRowCreated, nil
+}
+
+// csvUserModifyRequest - make request replacing all not empty mapped attrs of user
+func (conn *LdapConn) csvUserModifyRequest(u csvUser) (*ldap.ModifyRequest, error) {
+	modifyRequest := ldap.NewModifyRequest(u.user.DN, nil)
+	if len(u.fields) > 0 {
+		var err error
+		modifyRequest, err = conn.newUserModifyRequest(u.user.DN, u.user.Info, u.fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, attr := range u.attrs {
+		modifyRequest.Replace(attr.Type, attr.Vals)
+	}
+
+	return modifyRequest, nil
+}
+
+// updateCSVUser - apply modify request changes of attrs which differ from current entry ones
+// (skipped if all attrs are the same)
+func (conn *LdapConn) updateCSVUser(ctx context.Context, entry *ldap.Entry, modifyRequest *ldap.ModifyRequest,
+	apply bool) (CSVRowStatus, error) {
+
+	changes := make([]ldap.Change, 0, len(modifyRequest.Changes))
+	for _, change := range modifyRequest.Changes {
+		current := entry.GetEqualFoldAttributeValues(change.Modification.Type)
+		if !sameValues(current, change.Modification.Vals) {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 {
+		return CSVRowSkipped, nil
+	}
+	if !apply {
+		return CSVRowUpdated, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return CSVRowFailed, err
+	}
+
+	modifyRequest.Changes = changes
+	err := conn.Connection.Modify(modifyRequest)
+	if err != nil {
+		return CSVRowFailed, fmt.Errorf("bad modify: %s", err.Error())
+	}
+
+	return CSVRowUpdated, nil
+}
+
+// sameValues - check attr values are equal regardless of order
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+
+	return slices.Equal(a, b)
+}
+
+// ensureParentOUs - check parents of dn (nearest first), missing ones are created top-down
+// if CSVImportOptions.CreateOUs (parents caches known parents by dnKey)
+func (conn *LdapConn) ensureParentOUs(ctx context.Context, dn string, opts CSVImportOptions,
+	parents map[string]bool, res *CSVImportResult) error {
+
+	parsed, err := parseWriteDN(dn)
+	if err != nil {
+		return err
+	}
+
+	missing := make([]ldap.DN, 0)
+	for i := 1; i < len(parsed.RDNs); i++ {
+		parent := ldap.DN{RDNs: parsed.RDNs[i:]}
+		key := dnKey(parent.String())
+		if parents[key] {
+			break
+		}
+
+		_, err = conn.readEntry(ctx, parent.String(), []string{"1.1"})
+		if err == nil {
+			parents[key] = true
+			break
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		missing = append(missing, parent)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !opts.CreateOUs {
+		return fmt.Errorf("%w: parent %s", ErrNotFound, missing[0].String())
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		parent := missing[i]
+		rdn := parent.RDNs[0].Attributes[0]
+		if len(parent.RDNs[0].Attributes) != 1 || !strings.EqualFold(rdn.Type, "ou") || len(parent.RDNs) < 2 {
+			return fmt.Errorf("%w: missing parent %q is not ou", ErrInvalidInput, parent.String())
+		}
+
+		if opts.Apply {
+			grandParent := ldap.DN{RDNs: parent.RDNs[1:]}
+			if _, err = conn.CreateOU(ctx, grandParent.String(), rdn.Value, ""); err != nil {
+				return err
+			}
+		}
+		parents[dnKey(parent.String())] = true
+		res.CreatedOUs = append(res.CreatedOUs, parent.String())
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////// CSV rows
+
+// csvUser - CSV row mapped to new user
+type csvUser struct {
+	row        int // CSV line
+	index      int // index in report rows
+	user       NewUser
+	fields     []string         // not empty profile fields (for update)
+	attrs      []ldap.Attribute // not empty raw attrs
+	addRequest *ldap.AddRequest
+}
+
+// newCSVUser - map CSV record to user, dn is made by template (values are validated)
+func (conn *LdapConn) newCSVUser(mapping CSVMapping, templateFields []string, columns map[string]int,
+	record []string, opts CSVImportOptions) (csvUser, error) {
+
+	values := make(map[string]string, len(columns))
+	for field, i := range columns {
+		values[field] = strings.TrimSpace(record[i])
+	}
+
+	var u csvUser
+	for _, field := range slices.Concat([]string{"login"}, templateFields, mapping.Required) {
+		if values[field] == "" {
+			return u, fmt.Errorf("%w: empty %q", ErrInvalidInput, field)
+		}
+	}
+
+	u.user = NewUser{
+		DN: csvTemplateRe.ReplaceAllStringFunc(mapping.DNTemplate, func(s string) string {
+			return ldap.EscapeDN(values[csvTemplateRe.FindStringSubmatch(s)[1]])
+		}),
+		Login:     values["login"],
+		UPNSuffix: opts.UPNSuffix,
+		Surname:   values["surname"],
+		GivenName: values["givenName"],
+	}
+
+	// sorted for stable attrs order
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	for _, field := range fields {
+		value := values[field]
+		switch field {
+		case "login", "surname", "givenName":
+			continue
+		}
+
+		if f, ok := userFieldByName(field); ok {
+			*f.value(&u.user.Info) = value
+			if value != "" && !f.readOnly {
+				u.fields = append(u.fields, field)
+			}
+			continue
+		}
+		if value != "" {
+			u.attrs = append(u.attrs, ldap.Attribute{Type: field, Vals: []string{value}})
+		}
+	}
+
+	addRequest, err := conn.newUserAddRequest(u.user)
+	if err != nil {
+		return u, err
+	}
+	for _, attr := range u.attrs {
+		addRequest.Attribute(attr.Type, attr.Vals)
+	}
+	u.addRequest = addRequest
+
+	return u, nil
+}
+
+// checkCSVDuplicate - check dn and login are not used by previous rows (seen keeps their rows)
+func checkCSVDuplicate(seen map[string]int, u csvUser) error {
+	for _, key := range []string{"dn:" + dnKey(u.user.DN), "login:" + strings.ToLower(u.user.Login)} {
+		if row, ok := seen[key]; ok {
+			return fmt.Errorf("%w: %s is used in row %d", ErrInvalidInput, key, row)
+		}
+	}
+	seen["dn:"+dnKey(u.user.DN)] = u.row
+	seen["login:"+strings.ToLower(u.user.Login)] = u.row
+
+	return nil
+}
+
+////////////////////////////////////////////// CSV mapping
+
+// validate - check mapping, returns dn template fields
+func (m CSVMapping) validate() ([]string, error) {
+	mapped := make(map[string]bool, len(m.Columns))
+	for column, field := range m.Columns {
+		if strings.TrimSpace(field) == "" {
+			return nil, fmt.Errorf("%w: column %q has empty field", ErrInvalidInput, column)
+		}
+		if mapped[field] {
+			return nil, fmt.Errorf("%w: field %q is mapped twice", ErrInvalidInput, field)
+		}
+		mapped[field] = true
+	}
+
+	templateFields := make([]string, 0)
+	for _, match := range csvTemplateRe.FindAllStringSubmatch(m.DNTemplate, -1) {
+		templateFields = append(templateFields, match[1])
+	}
+	if len(templateFields) == 0 {
+		return nil, fmt.Errorf("%w: dn template %q has no fields", ErrInvalidInput, m.DNTemplate)
+	}
+
+	for _, field := range slices.Concat([]string{"login"}, templateFields, m.Required) {
+		if !mapped[field] {
+			return nil, fmt.Errorf("%w: field %q is not mapped", ErrInvalidInput, field)
+		}
+	}
+
+	// template must make dn with user rdn and parent
+	parsed, err := parseWriteDN(csvTemplateRe.ReplaceAllString(m.DNTemplate, "x"))
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.RDNs) < 2 {
+		return nil, fmt.Errorf("%w: dn template %q has no parent", ErrInvalidInput, m.DNTemplate)
+	}
+
+	return templateFields, nil
+}
+
+// columnIndex - get record index of mapped fields by CSV header
+func (m CSVMapping) columnIndex(header []string) (map[string]int, error) {
+	res := make(map[string]int, len(m.Columns))
+	for column, field := range m.Columns {
+		i := slices.IndexFunc(header, func(h string) bool { return strings.EqualFold(strings.TrimSpace(h), column) })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: no column %q in csv header", ErrInvalidInput, column)
+		}
+		res[field] = i
+	}
+
+	return res, nil
+}
+
+////////////////////////////////////////////// CSV import report
+
+// add - add row result to report
+func (res *CSVImportResult) add(row CSVRowResult, err error) {
+	res.Rows = append(res.Rows, row)
+	res.set(len(res.Rows)-1, row.Status, err)
+}
+
+// set - set row status (and error) and update counters
+func (res *CSVImportResult) set(i int, status CSVRowStatus, err error) {
+	res.Rows[i].Status = status
+	if err != nil {
+		res.Rows[i].Status = CSVRowFailed
+		res.Rows[i].Error = err.Error()
+	}
+
+	switch res.Rows[i].Status {
+	case CSVRowCreated:
+		res.Created++
+	case CSVRowUpdated:
+		res.Updated++
+	case CSVRowSkipped:
+		res.Skipped++
+	case CSVRowFailed:
+		res.Failed++
+	}
+}
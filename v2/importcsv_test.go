@@ -0,0 +1,204 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+var testCSVMapping = CSVMapping{
+	Columns: map[string]string{
+		"Login":      "login",
+		"Name":       "cn",
+		"Last name":  "surname",
+		"Department": "department",
+		"E-mail":     "mail",
+		"Type":       "employeeType",
+	},
+	DNTemplate: "CN={{cn}},OU={{ department }},DC=test,DC=ru",
+	Required:   []string{"mail"},
+}
+
+func TestCSVMappingValidate(t *testing.T) {
+	fields, err := testCSVMapping.validate()
+	require.NoError(t, err)
+	require.Equal(t, []string{"cn", "department"}, fields)
+
+	tests := []struct {
+		name    string
+		mapping CSVMapping
+	}{
+		{"no template fields", CSVMapping{Columns: map[string]string{"a": "login"}, DNTemplate: "CN=a,DC=ru"}},
+		{"no login", CSVMapping{Columns: map[string]string{"a": "cn"}, DNTemplate: "CN={{cn}},DC=ru"}},
+		{"not mapped template field", CSVMapping{Columns: map[string]string{"a": "login"}, DNTemplate: "CN={{cn}},DC=ru"}},
+		{"not mapped required field", CSVMapping{Columns: map[string]string{"a": "login"}, DNTemplate: "CN={{login}},DC=ru", Required: []string{"mail"}}},
+		{"mapped twice", CSVMapping{Columns: map[string]string{"a": "login", "b": "login"}, DNTemplate: "CN={{login}},DC=ru"}},
+		{"no parent", CSVMapping{Columns: map[string]string{"a": "login"}, DNTemplate: "CN={{login}}"}},
+		{"bad dn", CSVMapping{Columns: map[string]string{"a": "login"}, DNTemplate: "{{login}}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.mapping.validate()
+			require.ErrorIs(t, err, ErrInvalidInput)
+		})
+	}
+}
+
+func TestNewCSVUser(t *testing.T) {
+	conn := &LdapConn{}
+	fields, err := testCSVMapping.validate()
+	require.NoError(t, err)
+	columns, err := testCSVMapping.columnIndex([]string{"login", " Name", "Last name", "Department", "E-mail", "Type"})
+	require.NoError(t, err)
+
+	u, err := conn.newCSVUser(testCSVMapping, fields, columns,
+		[]string{"ivanov", "Ivanov, Ivan", "Ivanov", "IT", "ivanov@test.ru", ""}, CSVImportOptions{UPNSuffix: "test.ru"})
+	require.NoError(t, err)
+	require.Equal(t, `CN=Ivanov\, Ivan,OU=IT,DC=test,DC=ru`, u.user.DN)
+	require.Equal(t, []string{"department", "mail"}, u.fields)
+	require.Empty(t, u.attrs)
+	require.Equal(t, []string{"ivanov@test.ru"}, attrValues(u.addRequest.Attributes, "userPrincipalName"))
+
+	u, err = conn.newCSVUser(testCSVMapping, fields, columns,
+		[]string{"petrov", "Petrov", "", "IT", "petrov@test.ru", "contractor"}, CSVImportOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []ldap.Attribute{{Type: "employeeType", Vals: []string{"contractor"}}}, u.attrs)
+	require.Equal(t, []string{"contractor"}, attrValues(u.addRequest.Attributes, "employeeType"))
+	require.Equal(t, []string{"Petrov"}, attrValues(u.addRequest.Attributes, "sn"))
+
+	for _, record := range [][]string{
+		{"", "Petrov", "", "IT", "petrov@test.ru", ""},         // no login
+		{"petrov", "Petrov", "", "", "petrov@test.ru", ""},     // no template field
+		{"petrov", "Petrov", "", "IT", "", ""},                 // no required field
+		{"petrov:1", "Petrov", "", "IT", "petrov@test.ru", ""}, // bad login
+	} {
+		_, err = conn.newCSVUser(testCSVMapping, fields, columns, record, CSVImportOptions{})
+		require.ErrorIs(t, err, ErrInvalidInput)
+	}
+
+	_, err = testCSVMapping.columnIndex([]string{"login", "Name"})
+	require.ErrorIs(t, err, ErrInvalidInput)
+}
+
+func TestImportUsersCSVValidation(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	ctx := context.Background()
+
+	_, err := conn.ImportUsersCSV(ctx, strings.NewReader(""), testCSVMapping)
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.ImportUsersCSV(ctx, strings.NewReader("Login,Name\n"), testCSVMapping)
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.ImportUsersCSV(ctx, strings.NewReader("Login;Name;Last name;Department;E-mail;Type\na;\"b\n"),
+		testCSVMapping, CSVImportOptions{Comma: ';'})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	res, err := conn.ImportUsersCSV(ctx, strings.NewReader(`Login;Name;Last name;Department;E-mail;Type
+;Ivanov;;IT;ivanov@test.ru;
+petrov;Petrov;;;petrov@test.ru;
+`), testCSVMapping, CSVImportOptions{Comma: ';'})
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Failed)
+	require.Equal(t, 0, res.Created)
+	require.Equal(t, []int{2, 3}, []int{res.Rows[0].Row, res.Rows[1].Row})
+	require.Equal(t, CSVRowFailed, res.Rows[1].Status)
+	require.Contains(t, res.Rows[1].Error, "department")
+
+	// short and long rows are failed ones, import goes on
+	res, err = conn.ImportUsersCSV(ctx, strings.NewReader(`Login;Name;Last name;Department;E-mail;Type
+ivanov;Ivanov
+petrov;Petrov;;;petrov@test.ru;;extra
+`), testCSVMapping, CSVImportOptions{Comma: ';'})
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Failed)
+	require.Contains(t, res.Rows[0].Error, "2 fields in row, 6 in header")
+	require.Contains(t, res.Rows[1].Error, "7 fields in row, 6 in header")
+
+	// not processed rows are counted as skipped on cancel
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	res, err = conn.ImportUsersCSV(canceled, strings.NewReader(`Login;Name;Last name;Department;E-mail;Type
+ivanov;Ivanov;;IT;ivanov@test.ru;
+;Petrov;;IT;petrov@test.ru;
+sidorov;Sidorov;;IT;sidorov@test.ru;
+`), testCSVMapping, CSVImportOptions{Comma: ';'})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 2, res.Skipped)
+	require.Equal(t, 1, res.Failed)
+	require.Len(t, res.Rows, res.Skipped+res.Failed)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestImportUsersCSVUpdate(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		switch describeRequest(req.Op) {
+		case "search CN=Ivanov,OU=IT,DC=test,DC=ru (objectClass=*) [department mail]":
+			return []testMessage{entryMessage("CN=Ivanov,OU=IT,DC=test,DC=ru", map[string][]string{
+				"department": {"IT"}, "mail": {"ivanov@test.ru"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "search CN=Petrov,OU=IT,DC=test,DC=ru (objectClass=*) [department mail employeeType]":
+			return []testMessage{entryMessage("CN=Petrov,OU=IT,DC=test,DC=ru", map[string][]string{
+				"department": {"IT"}, "mail": {"old@test.ru"}, "employeeType": {"contractor"},
+			}), searchDone(ldap.LDAPResultSuccess)}
+		case "search CN=Sidorov,OU=IT,DC=test,DC=ru (objectClass=*) [department mail]":
+			return []testMessage{searchDone(ldap.LDAPResultNoSuchObject)}
+		case "search ou=IT,dc=test,dc=ru (objectClass=*) [1.1]":
+			return []testMessage{entryMessage("OU=IT,DC=test,DC=ru", nil), searchDone(ldap.LDAPResultSuccess)}
+		}
+		return nil
+	})
+
+	res, err := conn.ImportUsersCSV(context.Background(), strings.NewReader(`Login;Name;Last name;Department;E-mail;Type
+ivanov;Ivanov;;IT;ivanov@test.ru;
+petrov;Petrov;;IT;petrov@test.ru;contractor
+sidorov;Sidorov;;IT;sidorov@test.ru;
+`), testCSVMapping, CSVImportOptions{Comma: ';', Update: true, Apply: true})
+	require.NoError(t, err)
+	require.Equal(t, []CSVRowStatus{CSVRowSkipped, CSVRowUpdated, CSVRowCreated},
+		[]CSVRowStatus{res.Rows[0].Status, res.Rows[1].Status, res.Rows[2].Status})
+	require.Equal(t, 1, res.Skipped)
+
+	// only changed attrs are replaced
+	requests := srv.Requests()
+	require.Len(t, requests, 6)
+	require.Equal(t, []string{
+		"search CN=Ivanov,OU=IT,DC=test,DC=ru (objectClass=*) [department mail]",
+		"search CN=Petrov,OU=IT,DC=test,DC=ru (objectClass=*) [department mail employeeType]",
+		"modify CN=Petrov,OU=IT,DC=test,DC=ru: replace mail [petrov@test.ru]",
+		"search CN=Sidorov,OU=IT,DC=test,DC=ru (objectClass=*) [department mail]",
+		"search ou=IT,dc=test,dc=ru (objectClass=*) [1.1]",
+	}, requests[:5])
+	require.True(t, strings.HasPrefix(requests[5], "add CN=Sidorov,OU=IT,DC=test,DC=ru: "), requests[5])
+}
+
+func TestSameValues(t *testing.T) {
+	require.True(t, sameValues(nil, []string{}))
+	require.True(t, sameValues([]string{"a", "b"}, []string{"b", "a"}))
+	require.False(t, sameValues([]string{"a"}, []string{"A"}))
+	require.False(t, sameValues([]string{"a", "a"}, []string{"a"}))
+}
+
+func TestCheckCSVDuplicate(t *testing.T) {
+	seen := make(map[string]int)
+	require.NoError(t, checkCSVDuplicate(seen, csvUser{row: 2, user: NewUser{DN: "CN=a,DC=ru", Login: "a"}}))
+	require.ErrorIs(t, checkCSVDuplicate(seen, csvUser{row: 3, user: NewUser{DN: "cn=A,dc=ru", Login: "b"}}), ErrInvalidInput)
+	require.ErrorIs(t, checkCSVDuplicate(seen, csvUser{row: 4, user: NewUser{DN: "CN=b,DC=ru", Login: "A"}}), ErrInvalidInput)
+	require.NoError(t, checkCSVDuplicate(seen, csvUser{row: 5, user: NewUser{DN: "CN=b,DC=ru", Login: "b"}}))
+}
+
+// attrValues - get add request attr values by type
+func attrValues(attrs []ldap.Attribute, attr string) []string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Type, attr) {
+			return a.Vals
+		}
+	}
+	return nil
+}
@@ -2,11 +2,14 @@
 package ldapper
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"strings"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
 )
 
 ////////////////////////////////////////////// LdapConn struct
@@ -21,6 +24,7 @@ type LdapConn struct {
 	user       string
 	password   string
 	useTLS     bool
+	tlsCfg     *tls.Config // passed tls config (nil for InsecureSkipVerify conns)
 	options    LdapConnOptions
 	Connection *ldap.Conn
 }
@@ -104,11 +108,21 @@ func NewLdapConnWithTLSConfig(userName, passWord,
 		user:       userName,
 		password:   passWord,
 		useTLS:     true,
+		tlsCfg:     tlsCfg,
 		options:    connOptions,
 		Connection: conn,
 	}, nil
 }
 
+// Clone - create new conn with the same params (host, credentials, tls, options)
+func (conn *LdapConn) Clone() (*LdapConn, error) {
+	if conn.tlsCfg != nil {
+		return NewLdapConnWithTLSConfig(conn.user, conn.password, conn.host, conn.port, conn.tlsCfg, conn.options)
+	}
+
+	return NewLdapConn(conn.user, conn.password, conn.host, conn.port, conn.useTLS, conn.options)
+}
+
 func (conn *LdapConn) Close() {
 	if conn.Connection != nil {
 		err := conn.Connection.Close()
@@ -222,6 +236,10 @@ func TestBaseDnWithTLSConfig(userName, passWord,
 
 // GetUserInfo - get user info
 func (conn *LdapConn) GetUserInfo(userName, baseDn string) (res UserFullInfo, err error) {
+	if baseDn, err = conn.resolveDN(context.Background(), baseDn); err != nil {
+		return res, err
+	}
+
 	var filter string
 	var attributes = make([]string, 0)
 
@@ -245,27 +263,7 @@ func (conn *LdapConn) GetUserInfo(userName, baseDn string) (res UserFullInfo, er
 
 	if searchResult != nil {
 		for _, entry := range searchResult.Entries {
-			res.CN = entry.GetAttributeValue("cn")
-			res.Mobile = entry.GetAttributeValue("mobile")
-			res.Mail = entry.GetAttributeValue("mail")
-			res.Title = entry.GetAttributeValue("title")
-
-			res.Manager = entry.GetAttributeValue("manager")
-			res.Phone = entry.GetAttributeValue("telephoneNumber")
-			res.Address = entry.GetAttributeValue("streetAddress")
-			res.City = entry.GetAttributeValue("l")
-			res.Room = entry.GetAttributeValue("physicalDeliveryOfficeName")
-			res.Index = entry.GetAttributeValue("postalCode")
-			res.Country = entry.GetAttributeValue("co")
-			res.Company = entry.GetAttributeValue("company")
-
-			if conn.options.OpenLDAP {
-				res.Department = entry.GetAttributeValue("departmentNumber")
-				res.Photo = entry.GetAttributeValue("jpegPhoto")
-			} else {
-				res.Department = entry.GetAttributeValue("department")
-				res.Photo = entry.GetAttributeValue("thumbnailPhoto")
-			}
+			res = conn.userFullInfoFromEntry(entry)
 		}
 	}
 
@@ -277,17 +275,23 @@ func (conn *LdapConn) GetUserInfo(userName, baseDn string) (res UserFullInfo, er
 	return res, err
 }
 
-// GetGroupUsers
-func (conn *LdapConn) GetGroupUsers(group string) (res []UserShortInfo, err error) {
+// GetGroupUsers Reading all users under group (ou) with account state filters
+func (conn *LdapConn) GetGroupUsers(group string, opts ...GroupUsersOptions) (res []UserShortInfo, err error) {
 	res = make([]UserShortInfo, 0)
+	if group, err = conn.resolveDN(context.Background(), group); err != nil {
+		return res, err
+	}
+	usersOpts := getGroupUsersOptions(opts)
+	now := time.Now()
 
 	var filter string
 	var attributes = make([]string, 0)
 	if conn.options.OpenLDAP {
 		filter = filterUserOpenLDAP
-		attributes = openLDAPGroupUserAttrs
+		attributes = append(attributes, openLDAPGroupUserAttrs...)
+		attributes = append(attributes, openLDAPAccountStatusAttrs...)
 	} else {
-		filter = filterUserAD
+		filter = usersOpts.userFilterAD(now)
 		attributes = ADGroupUserAttrs
 	}
 
@@ -303,51 +307,119 @@ func (conn *LdapConn) GetGroupUsers(group string) (res []UserShortInfo, err erro
 
 	if searchResult != nil {
 		for _, entry := range searchResult.Entries {
+			if conn.options.OpenLDAP && usersOpts.skipOpenLDAP(entry, now) {
+				continue
+			}
+			res = append(res, conn.userShortInfoFromEntry(entry))
+		}
+	}
 
-			var inf UserShortInfo
+	return res, err
+}
 
-			inf.Name = entry.GetAttributeValue("cn")
-			inf.Mail = entry.GetAttributeValue("mail")
-			inf.Title = entry.GetAttributeValue("title")
+// userFullInfoFromEntry - make user full info from found user entry
+func (conn *LdapConn) userFullInfoFromEntry(entry *ldap.Entry) (res UserFullInfo) {
+	conn.readUserProfile(entry, &res)
+	res.DName = entry.DN
 
-			if conn.options.OpenLDAP {
-				inf.Login = entry.GetAttributeValue("uid")
-				inf.Department = entry.GetAttributeValue("departmentNumber")
-			} else {
-				inf.Login = entry.GetAttributeValue("userPrincipalName")
-				inf.Department = entry.GetAttributeValue("department")
-			}
+	res.GUID = conn.entryGUID(entry)
+	if conn.options.OpenLDAP {
+		res.SID = entry.GetAttributeValue("sambaSID")
+		res.Status = accountStatusOpenLDAP(entry, time.Now())
+	} else {
+		if sid, err := codec.DecodeSID(entry.GetRawAttributeValue("objectSid")); err == nil {
+			res.SID = sid.String()
+		}
+		res.Status = accountStatusAD(entry, time.Now())
+	}
 
-			// for no Name users cases
-			if inf.Name == "" {
-				inf.Name = "NoName"
-			}
+	return res
+}
 
-			res = append(res, inf)
-		}
+// entryGUID - get entry guid string form (AD objectGUID, openLdap entryUUID), empty if not read
+func (conn *LdapConn) entryGUID(entry *ldap.Entry) string {
+	if conn.options.OpenLDAP {
+		return entry.GetAttributeValue("entryUUID")
 	}
 
-	return res, err
+	guid, err := codec.DecodeGUID(entry.GetRawAttributeValue("objectGUID"))
+	if err != nil {
+		return ""
+	}
+	return guid.String()
+}
+
+// userShortInfoFromEntry - make user short info from found user entry
+func (conn *LdapConn) userShortInfoFromEntry(entry *ldap.Entry) (inf UserShortInfo) {
+	inf.Name = entry.GetAttributeValue("cn")
+	inf.Mail = entry.GetAttributeValue("mail")
+	inf.Title = entry.GetAttributeValue("title")
+	inf.DName = entry.DN
+	inf.GUID = conn.entryGUID(entry)
+
+	if conn.options.OpenLDAP {
+		inf.Login = entry.GetAttributeValue("uid")
+		inf.Department = entry.GetAttributeValue("departmentNumber")
+	} else {
+		inf.Login = entry.GetAttributeValue("userPrincipalName")
+		inf.Department = entry.GetAttributeValue("department")
+	}
+
+	// for no Name users cases
+	if inf.Name == "" {
+		inf.Name = "NoName"
+	}
+
+	return inf
 }
 
 ////////////////////////////////////////////// Get struct methods
 
-// GetStruct Reading full AD structure (with depth 2)
-func (conn *LdapConn) GetStruct(baseDn string) (res ADStruct, err error) {
-	firstLevel, err := conn.GetRootGroups(baseDn)
+// GetStruct Reading full AD structure (with depth DepthOfLdapSearch by default, see TreeOptions)
+func (conn *LdapConn) GetStruct(baseDn string, opts ...TreeOptions) (res ADStruct, err error) {
+	return conn.GetStructContext(context.Background(), baseDn, opts...)
+}
+
+// GetStructContext Reading full AD structure level by level (with depth DepthOfLdapSearch by default).
+// Subgroups of one level are read concurrently if TreeOptions.Concurrency > 1, children are sorted by name
+func (conn *LdapConn) GetStructContext(ctx context.Context, baseDn string, opts ...TreeOptions) (res ADStruct, err error) {
+	treeOpts := getTreeOptions(opts)
+	if treeOpts.StartDN != "" {
+		baseDn = treeOpts.StartDN
+	}
+	baseDn, err = conn.resolveDN(ctx, baseDn)
+	if err != nil {
+		return ADStruct{}, err
+	}
+	filter := newTreeFilter(treeOpts)
+
+	firstLevel, err := conn.getGroups(ctx, baseDn, ldap.ScopeSingleLevel, filter.search)
 	if err != nil {
 		return ADStruct{}, err
 	}
 
-	if firstLevel != nil {
-		_ = conn.GetRecursiveSearchResult(&firstLevel, 2)
-		res.AD = firstLevel
+	var conns connSource = singleConn{conn: conn}
+	switch {
+	case treeOpts.Pool != nil:
+		conns = treeOpts.Pool
+	case treeOpts.Concurrency > 1:
+		pool := NewConnPool(conn, treeOpts.Concurrency)
+		defer pool.Close()
+		conns = pool
+	}
+
+	firstLevel, _ = filter.level(firstLevel)
+	err = readTree(ctx, conns, firstLevel, filter)
+	if err != nil {
+		return ADStruct{}, err
 	}
+	res.AD = filter.prune(firstLevel)
 
 	return res, nil
 }
 
 // GetRecursiveSearchResult - run recursive search in AD (group->subgroup->etc.), return groups tree info
+// (legacy, ou only with depth DepthOfLdapSearch, use GetStructContext with TreeOptions instead)
 func (conn *LdapConn) GetRecursiveSearchResult(prevLevel *[]GroupInfo, level int) *[]GroupInfo {
 	nextLevel := make([]GroupInfo, 0)
 	for k, v := range *prevLevel {
@@ -362,51 +434,36 @@ func (conn *LdapConn) GetRecursiveSearchResult(prevLevel *[]GroupInfo, level int
 	return &nextLevel
 }
 
-// GetRootGroups Reading root AD folders (ou)
-func (conn *LdapConn) GetRootGroups(baseDn string) (res []GroupInfo, err error) {
-	res = make([]GroupInfo, 0)
-
-	var attributes = make([]string, 0)
-	if conn.options.OpenLDAP {
-		attributes = openLDAPGroupAttrs
-	} else {
-		attributes = ADGroupAttrs
-	}
-
+// hasSubGroups - check if group has at least one subgroup matching search filter
+func (conn *LdapConn) hasSubGroups(ctx context.Context, group, search string) (bool, error) {
 	searchRequest := ldap.NewSearchRequest(
-		baseDn,
-		ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
-		filterGroup,
-		attributes,
+		group,
+		ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 1, 0, false,
+		search,
+		[]string{"1.1"},
 		nil,
 	)
 
-	searchResult, err := conn.Connection.Search(searchRequest)
-	if err != nil {
-		return res, fmt.Errorf("bad search: %s", err.Error())
+	searchResult, err := conn.search(ctx, searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return false, fmt.Errorf("bad search: %s", err.Error())
 	}
 
-	for _, entry := range searchResult.Entries {
-		var inf GroupInfo
-
-		inf.Ou = entry.GetAttributeValue("ou")
-
-		if conn.options.OpenLDAP {
-			inf.Name = inf.Ou
-			inf.DName = entry.DN
-		} else {
-			inf.Name = entry.GetAttributeValue("name")
-			inf.DName = entry.GetAttributeValue("distinguishedName")
-		}
-
-		res = append(res, inf)
-	}
+	return len(searchResult.Entries) > 0, nil
+}
 
-	return res, nil
+// GetRootGroups Reading root AD folders (ou)
+func (conn *LdapConn) GetRootGroups(baseDn string) (res []GroupInfo, err error) {
+	return conn.getGroups(context.Background(), baseDn, ldap.ScopeSingleLevel, filterGroup)
 }
 
 // GetSubGroups Reading AD subFolders in group
 func (conn *LdapConn) GetSubGroups(group string, level int) (res []GroupInfo, err error) {
+	return conn.getSubGroups(context.Background(), group, level, filterGroup)
+}
+
+// getGroups - read groups matching search filter
+func (conn *LdapConn) getGroups(ctx context.Context, baseDn string, scope int, search string) (res []GroupInfo, err error) {
 	res = make([]GroupInfo, 0)
 
 	var attributes = make([]string, 0)
@@ -417,37 +474,66 @@ func (conn *LdapConn) GetSubGroups(group string, level int) (res []GroupInfo, er
 	}
 
 	searchRequest := ldap.NewSearchRequest(
-		group,
-		level, ldap.NeverDerefAliases, 0, 0, false,
-		filterGroup,
+		baseDn,
+		scope, ldap.NeverDerefAliases, 0, 0, false,
+		search,
 		attributes,
 		nil,
 	)
 
-	searchResult, err := conn.Connection.Search(searchRequest)
+	searchResult, err := conn.search(ctx, searchRequest)
 	if err != nil {
 		return res, fmt.Errorf("bad search: %s", err.Error())
 	}
 
 	for _, entry := range searchResult.Entries {
-		var inf GroupInfo
-		inf.Ou = entry.GetAttributeValue("ou")
-
-		if conn.options.OpenLDAP {
-			inf.Name = inf.Ou
-			inf.DName = entry.DN
-		} else {
-			inf.Name = entry.GetAttributeValue("name")
-			inf.DName = entry.GetAttributeValue("distinguishedName")
-		}
+		res = append(res, conn.groupInfoFromEntry(entry))
+	}
+
+	return res, nil
+}
+
+// getSubGroups - read groups matching search filter under passed group
+func (conn *LdapConn) getSubGroups(ctx context.Context, group string, level int, search string) (res []GroupInfo, err error) {
+	found, err := conn.getGroups(ctx, group, level, search)
+	if err != nil {
+		return found, err
+	}
 
+	res = make([]GroupInfo, 0, len(found))
+	for _, inf := range found {
 		// Needed for recursive AD struct search
 		inf.Has = make([]GroupInfo, 0)
 
-		if strings.Contains(inf.DName, group) && inf.DName != group {
+		if isSubDN(group, inf.DName) {
 			res = append(res, inf)
 		}
 	}
 
 	return res, nil
 }
+
+// groupInfoFromEntry - make group info from found group entry
+func (conn *LdapConn) groupInfoFromEntry(entry *ldap.Entry) (inf GroupInfo) {
+	inf.Ou = entry.GetAttributeValue("ou")
+	inf.Kind = groupKindOf(entry)
+	inf.GUID = conn.entryGUID(entry)
+
+	if conn.options.OpenLDAP {
+		inf.Name = inf.Ou
+		inf.DName = entry.DN
+	} else {
+		inf.Name = entry.GetAttributeValue("name")
+		inf.DName = entry.GetAttributeValue("distinguishedName")
+	}
+
+	// not ou nodes (containers, groups, etc.)
+	if inf.Name == "" {
+		inf.Name = entry.GetAttributeValue("cn")
+	}
+	if inf.Name == "" {
+		inf.Name, _ = RDNValue(entry.DN)
+	}
+
+	return inf
+}
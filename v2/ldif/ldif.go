@@ -0,0 +1,46 @@
+// Copyright 2020-2024 NGR Softlab
+
+// Package ldif LDIF (RFC 2849) reading and writing of directory entries.
+package ldif
+
+import (
+	"unicode/utf8"
+)
+
+////////////////////////////////////////////// Constants
+
+const (
+	// Version LDIF version written to header
+	Version = 1
+
+	// LineWidth max line length before folding
+	LineWidth = 76
+)
+
+////////////////////////////////////////////// Value helpers
+
+// IsSafeString Check if value can be written as is (RFC 2849 SAFE-STRING), other values are base64 encoded.
+// Values ending with space are not safe too (trailing spaces are lost by many tools)
+func IsSafeString(value []byte) bool {
+	if len(value) == 0 {
+		return true
+	}
+
+	// SAFE-INIT-CHAR: no NUL, LF, CR, SPACE, colon, less-than
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if value[len(value)-1] == ' ' {
+		return false
+	}
+
+	// SAFE-CHAR: ascii without NUL, LF, CR
+	for _, c := range value {
+		if c == 0 || c == '\n' || c == '\r' || c >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
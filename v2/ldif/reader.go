@@ -0,0 +1,460 @@
+// Copyright 2020-2024 NGR Softlab
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+////////////////////////////////////////////// Records
+
+// ChangeType LDIF record change type
+type ChangeType string
+
+const (
+	ChangeAdd    ChangeType = "add" // content records are add records too
+	ChangeModify ChangeType = "modify"
+	ChangeDelete ChangeType = "delete"
+	ChangeModRDN ChangeType = "modrdn" // moddn is parsed as modrdn
+)
+
+// modify operations
+const (
+	ModAdd     = "add"
+	ModDelete  = "delete"
+	ModReplace = "replace"
+)
+
+// Attribute Attribute with values (values can be binary)
+type Attribute struct {
+	Name   string
+	Values []string
+}
+
+// Modification One modify record operation (delete with no values - delete whole attribute)
+type Modification struct {
+	Op string // ModAdd, ModDelete or ModReplace
+	Attribute
+}
+
+// Control Record control ("control: oid [criticality] [value]")
+type Control struct {
+	OID         string
+	Criticality bool
+	Value       string
+}
+
+// Record LDIF record (content or change one)
+type Record struct {
+	Line     int // line number of record start
+	DN       string
+	Type     ChangeType
+	Controls []Control
+
+	Attributes    []Attribute    // add records attrs
+	Modifications []Modification // modify records operations
+
+	NewRDN       string // modrdn records new rdn
+	DeleteOldRDN bool
+	NewSuperior  string // new parent dn (empty - same parent)
+}
+
+// ParseError LDIF syntax error with line number
+type ParseError struct {
+	Line int
+	Err  error
+}
+
+// Error Error text with line number
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("ldif line %d: %s", e.Line, e.Err.Error())
+}
+
+// Unwrap Underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+////////////////////////////////////////////// Reader
+
+// Reader LDIF reader (content and change records)
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int // last read physical line number
+
+	pending     *logicalLine // line read ahead (record start)
+	versionRead bool
+}
+
+// logicalLine - unfolded line with its first physical line number
+type logicalLine struct {
+	text string
+	line int
+}
+
+// NewReader Create LDIF reader
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	// long base64 values (photos) are folded, but unfolded lines can be big
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	return &Reader{scanner: scanner}
+}
+
+// ReadAll Read all records
+func (r *Reader) ReadAll() ([]*Record, error) {
+	res := make([]*Record, 0)
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rec)
+	}
+}
+
+// Next Read next record (io.EOF if there are no more records)
+func (r *Reader) Next() (*Record, error) {
+	lines, err := r.readRecordLines()
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, io.EOF
+	}
+
+	return parseRecord(lines)
+}
+
+// readRecordLines - read unfolded lines of next record (comments are skipped, version line is consumed)
+func (r *Reader) readRecordLines() ([]logicalLine, error) {
+	lines := make([]logicalLine, 0)
+	for {
+		l, ok, err := r.nextLogicalLine()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return lines, nil
+		}
+
+		if l.text == "" {
+			if len(lines) > 0 {
+				return lines, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(l.text, "#") {
+			continue
+		}
+
+		if !r.versionRead && len(lines) == 0 && strings.HasPrefix(l.text, "version:") {
+			r.versionRead = true
+			version := strings.TrimSpace(strings.TrimPrefix(l.text, "version:"))
+			if version != strconv.Itoa(Version) {
+				return nil, &ParseError{Line: l.line, Err: fmt.Errorf("unsupported version %q", version)}
+			}
+			continue
+		}
+		r.versionRead = true
+
+		lines = append(lines, l)
+	}
+}
+
+// nextLogicalLine - read next line with its continuation lines
+func (r *Reader) nextLogicalLine() (logicalLine, bool, error) {
+	var res logicalLine
+	if r.pending != nil {
+		res = *r.pending
+		r.pending = nil
+	} else {
+		text, ok, err := r.nextPhysicalLine()
+		if err != nil || !ok {
+			return res, ok, err
+		}
+		res = logicalLine{text: text, line: r.line}
+	}
+
+	if res.text == "" {
+		return res, true, nil
+	}
+
+	for {
+		text, ok, err := r.nextPhysicalLine()
+		if err != nil {
+			return res, false, err
+		}
+		if !ok {
+			return res, true, nil
+		}
+		if !strings.HasPrefix(text, " ") {
+			r.pending = &logicalLine{text: text, line: r.line}
+			return res, true, nil
+		}
+		res.text += text[1:]
+	}
+}
+
+// nextPhysicalLine - read next line without line ending
+func (r *Reader) nextPhysicalLine() (string, bool, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", false, &ParseError{Line: r.line + 1, Err: err}
+		}
+		return "", false, nil
+	}
+	r.line++
+
+	return strings.TrimSuffix(r.scanner.Text(), "\r"), true, nil
+}
+
+////////////////////////////////////////////// Parsing
+
+// parseRecord - parse record from its unfolded lines
+func parseRecord(lines []logicalLine) (*Record, error) {
+	name, dn, err := parseLine(lines[0])
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(name, "dn") {
+		return nil, &ParseError{Line: lines[0].line, Err: fmt.Errorf("record must start with dn, got %q", name)}
+	}
+
+	rec := &Record{Line: lines[0].line, DN: dn, Type: ChangeAdd}
+	lines = lines[1:]
+
+	// controls and changetype go before changes
+	changeRecord := false
+	for len(lines) > 0 {
+		name, value, err := parseLine(lines[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.EqualFold(name, "control") {
+			control, err := parseControl(value)
+			if err != nil {
+				return nil, &ParseError{Line: lines[0].line, Err: err}
+			}
+			rec.Controls = append(rec.Controls, control)
+			lines = lines[1:]
+			continue
+		}
+		if strings.EqualFold(name, "changetype") {
+			changeRecord = true
+			switch strings.ToLower(value) {
+			case "add":
+				rec.Type = ChangeAdd
+			case "modify":
+				rec.Type = ChangeModify
+			case "delete":
+				rec.Type = ChangeDelete
+			case "modrdn", "moddn":
+				rec.Type = ChangeModRDN
+			default:
+				return nil, &ParseError{Line: lines[0].line, Err: fmt.Errorf("unknown changetype %q", value)}
+			}
+			lines = lines[1:]
+		}
+		break
+	}
+	if len(rec.Controls) > 0 && !changeRecord {
+		return nil, &ParseError{Line: rec.Line, Err: errors.New("controls are allowed only in change records")}
+	}
+
+	switch rec.Type {
+	case ChangeAdd:
+		err = parseAddRecord(rec, lines)
+	case ChangeModify:
+		err = parseModifyRecord(rec, lines)
+	case ChangeDelete:
+		if len(lines) > 0 {
+			err = &ParseError{Line: lines[0].line, Err: errors.New("delete record can't have attributes")}
+		}
+	case ChangeModRDN:
+		err = parseModRDNRecord(rec, lines)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// parseAddRecord - parse attrs of add (content) record
+func parseAddRecord(rec *Record, lines []logicalLine) error {
+	if len(lines) == 0 {
+		return &ParseError{Line: rec.Line, Err: errors.New("add record has no attributes")}
+	}
+
+	for _, l := range lines {
+		name, value, err := parseLine(l)
+		if err != nil {
+			return err
+		}
+		rec.Attributes = addValue(rec.Attributes, name, value)
+	}
+
+	return nil
+}
+
+// parseModifyRecord - parse operations of modify record ("op: attr", values, "-")
+func parseModifyRecord(rec *Record, lines []logicalLine) error {
+	var current *Modification
+	for _, l := range lines {
+		if l.text == "-" {
+			if current == nil {
+				return &ParseError{Line: l.line, Err: errors.New("unexpected \"-\"")}
+			}
+			rec.Modifications = append(rec.Modifications, *current)
+			current = nil
+			continue
+		}
+
+		name, value, err := parseLine(l)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			op := strings.ToLower(name)
+			if op != ModAdd && op != ModDelete && op != ModReplace {
+				return &ParseError{Line: l.line, Err: fmt.Errorf("unknown modify operation %q", name)}
+			}
+			current = &Modification{Op: op, Attribute: Attribute{Name: value}}
+			continue
+		}
+
+		if !strings.EqualFold(name, current.Name) {
+			return &ParseError{Line: l.line, Err: fmt.Errorf("attribute %q doesn't match operation attribute %q", name, current.Name)}
+		}
+		current.Values = append(current.Values, value)
+	}
+
+	if current != nil {
+		// last "-" can be omitted
+		rec.Modifications = append(rec.Modifications, *current)
+	}
+	if len(rec.Modifications) == 0 {
+		return &ParseError{Line: rec.Line, Err: errors.New("modify record has no operations")}
+	}
+	for _, m := range rec.Modifications {
+		if m.Op == ModAdd && len(m.Values) == 0 {
+			return &ParseError{Line: rec.Line, Err: fmt.Errorf("add of %q has no values", m.Name)}
+		}
+	}
+
+	return nil
+}
+
+// parseModRDNRecord - parse newrdn, deleteoldrdn and newsuperior of modrdn record
+func parseModRDNRecord(rec *Record, lines []logicalLine) error {
+	deleteOldRDNRead := false
+	for _, l := range lines {
+		name, value, err := parseLine(l)
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(name) {
+		case "newrdn":
+			rec.NewRDN = value
+		case "deleteoldrdn":
+			switch value {
+			case "0":
+				rec.DeleteOldRDN = false
+			case "1":
+				rec.DeleteOldRDN = true
+			default:
+				return &ParseError{Line: l.line, Err: fmt.Errorf("bad deleteoldrdn value %q", value)}
+			}
+			deleteOldRDNRead = true
+		case "newsuperior":
+			rec.NewSuperior = value
+		default:
+			return &ParseError{Line: l.line, Err: fmt.Errorf("unexpected %q in modrdn record", name)}
+		}
+	}
+
+	if rec.NewRDN == "" || !deleteOldRDNRead {
+		return &ParseError{Line: rec.Line, Err: errors.New("modrdn record needs newrdn and deleteoldrdn")}
+	}
+
+	return nil
+}
+
+// parseLine - parse "name: value", "name:: base64" or "name:< url" line
+func parseLine(l logicalLine) (name, value string, err error) {
+	i := strings.IndexByte(l.text, ':')
+	if i <= 0 {
+		return "", "", &ParseError{Line: l.line, Err: fmt.Errorf("bad line %q", l.text)}
+	}
+	name, rest := l.text[:i], l.text[i+1:]
+
+	switch {
+	case strings.HasPrefix(rest, ":"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return "", "", &ParseError{Line: l.line, Err: fmt.Errorf("bad base64 value of %q: %s", name, err.Error())}
+		}
+		return name, string(decoded), nil
+	case strings.HasPrefix(rest, "<"):
+		return "", "", &ParseError{Line: l.line, Err: fmt.Errorf("url values are not supported (%q)", name)}
+	default:
+		return name, strings.TrimLeft(rest, " "), nil
+	}
+}
+
+// parseControl - parse "oid [true|false] [value]" of control line (value can be base64 "oid true:: dmFs")
+func parseControl(value string) (Control, error) {
+	var res Control
+
+	spec, controlValue, hasValue := strings.Cut(value, ":")
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return res, fmt.Errorf("bad control %q", value)
+	}
+	res.OID = fields[0]
+	if len(fields) == 2 {
+		criticality, err := strconv.ParseBool(fields[1])
+		if err != nil {
+			return res, fmt.Errorf("bad control criticality %q", fields[1])
+		}
+		res.Criticality = criticality
+	}
+
+	if hasValue {
+		if strings.HasPrefix(controlValue, ":") {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(controlValue[1:]))
+			if err != nil {
+				return res, fmt.Errorf("bad control base64 value: %s", err.Error())
+			}
+			res.Value = string(decoded)
+		} else {
+			res.Value = strings.TrimLeft(controlValue, " ")
+		}
+	}
+
+	return res, nil
+}
+
+// addValue - add value to attrs list (attrs with the same name are merged, case-insensitive)
+func addValue(attrs []Attribute, name, value string) []Attribute {
+	for i := range attrs {
+		if strings.EqualFold(attrs[i].Name, name) {
+			attrs[i].Values = append(attrs[i].Values, value)
+			return attrs
+		}
+	}
+
+	return append(attrs, Attribute{Name: name, Values: []string{value}})
+}
@@ -0,0 +1,128 @@
+// Copyright 2020-2024 NGR Softlab
+package ldif
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const changesLDIF = `version: 1
+
+# new user
+dn: CN=Ivan Ivanov,OU=Sales,DC=test,DC=ru
+objectClass: top
+objectClass: person
+cn: Ivan Ivanov
+description: long descr
+ iption
+thumbnailPhoto:: AAEC
+
+dn: CN=Petr Petrov,OU=Sales,DC=test,DC=ru
+changetype: modify
+replace: title
+title: Manager
+-
+delete: mobile
+-
+add: mail
+mail: p.petrov@test.ru
+
+dn: CN=Old User,OU=Sales,DC=test,DC=ru
+control: 1.2.840.113556.1.4.805 true
+changetype: delete
+
+dn:: Q0490JjQstCw0L0sT1U9U2FsZXMsREM9dGVzdCxEQz1ydQ==
+changetype: moddn
+newrdn: CN=Ivan Petrov
+deleteoldrdn: 1
+newsuperior: OU=IT,DC=test,DC=ru
+`
+
+func TestReader(t *testing.T) {
+	records, err := NewReader(strings.NewReader(changesLDIF)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	require.Equal(t, &Record{
+		Line: 4,
+		DN:   "CN=Ivan Ivanov,OU=Sales,DC=test,DC=ru",
+		Type: ChangeAdd,
+		Attributes: []Attribute{
+			{Name: "objectClass", Values: []string{"top", "person"}},
+			{Name: "cn", Values: []string{"Ivan Ivanov"}},
+			{Name: "description", Values: []string{"long description"}},
+			{Name: "thumbnailPhoto", Values: []string{"\x00\x01\x02"}},
+		},
+	}, records[0])
+
+	require.Equal(t, ChangeModify, records[1].Type)
+	require.Equal(t, []Modification{
+		{Op: ModReplace, Attribute: Attribute{Name: "title", Values: []string{"Manager"}}},
+		{Op: ModDelete, Attribute: Attribute{Name: "mobile"}},
+		{Op: ModAdd, Attribute: Attribute{Name: "mail", Values: []string{"p.petrov@test.ru"}}},
+	}, records[1].Modifications)
+
+	require.Equal(t, ChangeDelete, records[2].Type)
+	require.Equal(t, []Control{{OID: "1.2.840.113556.1.4.805", Criticality: true}}, records[2].Controls)
+
+	require.Equal(t, &Record{
+		Line:         26,
+		DN:           "CN=Иван,OU=Sales,DC=test,DC=ru",
+		Type:         ChangeModRDN,
+		NewRDN:       "CN=Ivan Petrov",
+		DeleteOldRDN: true,
+		NewSuperior:  "OU=IT,DC=test,DC=ru",
+	}, records[3])
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ldif string
+		line int
+	}{
+		{name: "bad version", ldif: "version: 2\n\ndn: cn=a\ncn: a\n", line: 1},
+		{name: "no dn", ldif: "cn: a\n", line: 1},
+		{name: "bad changetype", ldif: "dn: cn=a\nchangetype: rename\n", line: 2},
+		{name: "bad base64", ldif: "dn: cn=a\ncn:: ???\n", line: 2},
+		{name: "url value", ldif: "dn: cn=a\njpegPhoto:< file:///tmp/a.jpg\n", line: 2},
+		{name: "modify attr mismatch", ldif: "dn: cn=a\nchangetype: modify\nreplace: title\nmail: a@b\n-\n", line: 4},
+		{name: "modify bad op", ldif: "dn: cn=a\nchangetype: modify\nincrement: uidNumber\n", line: 3},
+		{name: "modrdn without newrdn", ldif: "dn: cn=a\nchangetype: modrdn\ndeleteoldrdn: 1\n", line: 1},
+		{name: "delete with attrs", ldif: "dn: cn=a\nchangetype: delete\ncn: a\n", line: 3},
+		{name: "control in content record", ldif: "dn: cn=a\ncontrol: 1.2.3\ncn: a\n", line: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(strings.NewReader(tt.ldif)).ReadAll()
+			var parseErr *ParseError
+			require.ErrorAs(t, err, &parseErr)
+			require.Equal(t, tt.line, parseErr.Line)
+		})
+	}
+}
+
+func TestWriteRecordRoundTrip(t *testing.T) {
+	records, err := NewReader(strings.NewReader(changesLDIF)).ReadAll()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, rec := range records {
+		require.NoError(t, w.WriteRecord(rec))
+	}
+	require.NoError(t, w.Flush())
+
+	written, err := NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, written, len(records))
+	for i := range records {
+		// line numbers differ
+		written[i].Line = records[i].Line
+		require.Equal(t, records[i], written[i])
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2020-2024 NGR Softlab
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Writer
+
+// Writer LDIF writer (version header is written before the first record)
+type Writer struct {
+	w       *bufio.Writer
+	records int
+}
+
+// NewWriter Create LDIF writer (Flush must be called after writing)
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteEntry Write entry as content record (binary and non-ascii values are base64 encoded)
+func (w *Writer) WriteEntry(entry *ldap.Entry) error {
+	if err := w.startRecord(entry.DN); err != nil {
+		return err
+	}
+
+	for _, attr := range entry.Attributes {
+		values := attr.ByteValues
+		if len(values) == 0 {
+			// hand made attrs can have only string values
+			for _, v := range attr.Values {
+				values = append(values, []byte(v))
+			}
+		}
+		for _, v := range values {
+			if err := w.writeLine(attr.Name, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteRecord Write record (content record for add records without controls, change record otherwise)
+func (w *Writer) WriteRecord(rec *Record) error {
+	if err := w.startRecord(rec.DN); err != nil {
+		return err
+	}
+
+	for _, c := range rec.Controls {
+		control := c.OID
+		if c.Criticality {
+			control += " true"
+		}
+		if c.Value != "" {
+			if IsSafeString([]byte(c.Value)) {
+				control += ": " + c.Value
+			} else {
+				control += ":: " + base64.StdEncoding.EncodeToString([]byte(c.Value))
+			}
+		}
+		if err := w.writeFolded("control: " + control); err != nil {
+			return err
+		}
+	}
+	if rec.Type != ChangeAdd || len(rec.Controls) > 0 {
+		if err := w.writeLine("changetype", []byte(rec.Type)); err != nil {
+			return err
+		}
+	}
+
+	switch rec.Type {
+	case ChangeAdd:
+		return w.writeAttributes(rec.Attributes)
+	case ChangeModify:
+		for _, m := range rec.Modifications {
+			if err := w.writeLine(m.Op, []byte(m.Name)); err != nil {
+				return err
+			}
+			if err := w.writeAttributes([]Attribute{m.Attribute}); err != nil {
+				return err
+			}
+			if err := w.writeFolded("-"); err != nil {
+				return err
+			}
+		}
+	case ChangeModRDN:
+		deleteOldRDN := "0"
+		if rec.DeleteOldRDN {
+			deleteOldRDN = "1"
+		}
+		if err := w.writeLine("newrdn", []byte(rec.NewRDN)); err != nil {
+			return err
+		}
+		if err := w.writeLine("deleteoldrdn", []byte(deleteOldRDN)); err != nil {
+			return err
+		}
+		if rec.NewSuperior != "" {
+			return w.writeLine("newsuperior", []byte(rec.NewSuperior))
+		}
+	}
+
+	return nil
+}
+
+// WriteComment Write comment line(s) before the next record
+func (w *Writer) WriteComment(comment string) error {
+	for _, line := range strings.Split(comment, "\n") {
+		if err := w.writeFolded("# " + line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records Count of written records
+func (w *Writer) Records() int {
+	return w.records
+}
+
+// Flush Write buffered data to underlying writer
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// writeAttributes - write attr lines for every value
+func (w *Writer) writeAttributes(attrs []Attribute) error {
+	for _, attr := range attrs {
+		for _, v := range attr.Values {
+			if err := w.writeLine(attr.Name, []byte(v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startRecord - write version header (before the first record) or records separator and dn line
+func (w *Writer) startRecord(dn string) error {
+	var err error
+	if w.records == 0 {
+		_, err = fmt.Fprintf(w.w, "version: %d\n\n", Version)
+	} else {
+		_, err = w.w.WriteString("\n")
+	}
+	if err != nil {
+		return err
+	}
+	w.records++
+
+	return w.writeLine("dn", []byte(dn))
+}
+
+// writeLine - write "attr: value" line ("attr:: base64" for not safe values)
+func (w *Writer) writeLine(attr string, value []byte) error {
+	if IsSafeString(value) {
+		return w.writeFolded(attr + ": " + string(value))
+	}
+	return w.writeFolded(attr + ":: " + base64.StdEncoding.EncodeToString(value))
+}
+
+// writeFolded - write line folded by LineWidth (continuation lines start with space)
+func (w *Writer) writeFolded(line string) error {
+	width := LineWidth
+	for len(line) > width {
+		// don't split utf-8 chars (only comments can have them)
+		cut := width
+		for cut > 1 && !isCharStart(line[cut]) {
+			cut--
+		}
+		if _, err := w.w.WriteString(line[:cut] + "\n "); err != nil {
+			return err
+		}
+		line = line[cut:]
+		// continuation lines have leading space
+		width = LineWidth - 1
+	}
+
+	_, err := w.w.WriteString(line + "\n")
+	return err
+}
+
+// isCharStart - check if byte starts utf-8 char
+func isCharStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
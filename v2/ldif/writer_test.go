@@ -0,0 +1,91 @@
+// Copyright 2020-2024 NGR Softlab
+package ldif
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsSafeString(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		safe  bool
+	}{
+		{name: "empty", value: "", safe: true},
+		{name: "plain", value: "Ivan Ivanov", safe: true},
+		{name: "colon inside", value: "a:b", safe: true},
+		{name: "leading space", value: " Ivan", safe: false},
+		{name: "trailing space", value: "Ivan ", safe: false},
+		{name: "leading colon", value: ":Ivan", safe: false},
+		{name: "leading less-than", value: "<Ivan>", safe: false},
+		{name: "new line", value: "Ivan\nIvanov", safe: false},
+		{name: "non-ascii", value: "Иван", safe: false},
+		{name: "binary", value: "\x00\x01", safe: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.safe, IsSafeString([]byte(tt.value)))
+		})
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	require.NoError(t, w.WriteEntry(ldap.NewEntry("CN=Ivan Ivanov,OU=Sales,DC=test,DC=ru", map[string][]string{
+		"cn": {"Ivan Ivanov"},
+	})))
+	require.NoError(t, w.WriteEntry(&ldap.Entry{
+		DN: "CN=Иван,OU=Sales,DC=test,DC=ru",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "objectGUID", ByteValues: [][]byte{{0x00, 0x11, 0x22, 0x33}}},
+			{Name: "description", Values: []string{strings.Repeat("a", 80)}},
+			{Name: "objectClass", Values: []string{"top", "person"}},
+		},
+	}))
+	require.NoError(t, w.Flush())
+	require.Equal(t, 2, w.Records())
+
+	require.Equal(t, "version: 1\n"+
+		"\n"+
+		"dn: CN=Ivan Ivanov,OU=Sales,DC=test,DC=ru\n"+
+		"cn: Ivan Ivanov\n"+
+		"\n"+
+		"dn:: Q0490JjQstCw0L0sT1U9U2FsZXMsREM9dGVzdCxEQz1ydQ==\n"+
+		"objectGUID:: ABEiMw==\n"+
+		"description: "+strings.Repeat("a", 63)+"\n"+
+		" "+strings.Repeat("a", 17)+"\n"+
+		"objectClass: top\n"+
+		"objectClass: person\n", buf.String())
+}
+
+func TestWriterFolding(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	require.NoError(t, w.WriteEntry(&ldap.Entry{
+		DN: "cn=test",
+		Attributes: []*ldap.EntryAttribute{
+			{Name: "description", Values: []string{strings.Repeat("b", 300)}},
+		},
+	}))
+	require.NoError(t, w.Flush())
+
+	var unfolded strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		require.LessOrEqual(t, len(line), LineWidth)
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+		} else {
+			unfolded.WriteString("\n" + line)
+		}
+	}
+	require.Contains(t, unfolded.String(), "\ndescription: "+strings.Repeat("b", 300))
+}
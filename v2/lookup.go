@@ -0,0 +1,262 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
+)
+
+////////////////////////////////////////////// Lookup by GUID/SID
+
+// GetByGUID Get user (or other object) full info by guid (AD objectGUID, openLdap entryUUID) under baseDn
+func (conn *LdapConn) GetByGUID(ctx context.Context, baseDn, guid string) (UserFullInfo, error) {
+	parsed, err := codec.ParseGUID(guid)
+	if err != nil {
+		return UserFullInfo{}, err
+	}
+
+	// openLdap entryUUID is a plain (RFC 4122) uuid string, AD objectGUID is mixed-endian binary
+	filter := fmt.Sprintf(filterByGUIDAD, parsed.FilterValue())
+	if conn.options.OpenLDAP {
+		filter = fmt.Sprintf(filterByEntryUUID, parsed.String())
+	}
+
+	return conn.getUserByFilter(ctx, baseDn, filter, guid)
+}
+
+// GetBySID Get user (or other object) full info by sid string (AD objectSid, openLdap samba sambaSID) under baseDn
+func (conn *LdapConn) GetBySID(ctx context.Context, baseDn, sid string) (UserFullInfo, error) {
+	parsed, err := codec.ParseSID(sid)
+	if err != nil {
+		return UserFullInfo{}, err
+	}
+
+	filter := fmt.Sprintf(filterBySID, parsed.FilterValue())
+	if conn.options.OpenLDAP {
+		filter = fmt.Sprintf(filterBySambaSID, ldap.EscapeFilter(parsed.String()))
+	}
+
+	return conn.getUserByFilter(ctx, baseDn, filter, sid)
+}
+
+// getUserByFilter - get one user full info by unique filter (ErrNotFound if there is no such user)
+func (conn *LdapConn) getUserByFilter(ctx context.Context, baseDn, filter, id string) (UserFullInfo, error) {
+	baseDn, err := conn.resolveDN(ctx, baseDn)
+	if err != nil {
+		return UserFullInfo{}, err
+	}
+
+	var attributes = make([]string, 0)
+	if conn.options.OpenLDAP {
+		attributes = openLDAPUserAttrs
+	} else {
+		attributes = ADUserAttrs
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	searchResult, err := conn.search(ctx, searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(searchResult.Entries) == 0) {
+		return UserFullInfo{}, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return UserFullInfo{}, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	return conn.userFullInfoFromEntry(searchResult.Entries[0]), nil
+}
+
+////////////////////////////////////////////// AD extended DN forms
+
+// GUIDBaseDN Make AD "<GUID=...>" dn form, it can be used as search base instead of dn (AD only)
+func GUIDBaseDN(guid string) (string, error) {
+	parsed, err := codec.ParseGUID(guid)
+	if err != nil {
+		return "", err
+	}
+
+	return "<GUID=" + parsed.String() + ">", nil
+}
+
+// SIDBaseDN Make AD "<SID=...>" dn form, it can be used as search base instead of dn (AD only)
+func SIDBaseDN(sid string) (string, error) {
+	parsed, err := codec.ParseSID(sid)
+	if err != nil {
+		return "", err
+	}
+
+	return "<SID=" + parsed.String() + ">", nil
+}
+
+// isExtendedDNForm - check if dn is AD "<GUID=...>", "<SID=...>" or "<WKGUID=...>" form
+func isExtendedDNForm(dn string) bool {
+	dn = strings.TrimSpace(dn)
+	return strings.HasPrefix(dn, "<") && strings.HasSuffix(dn, ">")
+}
+
+// resolveDN - get real dn for AD "<GUID=...>"/"<SID=...>" dn forms (other dns are returned as is).
+// Every dn taking method resolves its dns by it, so compared and returned dns are always real ones
+func (conn *LdapConn) resolveDN(ctx context.Context, dn string) (string, error) {
+	if !isExtendedDNForm(dn) {
+		return dn, nil
+	}
+	if conn.options.OpenLDAP {
+		return "", fmt.Errorf("%w: extended dn %q is supported only by AD", ErrInvalidInput, dn)
+	}
+
+	entry, err := conn.readEntry(ctx, dn, []string{"1.1"})
+	if err != nil {
+		return "", err
+	}
+
+	return entry.DN, nil
+}
+
+////////////////////////////////////////////// Extended DN control
+
+// GetExtendedDN Read dn of object with its guid and sid by AD Extended DN control (dn can be in "<GUID=...>" form)
+func (conn *LdapConn) GetExtendedDN(ctx context.Context, dn string) (ExtendedDN, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filterAny,
+		[]string{"1.1"},
+		[]ldap.Control{NewControlExtendedDN(true)},
+	)
+
+	searchResult, err := conn.search(ctx, searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(searchResult.Entries) == 0) {
+		return ExtendedDN{}, fmt.Errorf("%w: %s", ErrNotFound, dn)
+	}
+	if err != nil {
+		return ExtendedDN{}, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	return ParseExtendedDN(searchResult.Entries[0].DN)
+}
+
+// ControlExtendedDN AD Extended DN control (1.2.840.113556.1.4.529), found entries dns are returned
+// as "<GUID=...>;<SID=...>;dn" with it
+type ControlExtendedDN struct {
+	Criticality  bool
+	StringFormat bool // guid and sid as strings (hex of binary values by default)
+}
+
+// NewControlExtendedDN Create Extended DN control (guid and sid in string format if stringFormat)
+func NewControlExtendedDN(stringFormat bool) *ControlExtendedDN {
+	return &ControlExtendedDN{StringFormat: stringFormat}
+}
+
+// GetControlType Control OID
+func (c *ControlExtendedDN) GetControlType() string {
+	return ldap.ControlTypeMicrosoftExtendedDN
+}
+
+// Encode Control ber packet
+func (c *ControlExtendedDN) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString,
+		ldap.ControlTypeMicrosoftExtendedDN, "Control Type (Extended DN)"))
+	if c.Criticality {
+		packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.Criticality, "Criticality"))
+	}
+
+	var flag int64
+	if c.StringFormat {
+		flag = 1
+	}
+	value := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value (Extended DN)")
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "ExtendedDNRequestValue")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, flag, "Flag"))
+	value.AppendChild(seq)
+	packet.AppendChild(value)
+
+	return packet
+}
+
+// String Control description
+func (c *ControlExtendedDN) String() string {
+	return fmt.Sprintf("Control Type: Extended DN (%q) Criticality: %t StringFormat: %t",
+		ldap.ControlTypeMicrosoftExtendedDN, c.Criticality, c.StringFormat)
+}
+
+// ExtendedDN Parsed dn returned with Extended DN control
+type ExtendedDN struct {
+	DN   string `json:"distinguishedName"`
+	GUID string `json:"objectGUID,omitempty"` // guid string form
+	SID  string `json:"objectSid,omitempty"`  // sid string form (empty for objects without sid, e.g. ou)
+}
+
+// ParseExtendedDN Parse "<GUID=...>;<SID=...>;dn" (guid and sid in hex or string format, plain dns are allowed)
+func ParseExtendedDN(dn string) (res ExtendedDN, err error) {
+	rest := strings.TrimSpace(dn)
+	for strings.HasPrefix(rest, "<") {
+		end := strings.Index(rest, ">")
+		if end < 0 {
+			return ExtendedDN{}, fmt.Errorf("bad extended dn %q", dn)
+		}
+		name, value, ok := strings.Cut(rest[1:end], "=")
+		if !ok {
+			return ExtendedDN{}, fmt.Errorf("bad extended dn %q", dn)
+		}
+
+		rest = strings.TrimPrefix(rest[end+1:], ";")
+
+		switch strings.ToUpper(name) {
+		case "GUID":
+			guid, err := parseExtendedGUID(value)
+			if err != nil {
+				return ExtendedDN{}, err
+			}
+			res.GUID = guid.String()
+		case "SID":
+			sid, err := parseExtendedSID(value)
+			if err != nil {
+				return ExtendedDN{}, err
+			}
+			res.SID = sid.String()
+		}
+	}
+	res.DN = rest
+
+	return res, nil
+}
+
+// parseExtendedGUID - parse guid of extended dn (hex of binary value or string form)
+func parseExtendedGUID(value string) (codec.GUID, error) {
+	if strings.Contains(value, "-") {
+		return codec.ParseGUID(value)
+	}
+
+	raw, err := hex.DecodeString(value)
+	if err != nil {
+		return codec.GUID{}, fmt.Errorf("bad extended dn guid %q: %s", value, err.Error())
+	}
+	return codec.DecodeGUID(raw)
+}
+
+// parseExtendedSID - parse sid of extended dn (hex of binary value or string form)
+func parseExtendedSID(value string) (codec.SID, error) {
+	if strings.HasPrefix(strings.ToUpper(value), "S-") {
+		return codec.ParseSID(value)
+	}
+
+	raw, err := hex.DecodeString(value)
+	if err != nil {
+		return codec.SID{}, fmt.Errorf("bad extended dn sid %q: %s", value, err.Error())
+	}
+	return codec.DecodeSID(raw)
+}
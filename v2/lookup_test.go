@@ -0,0 +1,148 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtendedDN(t *testing.T) {
+	tests := []struct {
+		name     string
+		dn       string
+		expected ExtendedDN
+		mustFail bool
+	}{
+		{
+			name: "string format",
+			dn:   "<GUID=33221100-5544-7766-8899-aabbccddeeff>;<SID=S-1-5-21-1004336348-1177238915-682003330-1105>;CN=Ivan,DC=test,DC=ru",
+			expected: ExtendedDN{
+				DN:   "CN=Ivan,DC=test,DC=ru",
+				GUID: "33221100-5544-7766-8899-aabbccddeeff",
+				SID:  "S-1-5-21-1004336348-1177238915-682003330-1105",
+			},
+		},
+		{
+			name: "hex format",
+			dn:   "<GUID=00112233445566778899aabbccddeeff>;<SID=010500000000000515000000dcf4dc3b833d2b46828ba62851040000>;CN=Ivan,DC=test,DC=ru",
+			expected: ExtendedDN{
+				DN:   "CN=Ivan,DC=test,DC=ru",
+				GUID: "33221100-5544-7766-8899-aabbccddeeff",
+				SID:  "S-1-5-21-1004336348-1177238915-682003330-1105",
+			},
+		},
+		{
+			name:     "ou without sid",
+			dn:       "<GUID=33221100-5544-7766-8899-aabbccddeeff>;OU=Sales,DC=test,DC=ru",
+			expected: ExtendedDN{DN: "OU=Sales,DC=test,DC=ru", GUID: "33221100-5544-7766-8899-aabbccddeeff"},
+		},
+		{
+			name:     "plain dn",
+			dn:       "OU=Sales,DC=test,DC=ru",
+			expected: ExtendedDN{DN: "OU=Sales,DC=test,DC=ru"},
+		},
+		{
+			name:     "bad guid",
+			dn:       "<GUID=zz>;OU=Sales,DC=test,DC=ru",
+			mustFail: true,
+		},
+		{
+			name:     "not closed",
+			dn:       "<GUID=33221100-5544-7766-8899-aabbccddeeff;OU=Sales,DC=test,DC=ru",
+			mustFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseExtendedDN(tt.dn)
+			if tt.mustFail {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, res)
+			}
+		})
+	}
+}
+
+func TestExtendedBaseDN(t *testing.T) {
+	base, err := GUIDBaseDN("{33221100-5544-7766-8899-AABBCCDDEEFF}")
+	require.NoError(t, err)
+	require.Equal(t, "<GUID=33221100-5544-7766-8899-aabbccddeeff>", base)
+	require.True(t, isExtendedDNForm(base))
+
+	base, err = SIDBaseDN("S-1-5-32-544")
+	require.NoError(t, err)
+	require.Equal(t, "<SID=S-1-5-32-544>", base)
+
+	_, err = GUIDBaseDN("not a guid")
+	require.Error(t, err)
+	_, err = SIDBaseDN("not a sid")
+	require.Error(t, err)
+
+	require.False(t, isExtendedDNForm("CN=Ivan,DC=test,DC=ru"))
+}
+
+func TestResolveDN(t *testing.T) {
+	ctx := context.Background()
+	guidDN := "<GUID=33221100-5544-7766-8899-aabbccddeeff>"
+
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		if describeRequest(req.Op) == "search "+guidDN+" (objectClass=*) [1.1]" {
+			return []testMessage{entryMessage("CN=Ivan,DC=test,DC=ru", nil), searchDone(ldap.LDAPResultSuccess)}
+		}
+		return []testMessage{searchDone(ldap.LDAPResultNoSuchObject)}
+	})
+
+	dn, err := conn.resolveDN(ctx, "CN=Ivan,DC=test,DC=ru")
+	require.NoError(t, err)
+	require.Equal(t, "CN=Ivan,DC=test,DC=ru", dn)
+	require.Empty(t, srv.Requests())
+
+	dn, err = conn.resolveDN(ctx, guidDN)
+	require.NoError(t, err)
+	require.Equal(t, "CN=Ivan,DC=test,DC=ru", dn)
+
+	_, err = conn.resolveDN(ctx, "<SID=S-1-5-21-1-2-3-1104>")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	openLDAP := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+	_, err = openLDAP.resolveDN(ctx, guidDN)
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	// every dn taking method resolves extended forms
+	_, err = openLDAP.GetUserInfo("ivan", guidDN)
+	require.ErrorIs(t, err, ErrInvalidInput)
+	_, err = openLDAP.GetGroupUsers(guidDN)
+	require.ErrorIs(t, err, ErrInvalidInput)
+	_, err = openLDAP.GetByGUID(ctx, guidDN, "33221100-5544-7766-8899-aabbccddeeff")
+	require.ErrorIs(t, err, ErrInvalidInput)
+	_, err = openLDAP.ExportLDIF(ctx, guidDN, "", nil, io.Discard)
+	require.ErrorIs(t, err, ErrInvalidInput)
+	require.ErrorIs(t, openLDAP.DeleteEntry(ctx, guidDN, false), ErrInvalidInput)
+	require.ErrorIs(t, openLDAP.SetAccountExpiry(ctx, guidDN, time.Time{}), ErrInvalidInput)
+	_, err = openLDAP.CreateOU(ctx, guidDN, "Sales", "")
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	// extended forms can't be parsed as dn
+	_, err = parseWriteDN(guidDN)
+	require.ErrorIs(t, err, ErrInvalidInput)
+	require.NoError(t, validateDN(guidDN))
+}
+
+func TestControlExtendedDN(t *testing.T) {
+	packet := NewControlExtendedDN(true).Encode()
+	require.Len(t, packet.Children, 2)
+	require.Equal(t, ldap.ControlTypeMicrosoftExtendedDN, packet.Children[0].Value)
+
+	value := ber.DecodePacket(packet.Children[1].Data.Bytes())
+	require.Len(t, value.Children, 1)
+	require.Equal(t, int64(1), value.Children[0].Value)
+}
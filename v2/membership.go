@@ -0,0 +1,519 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/NGRsoftlab/ngr-ldapper/v2/codec"
+)
+
+////////////////////////////////////////////// User groups
+
+// GetUserGroups Get groups of user: direct ones (memberOf), all ones with nested (transitive closure) and primary one
+func (conn *LdapConn) GetUserGroups(ctx context.Context, userDN string, opts ...UserGroupsOptions) (res UserGroups, err error) {
+	var groupsOpts UserGroupsOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		groupsOpts = opts[0]
+	}
+
+	userDN, err = conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return res, err
+	}
+	baseDn, err := conn.resolveDN(ctx, groupsOpts.BaseDN)
+	if err != nil {
+		return res, err
+	}
+	if baseDn == "" {
+		baseDn, err = DomainDN(userDN)
+		if err != nil {
+			return res, err
+		}
+		if baseDn == "" {
+			return res, fmt.Errorf("no groups search base for user %q", userDN)
+		}
+	}
+
+	if conn.options.OpenLDAP {
+		res, err = conn.getUserGroupsOpenLDAP(ctx, userDN, baseDn, groupsOpts)
+	} else {
+		res, err = conn.getUserGroupsAD(ctx, userDN, baseDn, groupsOpts)
+	}
+	if err != nil {
+		return UserGroups{}, err
+	}
+
+	res.All = addGroup(res.All, res.Primary)
+
+	return res, nil
+}
+
+// getUserGroupsAD - get user groups from AD
+func (conn *LdapConn) getUserGroupsAD(ctx context.Context, userDN, baseDn string,
+	opts UserGroupsOptions) (res UserGroups, err error) {
+
+	user, err := conn.readEntry(ctx, userDN, ADUserGroupsAttrs)
+	if err != nil {
+		return res, err
+	}
+
+	res.Direct, err = conn.getGroupsByDN(ctx, user.GetAttributeValues("memberOf"))
+	if err != nil {
+		return res, err
+	}
+
+	res.Primary, err = conn.getPrimaryGroupAD(ctx, baseDn, user)
+	if err != nil {
+		return res, err
+	}
+
+	switch {
+	case opts.SkipNested:
+		res.All = append(make([]GroupInfo, 0, len(res.Direct)), res.Direct...)
+	case opts.Method == NestedGroupsTokenGroups:
+		res.All, err = conn.getTokenGroups(ctx, userDN, baseDn)
+	case opts.Method == NestedGroupsClientSide:
+		res.All, err = conn.getNestedGroups(ctx, baseDn, res.Direct, opts.MaxDepth)
+	default:
+		res.All, err = conn.findGroups(ctx, baseDn, fmt.Sprintf(filterGroupsInChainAD, ldap.EscapeFilter(userDN)))
+	}
+
+	return res, err
+}
+
+// getUserGroupsOpenLDAP - get user groups from openLdap (groupOfNames, groupOfUniqueNames, posixGroup)
+func (conn *LdapConn) getUserGroupsOpenLDAP(ctx context.Context, userDN, baseDn string,
+	opts UserGroupsOptions) (res UserGroups, err error) {
+
+	if opts.Method == NestedGroupsInChain || opts.Method == NestedGroupsTokenGroups {
+		return res, fmt.Errorf("nested groups method %d is supported only by AD", opts.Method)
+	}
+
+	user, err := conn.readEntry(ctx, userDN, openLDAPUserGroupsAttrs)
+	if err != nil {
+		return res, err
+	}
+
+	filter := fmt.Sprintf(filterGroupsOfMemberOpenLDAP, ldap.EscapeFilter(userDN))
+	if uid := user.GetAttributeValue("uid"); uid != "" {
+		filter = "(|" + filter + fmt.Sprintf(filterPosixGroupsOfMember, ldap.EscapeFilter(uid)) + ")"
+	}
+
+	res.Direct, err = conn.findGroups(ctx, baseDn, filter)
+	if err != nil {
+		return res, err
+	}
+
+	if gid := user.GetAttributeValue("gidNumber"); gid != "" {
+		primary, err := conn.findGroups(ctx, baseDn, fmt.Sprintf(filterPosixGroupByGID, ldap.EscapeFilter(gid)))
+		if err != nil {
+			return res, err
+		}
+		if len(primary) > 0 {
+			res.Primary = &primary[0]
+		}
+	}
+
+	if opts.SkipNested {
+		res.All = append(make([]GroupInfo, 0, len(res.Direct)), res.Direct...)
+		return res, nil
+	}
+
+	res.All, err = conn.getNestedGroups(ctx, baseDn, res.Direct, opts.MaxDepth)
+
+	return res, err
+}
+
+// getPrimaryGroupAD - get AD primary group by primaryGroupID (RID) and user objectSid (domain part)
+func (conn *LdapConn) getPrimaryGroupAD(ctx context.Context, baseDn string, user *ldap.Entry) (*GroupInfo, error) {
+	rid, err := strconv.ParseUint(user.GetAttributeValue("primaryGroupID"), 10, 32)
+	if err != nil {
+		// no primary group
+		return nil, nil
+	}
+
+	sid, err := codec.DecodeSID(user.GetRawAttributeValue("objectSid"))
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := conn.findGroups(ctx, baseDn, fmt.Sprintf(filterBySID, sid.WithRID(uint32(rid)).FilterValue()))
+	if err != nil || len(groups) == 0 {
+		return nil, err
+	}
+
+	return &groups[0], nil
+}
+
+// getTokenGroups - get all AD user groups by tokenGroups (sids of all groups, computed by AD)
+func (conn *LdapConn) getTokenGroups(ctx context.Context, userDN, baseDn string) ([]GroupInfo, error) {
+	// tokenGroups can be read only with base scope search
+	user, err := conn.readEntry(ctx, userDN, []string{"tokenGroups"})
+	if err != nil {
+		return nil, err
+	}
+
+	sids := user.GetRawAttributeValues("tokenGroups")
+	res := make([]GroupInfo, 0, len(sids))
+	for len(sids) > 0 {
+		chunk := sids[:min(len(sids), filterChunkSize)]
+		sids = sids[len(chunk):]
+
+		var filter strings.Builder
+		filter.WriteString("(|")
+		for _, sid := range chunk {
+			filter.WriteString(fmt.Sprintf(filterBySID, codec.EscapeFilterBytes(sid)))
+		}
+		filter.WriteString(")")
+
+		groups, err := conn.findGroups(ctx, baseDn, filter.String())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, groups...)
+	}
+	sortGroups(res)
+
+	return res, nil
+}
+
+// getNestedGroups - resolve nested groups of direct groups group by group (with cycle detection)
+func (conn *LdapConn) getNestedGroups(ctx context.Context, baseDn string,
+	direct []GroupInfo, maxDepth int) ([]GroupInfo, error) {
+
+	var filterPattern string
+	if conn.options.OpenLDAP {
+		filterPattern = filterGroupsOfMemberOpenLDAP
+	} else {
+		filterPattern = filterGroupsOfMemberAD
+	}
+
+	res := make([]GroupInfo, 0, len(direct))
+	visited := make(map[string]bool)
+	frontier := direct
+	for depth := 1; len(frontier) > 0; depth++ {
+		next := make([]GroupInfo, 0)
+		for _, g := range frontier {
+			key := dnKey(g.DName)
+			if visited[key] {
+				// already found group or membership cycle
+				continue
+			}
+			visited[key] = true
+			res = append(res, g)
+
+			if maxDepth > 0 && depth >= maxDepth {
+				continue
+			}
+
+			parents, err := conn.findGroups(ctx, baseDn, fmt.Sprintf(filterPattern, ldap.EscapeFilter(g.DName)))
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, parents...)
+		}
+		frontier = next
+	}
+	sortGroups(res)
+
+	return res, nil
+}
+
+// getGroupsByDN - read groups by their dns (not found groups are skipped)
+func (conn *LdapConn) getGroupsByDN(ctx context.Context, dns []string) ([]GroupInfo, error) {
+	var attributes = make([]string, 0)
+	if conn.options.OpenLDAP {
+		attributes = openLDAPGroupAttrs
+	} else {
+		attributes = ADGroupAttrs
+	}
+
+	res := make([]GroupInfo, 0, len(dns))
+	for _, dn := range dns {
+		entry, err := conn.readEntry(ctx, dn, attributes)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, conn.groupInfoFromEntry(entry))
+	}
+	sortGroups(res)
+
+	return res, nil
+}
+
+// findGroups - search groups matching filter in whole baseDn subtree (with paging)
+func (conn *LdapConn) findGroups(ctx context.Context, baseDn, filter string) ([]GroupInfo, error) {
+	var attributes = make([]string, 0)
+	if conn.options.OpenLDAP {
+		attributes = openLDAPGroupAttrs
+	} else {
+		attributes = ADGroupAttrs
+	}
+
+	entries, err := conn.findEntries(ctx, baseDn, filter, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]GroupInfo, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, conn.groupInfoFromEntry(entry))
+	}
+	sortGroups(res)
+
+	return res, nil
+}
+
+////////////////////////////////////////////// Group members
+
+// GetGroupMembers Get members of group object (AD group, groupOfNames, groupOfUniqueNames, posixGroup).
+// Big AD groups are read by ranges (member;range=...), nested groups are expanded if recursive
+// (with cycle detection), else they are returned as members of MemberKindGroup kind
+func (conn *LdapConn) GetGroupMembers(ctx context.Context, groupDN string, recursive bool) ([]UserShortInfo, error) {
+	res := make([]UserShortInfo, 0)
+
+	groupDN, err := conn.resolveDN(ctx, groupDN)
+	if err != nil {
+		return nil, err
+	}
+
+	// members and groups already processed
+	seen := map[string]bool{dnKey(groupDN): true}
+	queue := []string{groupDN}
+	for len(queue) > 0 {
+		dn := queue[0]
+		queue = queue[1:]
+
+		members, err := conn.getDirectMembers(ctx, dn)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range members {
+			key := dnKey(m.DName)
+			if seen[key] {
+				// already found member or membership cycle
+				continue
+			}
+			seen[key] = true
+
+			if recursive && m.Kind == MemberKindGroup {
+				queue = append(queue, m.DName)
+				continue
+			}
+			res = append(res, m)
+		}
+	}
+	sortUsers(res)
+
+	return res, nil
+}
+
+// getDirectMembers - get direct members of group (not found members are skipped)
+func (conn *LdapConn) getDirectMembers(ctx context.Context, groupDN string) ([]UserShortInfo, error) {
+	var dns, uids []string
+	var attributes []string
+
+	if conn.options.OpenLDAP {
+		group, err := conn.readEntry(ctx, groupDN, []string{"member", "uniqueMember", "memberUid"})
+		if err != nil {
+			return nil, err
+		}
+
+		dns = group.GetAttributeValues("member")
+		for _, v := range group.GetAttributeValues("uniqueMember") {
+			dns = append(dns, uniqueMemberDN(v))
+		}
+		uids = group.GetAttributeValues("memberUid")
+		attributes = openLDAPMemberAttrs
+	} else {
+		var err error
+		dns, err = conn.getRangedValues(ctx, groupDN, "member")
+		if err != nil {
+			return nil, err
+		}
+		attributes = ADMemberAttrs
+	}
+
+	res := make([]UserShortInfo, 0, len(dns)+len(uids))
+	for _, dn := range dns {
+		entry, err := conn.readEntry(ctx, dn, attributes)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, conn.memberInfoFromEntry(entry))
+	}
+
+	if len(uids) > 0 {
+		baseDn, err := DomainDN(groupDN)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, uid := range uids {
+			users, err := conn.findEntries(ctx, baseDn, fmt.Sprintf(filterPosixAccountByUID, ldap.EscapeFilter(uid)), attributes)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range users {
+				res = append(res, conn.memberInfoFromEntry(entry))
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// getRangedValues - read all values of multi-valued attribute with AD range retrieval (attr;range=0-*, etc.)
+func (conn *LdapConn) getRangedValues(ctx context.Context, dn, attribute string) ([]string, error) {
+	res := make([]string, 0)
+	rangePrefix := strings.ToLower(attribute) + ";range="
+
+	start := 0
+	for {
+		entry, err := conn.readEntry(ctx, dn, []string{fmt.Sprintf("%s;range=%d-*", attribute, start)})
+		if err != nil {
+			return nil, err
+		}
+
+		next := -1
+		for _, attr := range entry.Attributes {
+			name := strings.ToLower(attr.Name)
+			if name == strings.ToLower(attribute) {
+				// all values are returned without range
+				return append(res, attr.Values...), nil
+			}
+			if !strings.HasPrefix(name, rangePrefix) {
+				continue
+			}
+
+			res = append(res, attr.Values...)
+
+			// returned range: <start>-<end> or <start>-* for the last one
+			_, end, _ := strings.Cut(name[len(rangePrefix):], "-")
+			if end == "*" {
+				return res, nil
+			}
+			last, err := strconv.Atoi(end)
+			if err != nil {
+				return nil, fmt.Errorf("bad range attribute %q", attr.Name)
+			}
+			next = last + 1
+		}
+
+		if next <= start {
+			// no ranged attribute (no values)
+			return res, nil
+		}
+		start = next
+	}
+}
+
+// findEntries - search entries matching filter in whole baseDn subtree (with paging)
+func (conn *LdapConn) findEntries(ctx context.Context, baseDn, filter string, attributes []string) ([]*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		baseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	res := make([]*ldap.Entry, 0)
+	err := conn.searchPaged(ctx, searchRequest, func(entry *ldap.Entry) error {
+		res = append(res, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	return res, nil
+}
+
+// memberInfoFromEntry - make member info (with member kind) from group member entry
+func (conn *LdapConn) memberInfoFromEntry(entry *ldap.Entry) UserShortInfo {
+	inf := conn.userShortInfoFromEntry(entry)
+	inf.Kind = memberKindOf(entry)
+
+	return inf
+}
+
+// memberKindOf - get member kind by entry objectClass
+func memberKindOf(entry *ldap.Entry) MemberKind {
+	classes := make(map[string]bool)
+	for _, c := range entry.GetAttributeValues("objectClass") {
+		classes[strings.ToLower(c)] = true
+	}
+
+	switch {
+	case classes["foreignsecurityprincipal"]:
+		return MemberKindForeign
+	case classes["contact"]:
+		return MemberKindContact
+	case classes["computer"]:
+		return MemberKindComputer
+	case classes["group"], classes["groupofnames"], classes["groupofuniquenames"], classes["posixgroup"]:
+		return MemberKindGroup
+	default:
+		return MemberKindUser
+	}
+}
+
+// sortUsers - sort users by name (and dn for equal names)
+func sortUsers(users []UserShortInfo) {
+	sort.SliceStable(users, func(i, j int) bool {
+		return lessUser(users[i], users[j])
+	})
+}
+
+// lessUser - users order by name (and dn for equal names)
+func lessUser(a, b UserShortInfo) bool {
+	na, nb := strings.ToLower(a.Name), strings.ToLower(b.Name)
+	if na != nb {
+		return na < nb
+	}
+	return strings.ToLower(a.DName) < strings.ToLower(b.DName)
+}
+
+// dnKey - unique dn key (canonical dn, lowercased dn for bad dns)
+func dnKey(dn string) string {
+	key, err := CanonicalDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	return key
+}
+
+// addGroup - add group to groups list if it is not there yet (list stays sorted)
+func addGroup(groups []GroupInfo, g *GroupInfo) []GroupInfo {
+	if g == nil {
+		return groups
+	}
+
+	key := dnKey(g.DName)
+	for _, v := range groups {
+		if dnKey(v.DName) == key {
+			return groups
+		}
+	}
+
+	groups = append(groups, *g)
+	sortGroups(groups)
+
+	return groups
+}
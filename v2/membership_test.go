@@ -0,0 +1,47 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddGroup(t *testing.T) {
+	groups := []GroupInfo{
+		{Name: "Admins", DName: "CN=Admins,DC=test,DC=ru"},
+		{Name: "Users", DName: "CN=Users,DC=test,DC=ru"},
+	}
+
+	res := addGroup(groups, &GroupInfo{Name: "Users", DName: "cn=users, dc=test, dc=ru"})
+	require.Len(t, res, 2)
+
+	res = addGroup(res, &GroupInfo{Name: "Domain Users", DName: "CN=Domain Users,CN=Users,DC=test,DC=ru"})
+	require.Len(t, res, 3)
+	require.Equal(t, "Domain Users", res[1].Name)
+
+	require.Len(t, addGroup(res, nil), 3)
+}
+
+func TestMemberKindOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		classes  []string
+		expected MemberKind
+	}{
+		{name: "user", classes: []string{"top", "person", "organizationalPerson", "user"}, expected: MemberKindUser},
+		{name: "computer", classes: []string{"top", "person", "organizationalPerson", "user", "computer"}, expected: MemberKindComputer},
+		{name: "contact", classes: []string{"top", "person", "organizationalPerson", "contact"}, expected: MemberKindContact},
+		{name: "foreign", classes: []string{"top", "foreignSecurityPrincipal"}, expected: MemberKindForeign},
+		{name: "group", classes: []string{"top", "group"}, expected: MemberKindGroup},
+		{name: "openldap person", classes: []string{"inetOrgPerson", "posixAccount"}, expected: MemberKindUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := ldap.NewEntry("CN=test,DC=test,DC=ru", map[string][]string{"objectClass": tt.classes})
+			require.Equal(t, tt.expected, memberKindOf(entry))
+		})
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Org chart
+
+// GetManagerChain Get managers of user from direct manager up to the top one (max maxDepth managers, 0 - no limit).
+// Walking stops on manager cycles, self-managed and not found managers
+func (conn *LdapConn) GetManagerChain(ctx context.Context, userDN string, maxDepth int) ([]UserShortInfo, error) {
+	userDN, err := conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := conn.readEntry(ctx, userDN, []string{"manager"})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]UserShortInfo, 0)
+	seen := map[string]bool{dnKey(userDN): true}
+
+	managerDN := user.GetAttributeValue("manager")
+	for managerDN != "" && (maxDepth <= 0 || len(res) < maxDepth) {
+		key := dnKey(managerDN)
+		if seen[key] {
+			// manager cycle (or top manager is managed by himself)
+			break
+		}
+		seen[key] = true
+
+		manager, err := conn.readEntry(ctx, managerDN, conn.orgAttrs())
+		if errors.Is(err, ErrNotFound) {
+			// dangling manager link (deleted or out of read access)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, conn.userShortInfoFromEntry(manager))
+		managerDN = manager.GetAttributeValue("manager")
+	}
+
+	return res, nil
+}
+
+// GetDirectReports Get direct reports of user (AD directReports back-link, openLdap reverse manager search)
+func (conn *LdapConn) GetDirectReports(ctx context.Context, userDN string) ([]UserShortInfo, error) {
+	userDN, err := conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := conn.getDirectReports(ctx, userDN)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]UserShortInfo, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, conn.userShortInfoFromEntry(entry))
+	}
+	sortUsers(res)
+
+	return res, nil
+}
+
+// GetOrgTree Get org chart from root user down by direct reports (max maxDepth levels under root, 0 - no limit).
+// Users already in tree (report cycles) are skipped
+func (conn *LdapConn) GetOrgTree(ctx context.Context, rootDN string, maxDepth int) (res OrgNode, err error) {
+	rootDN, err = conn.resolveDN(ctx, rootDN)
+	if err != nil {
+		return OrgNode{}, err
+	}
+
+	root, err := conn.readEntry(ctx, rootDN, conn.orgAttrs())
+	if err != nil {
+		return OrgNode{}, err
+	}
+
+	res = OrgNode{UserShortInfo: conn.userShortInfoFromEntry(root)}
+	seen := map[string]bool{dnKey(root.DN): true}
+
+	err = conn.readOrgReports(ctx, &res, 1, maxDepth, seen)
+	if err != nil {
+		return OrgNode{}, err
+	}
+
+	return res, nil
+}
+
+// readOrgReports - read direct reports of node recursively (level - reports level, root reports are level 1)
+func (conn *LdapConn) readOrgReports(ctx context.Context, node *OrgNode, level, maxDepth int, seen map[string]bool) error {
+	node.Reports = make([]OrgNode, 0)
+
+	if maxDepth > 0 && level > maxDepth {
+		truncated, err := conn.hasDirectReports(ctx, node.DName)
+		node.Truncated = truncated
+		return err
+	}
+
+	entries, err := conn.getDirectReports(ctx, node.DName)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		key := dnKey(entry.DN)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		node.Reports = append(node.Reports, OrgNode{UserShortInfo: conn.userShortInfoFromEntry(entry)})
+	}
+	sortOrgNodes(node.Reports)
+
+	for i := range node.Reports {
+		err = conn.readOrgReports(ctx, &node.Reports[i], level+1, maxDepth, seen)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getDirectReports - get direct reports entries of user (not found reports are skipped)
+func (conn *LdapConn) getDirectReports(ctx context.Context, userDN string) ([]*ldap.Entry, error) {
+	if conn.options.OpenLDAP {
+		baseDn, err := DomainDN(userDN)
+		if err != nil {
+			return nil, err
+		}
+		if baseDn == "" {
+			return nil, fmt.Errorf("no reports search base for user %q", userDN)
+		}
+
+		return conn.findEntries(ctx, baseDn, fmt.Sprintf(filterByManager, ldap.EscapeFilter(userDN)), openLDAPOrgAttrs)
+	}
+
+	dns, err := conn.getRangedValues(ctx, userDN, "directReports")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*ldap.Entry, 0, len(dns))
+	for _, dn := range dns {
+		entry, err := conn.readEntry(ctx, dn, ADOrgAttrs)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, entry)
+	}
+
+	return res, nil
+}
+
+// hasDirectReports - check if user has direct reports without reading them
+func (conn *LdapConn) hasDirectReports(ctx context.Context, userDN string) (bool, error) {
+	if !conn.options.OpenLDAP {
+		entry, err := conn.readEntry(ctx, userDN, []string{"directReports"})
+		if err != nil {
+			return false, err
+		}
+		return hasAttrValues(entry, "directReports"), nil
+	}
+
+	baseDn, err := DomainDN(userDN)
+	if err != nil {
+		return false, err
+	}
+	if baseDn == "" {
+		return false, fmt.Errorf("no reports search base for user %q", userDN)
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false,
+		fmt.Sprintf(filterByManager, ldap.EscapeFilter(userDN)),
+		[]string{"1.1"},
+		nil,
+	)
+
+	searchResult, err := conn.search(ctx, searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return false, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	return searchResult != nil && len(searchResult.Entries) > 0, nil
+}
+
+// hasAttrValues - check if entry has values of attr (AD returns large attrs as "attr;range=0-1499")
+func hasAttrValues(entry *ldap.Entry, name string) bool {
+	for _, attr := range entry.Attributes {
+		attrName, _, _ := strings.Cut(attr.Name, ";")
+		if strings.EqualFold(attrName, name) && len(attr.Values) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// orgAttrs - user attributes for org chart
+func (conn *LdapConn) orgAttrs() []string {
+	if conn.options.OpenLDAP {
+		return openLDAPOrgAttrs
+	}
+	return ADOrgAttrs
+}
+
+// sortOrgNodes - sort org nodes by name (and dn for equal names)
+func sortOrgNodes(nodes []OrgNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return lessUser(nodes[i].UserShortInfo, nodes[j].UserShortInfo)
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortOrgNodes(t *testing.T) {
+	nodes := []OrgNode{
+		{UserShortInfo: UserShortInfo{Name: "petrov", DName: "CN=petrov,DC=test,DC=ru"}},
+		{UserShortInfo: UserShortInfo{Name: "Ivanov", DName: "CN=Ivanov,OU=B,DC=test,DC=ru"}},
+		{UserShortInfo: UserShortInfo{Name: "Ivanov", DName: "CN=Ivanov,OU=A,DC=test,DC=ru"}},
+	}
+
+	sortOrgNodes(nodes)
+	require.Equal(t, "CN=Ivanov,OU=A,DC=test,DC=ru", nodes[0].DName)
+	require.Equal(t, "CN=Ivanov,OU=B,DC=test,DC=ru", nodes[1].DName)
+	require.Equal(t, "petrov", nodes[2].Name)
+}
+
+func TestOrgNodeJSON(t *testing.T) {
+	node := OrgNode{
+		UserShortInfo: UserShortInfo{Name: "Boss", DName: "CN=Boss,DC=test,DC=ru"},
+		Reports: []OrgNode{
+			{UserShortInfo: UserShortInfo{Name: "Ivanov", DName: "CN=Ivanov,DC=test,DC=ru"}, Reports: []OrgNode{}, Truncated: true},
+		},
+	}
+
+	data, err := json.Marshal(node)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"name": "Boss", "login": "", "mail": "", "title": "", "department": "",
+		"distinguishedName": "CN=Boss,DC=test,DC=ru",
+		"reports": [{
+			"name": "Ivanov", "login": "", "mail": "", "title": "", "department": "",
+			"distinguishedName": "CN=Ivanov,DC=test,DC=ru",
+			"reports": [], "truncated": true
+		}]
+	}`, string(data))
+}
+
+func TestHasAttrValues(t *testing.T) {
+	entry := ldap.NewEntry("CN=Boss,DC=test,DC=ru", map[string][]string{
+		"directReports;range=0-1499": {"CN=Ivanov,DC=test,DC=ru"},
+		"manager":                    {},
+	})
+
+	require.True(t, hasAttrValues(entry, "directReports"))
+	require.True(t, hasAttrValues(entry, "DIRECTREPORTS"))
+	require.False(t, hasAttrValues(entry, "manager"))
+	require.False(t, hasAttrValues(entry, "mail"))
+}
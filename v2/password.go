@@ -0,0 +1,137 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Passwords
+
+// ChangePassword Change user password knowing the old one (self-service), TLS connection is required.
+// AD unicodePwd delete+add, openLdap Password Modify extended operation (RFC 3062)
+func (conn *LdapConn) ChangePassword(ctx context.Context, userDN, oldPassword, newPassword string) error {
+	if err := conn.checkPasswordRequest(userDN, newPassword); err != nil {
+		return err
+	}
+	if oldPassword == "" {
+		return fmt.Errorf("%w: empty old password", ErrInvalidInput)
+	}
+	userDN, err := conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if conn.options.OpenLDAP {
+		_, err = conn.Connection.PasswordModify(ldap.NewPasswordModifyRequest(userDN, oldPassword, newPassword))
+	} else {
+		modifyRequest := ldap.NewModifyRequest(userDN, nil)
+		modifyRequest.Delete("unicodePwd", []string{encodeADPassword(oldPassword)})
+		modifyRequest.Add("unicodePwd", []string{encodeADPassword(newPassword)})
+		err = conn.Connection.Modify(modifyRequest)
+	}
+
+	return conn.passwordError(userDN, err, true)
+}
+
+// ResetPassword Set new user password without the old one (admin reset), TLS connection is required.
+// User must change password at next logon if mustChangeAtNextLogon (AD pwdLastSet, openLdap ppolicy pwdReset)
+func (conn *LdapConn) ResetPassword(ctx context.Context, userDN, newPassword string, mustChangeAtNextLogon bool) error {
+	if err := conn.checkPasswordRequest(userDN, newPassword); err != nil {
+		return err
+	}
+	userDN, err := conn.resolveDN(ctx, userDN)
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if conn.options.OpenLDAP {
+		_, err := conn.Connection.PasswordModify(ldap.NewPasswordModifyRequest(userDN, "", newPassword))
+		if err != nil || !mustChangeAtNextLogon {
+			return conn.passwordError(userDN, err, false)
+		}
+
+		modifyRequest := ldap.NewModifyRequest(userDN, nil)
+		modifyRequest.Replace("pwdReset", []string{"TRUE"})
+
+		return conn.passwordError(userDN, conn.Connection.Modify(modifyRequest), false)
+	}
+
+	// pwdLastSet: 0 - must change at next logon, -1 - set to current time
+	pwdLastSet := "-1"
+	if mustChangeAtNextLogon {
+		pwdLastSet = "0"
+	}
+
+	modifyRequest := ldap.NewModifyRequest(userDN, nil)
+	modifyRequest.Replace("unicodePwd", []string{encodeADPassword(newPassword)})
+	modifyRequest.Replace("pwdLastSet", []string{pwdLastSet})
+
+	return conn.passwordError(userDN, conn.Connection.Modify(modifyRequest), false)
+}
+
+// checkPasswordRequest - check password operation params and connection security
+func (conn *LdapConn) checkPasswordRequest(userDN, newPassword string) error {
+	if err := validateDN(userDN); err != nil {
+		return err
+	}
+	if newPassword == "" {
+		return fmt.Errorf("%w: empty new password", ErrInvalidInput)
+	}
+	if !conn.useTLS {
+		return ErrInsecureConnection
+	}
+
+	return nil
+}
+
+// passwordError - map password operation error to typed errors (ErrWrongPassword, ErrPasswordPolicy, ErrNotFound)
+func (conn *LdapConn) passwordError(userDN string, err error, change bool) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject):
+		return fmt.Errorf("%w: %s", ErrNotFound, userDN)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials):
+		return fmt.Errorf("%w: %s", ErrWrongPassword, msg)
+	case conn.options.OpenLDAP && change && ldap.IsErrorWithCode(err, ldap.LDAPResultUnwillingToPerform):
+		// slapd: "unwilling to verify old password"
+		return fmt.Errorf("%w: %s", ErrWrongPassword, msg)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultConstraintViolation):
+		if !conn.options.OpenLDAP && strings.Contains(strings.ToUpper(msg), adErrWrongPassword) {
+			return fmt.Errorf("%w: %s", ErrWrongPassword, msg)
+		}
+		// AD 0000052D, openLdap ppolicy quality/min age/history checks
+		return fmt.Errorf("%w: %s", ErrPasswordPolicy, msg)
+	case !conn.options.OpenLDAP && strings.Contains(strings.ToUpper(msg), adErrPasswordPolicy):
+		return fmt.Errorf("%w: %s", ErrPasswordPolicy, msg)
+	default:
+		return fmt.Errorf("bad password modify: %s", msg)
+	}
+}
+
+// encodeADPassword - AD unicodePwd value (quoted password in UTF-16LE)
+func encodeADPassword(password string) string {
+	encoded := utf16.Encode([]rune("\"" + password + "\""))
+
+	b := make([]byte, 2*len(encoded))
+	for i, c := range encoded {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeADPassword(t *testing.T) {
+	require.Equal(t, "\"\x00P\x00w\x00!\x00\"\x00", encodeADPassword("Pw!"))
+	// non-ascii chars
+	require.Equal(t, "\"\x00\x1f\x04\"\x00", encodeADPassword("П"))
+}
+
+func TestPasswordError(t *testing.T) {
+	ad := &LdapConn{}
+	openLDAP := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+
+	tests := []struct {
+		name     string
+		conn     *LdapConn
+		err      error
+		change   bool
+		expected error
+	}{
+		{
+			name:     "ad wrong old password",
+			conn:     ad,
+			err:      ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("00000056: AtrErr: DSID-03190F80, #1")),
+			change:   true,
+			expected: ErrWrongPassword,
+		},
+		{
+			name:     "ad policy",
+			conn:     ad,
+			err:      ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("0000052D: Constraint violation")),
+			expected: ErrPasswordPolicy,
+		},
+		{
+			name:     "ad policy unwilling",
+			conn:     ad,
+			err:      ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("0000052D: SvcErr: DSID-031A12D2")),
+			expected: ErrPasswordPolicy,
+		},
+		{
+			name:     "openldap wrong old password",
+			conn:     openLDAP,
+			err:      ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("unwilling to verify old password")),
+			change:   true,
+			expected: ErrWrongPassword,
+		},
+		{
+			name:     "openldap quality",
+			conn:     openLDAP,
+			err:      ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("Password fails quality checking policy")),
+			expected: ErrPasswordPolicy,
+		},
+		{
+			name:     "no user",
+			conn:     openLDAP,
+			err:      ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object")),
+			expected: ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, tt.conn.passwordError("cn=user,dc=test,dc=ru", tt.err, tt.change), tt.expected)
+		})
+	}
+
+	require.NoError(t, ad.passwordError("cn=user,dc=test,dc=ru", nil, true))
+}
+
+func TestPasswordValidation(t *testing.T) {
+	ctx := context.Background()
+
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	require.ErrorIs(t, conn.ResetPassword(ctx, "cn=user,dc=test,dc=ru", "new", true), ErrInsecureConnection)
+	require.ErrorIs(t, conn.ChangePassword(ctx, "cn=user,dc=test,dc=ru", "old", "new"), ErrInsecureConnection)
+
+	conn.useTLS = true
+	require.ErrorIs(t, conn.ResetPassword(ctx, "bad dn", "new", false), ErrInvalidInput)
+	require.ErrorIs(t, conn.ResetPassword(ctx, "cn=user,dc=test,dc=ru", "", false), ErrInvalidInput)
+	require.ErrorIs(t, conn.ChangePassword(ctx, "cn=user,dc=test,dc=ru", "", "new"), ErrInvalidInput)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestPasswordRequests(t *testing.T) {
+	// Password Modify extended operation (RFC 3062)
+	const passwordModify = "extended 1.3.6.1.4.1.4203.1.11.1"
+	ctx := context.Background()
+	handler := func(req testRequest) []testMessage {
+		if describeRequest(req.Op) == "modify cn=old,dc=test,dc=ru: replace pwdReset [TRUE]" {
+			return []testMessage{resultMessage(ldap.ApplicationModifyResponse, ldap.LDAPResultNoSuchObject)}
+		}
+		return nil
+	}
+
+	ad, srv := newTestConn(t, LdapConnOptions{}, handler)
+	ad.useTLS = true
+	require.NoError(t, ad.ChangePassword(ctx, "cn=user,dc=test,dc=ru", "old", "new"))
+	require.NoError(t, ad.ResetPassword(ctx, "cn=user,dc=test,dc=ru", "new", true))
+	require.NoError(t, ad.ResetPassword(ctx, "cn=user,dc=test,dc=ru", "new", false))
+	require.Equal(t, []string{
+		"modify cn=user,dc=test,dc=ru: delete unicodePwd [" + encodeADPassword("old") + "]; " +
+			"add unicodePwd [" + encodeADPassword("new") + "]",
+		"modify cn=user,dc=test,dc=ru: replace unicodePwd [" + encodeADPassword("new") + "]; replace pwdLastSet [0]",
+		"modify cn=user,dc=test,dc=ru: replace unicodePwd [" + encodeADPassword("new") + "]; replace pwdLastSet [-1]",
+	}, srv.Requests())
+
+	openLDAP, srv := newTestConn(t, LdapConnOptions{OpenLDAP: true}, handler)
+	openLDAP.useTLS = true
+	require.NoError(t, openLDAP.ChangePassword(ctx, "cn=user,dc=test,dc=ru", "old", "new"))
+	require.NoError(t, openLDAP.ResetPassword(ctx, "cn=user,dc=test,dc=ru", "new", false))
+	require.NoError(t, openLDAP.ResetPassword(ctx, "cn=user,dc=test,dc=ru", "new", true))
+	require.ErrorIs(t, openLDAP.ResetPassword(ctx, "cn=old,dc=test,dc=ru", "new", true), ErrNotFound)
+	require.Equal(t, []string{
+		passwordModify,
+		passwordModify,
+		passwordModify,
+		"modify cn=user,dc=test,dc=ru: replace pwdReset [TRUE]",
+		passwordModify,
+		"modify cn=old,dc=test,dc=ru: replace pwdReset [TRUE]",
+	}, srv.Requests())
+}
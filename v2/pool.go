@@ -0,0 +1,109 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+////////////////////////////////////////////// ConnPool struct
+
+// ConnPool Pool of conns with the same params for concurrent operations (conns are opened lazily)
+type ConnPool struct {
+	conn  *LdapConn      // conn to take params from
+	idle  chan *LdapConn // opened free conns
+	slots chan struct{}  // one slot per opened conn
+
+	mu     sync.Mutex
+	opened []*LdapConn
+}
+
+// NewConnPool - create new pool with max size conns cloned from passed conn
+func NewConnPool(conn *LdapConn, size int) *ConnPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &ConnPool{
+		conn:   conn,
+		idle:   make(chan *LdapConn, size),
+		slots:  make(chan struct{}, size),
+		opened: make([]*LdapConn, 0, size),
+	}
+}
+
+// Get - get free conn from pool (new conn is opened if pool is not full, else waiting for free conn).
+// Broken free conns are dropped, their slots are used for new conns
+func (p *ConnPool) Get(ctx context.Context) (*LdapConn, error) {
+	for {
+		select {
+		case c := <-p.idle:
+			if p.alive(c) {
+				return c, nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case c := <-p.idle:
+			if p.alive(c) {
+				return c, nil
+			}
+		case p.slots <- struct{}{}:
+			c, err := p.conn.Clone()
+			if err != nil {
+				<-p.slots
+				return nil, err
+			}
+
+			p.mu.Lock()
+			p.opened = append(p.opened, c)
+			p.mu.Unlock()
+
+			return c, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// alive - check if free conn can be used, closing one (e.g. after network error) is dropped with its slot
+func (p *ConnPool) alive(c *LdapConn) bool {
+	if c.Connection == nil || !c.Connection.IsClosing() {
+		return true
+	}
+
+	p.mu.Lock()
+	opened := len(p.opened)
+	p.opened = slices.DeleteFunc(p.opened, func(o *LdapConn) bool { return o == c })
+	dropped := len(p.opened) < opened
+	p.mu.Unlock()
+
+	if dropped {
+		<-p.slots
+	}
+	return false
+}
+
+// Put - return conn taken by Get back to pool
+func (p *ConnPool) Put(c *LdapConn) {
+	p.idle <- c
+}
+
+// Size - max number of pool conns
+func (p *ConnPool) Size() int {
+	return cap(p.slots)
+}
+
+// Close - close all opened conns (pool must not be used after closing)
+func (p *ConnPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.opened {
+		c.Close()
+	}
+	p.opened = p.opened[:0]
+}
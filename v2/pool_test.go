@@ -0,0 +1,76 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnPool(t *testing.T) {
+	ctx := context.Background()
+
+	require.Equal(t, 1, NewConnPool(&LdapConn{}, 0).Size())
+
+	// free conns are reused, no new conns are opened for them
+	pool := NewConnPool(&LdapConn{}, 2)
+	free := &LdapConn{}
+	pool.Put(free)
+	c, err := pool.Get(ctx)
+	require.NoError(t, err)
+	require.Same(t, free, c)
+
+	// full pool waits for free conn until ctx is done
+	pool = NewConnPool(&LdapConn{}, 1)
+	pool.slots <- struct{}{}
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(waitCtx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	go pool.Put(free)
+	c, err = pool.Get(ctx)
+	require.NoError(t, err)
+	require.Same(t, free, c)
+}
+
+func TestConnPoolCloneError(t *testing.T) {
+	// nothing listens on port 1: clone fails, slot must be released for next Get
+	pool := NewConnPool(&LdapConn{host: "127.0.0.1", port: 1}, 1)
+	for range 2 {
+		_, err := pool.Get(context.Background())
+		require.Error(t, err)
+	}
+	require.Empty(t, pool.slots)
+	require.Empty(t, pool.opened)
+	pool.Close()
+}
+
+func TestConnPoolBrokenConn(t *testing.T) {
+	netConn, other := net.Pipe()
+	defer other.Close()
+	closed := ldap.NewConn(netConn, false)
+	closed.Start()
+	require.NoError(t, closed.Close())
+
+	// broken free conn is dropped with its slot, new conn is opened instead (nothing listens on port 1)
+	pool := NewConnPool(&LdapConn{host: "127.0.0.1", port: 1}, 1)
+	broken := &LdapConn{Connection: closed}
+	pool.slots <- struct{}{}
+	pool.opened = append(pool.opened, broken)
+	pool.Put(broken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := pool.Get(ctx)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, context.DeadlineExceeded)
+	require.Nil(t, c)
+	require.Empty(t, pool.opened)
+	require.Empty(t, pool.slots)
+	require.Empty(t, pool.idle)
+}
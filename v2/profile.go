@@ -0,0 +1,60 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// User attribute profile
+
+// userField - UserFullInfo string field mapped to AD and openLdap attrs
+type userField struct {
+	name     string // json name of field (used in UpdateUser fields mask)
+	ad       string
+	openLDAP string
+	readOnly bool // field can't be changed by UpdateUser (cn is changed by Rename)
+	value    func(u *UserFullInfo) *string
+}
+
+// userProfile - UserFullInfo fields mapped to attrs (for reading and writing)
+var userProfile = []userField{
+	{name: "cn", ad: "cn", openLDAP: "cn", readOnly: true, value: func(u *UserFullInfo) *string { return &u.CN }},
+	{name: "department", ad: "department", openLDAP: "departmentNumber", value: func(u *UserFullInfo) *string { return &u.Department }},
+	{name: "mobile", ad: "mobile", openLDAP: "mobile", value: func(u *UserFullInfo) *string { return &u.Mobile }},
+	{name: "mail", ad: "mail", openLDAP: "mail", value: func(u *UserFullInfo) *string { return &u.Mail }},
+	{name: "title", ad: "title", openLDAP: "title", value: func(u *UserFullInfo) *string { return &u.Title }},
+	{name: "thumbnailPhoto", ad: "thumbnailPhoto", openLDAP: "jpegPhoto", value: func(u *UserFullInfo) *string { return &u.Photo }},
+	{name: "company", ad: "company", openLDAP: "company", value: func(u *UserFullInfo) *string { return &u.Company }},
+	{name: "address", ad: "streetAddress", openLDAP: "streetAddress", value: func(u *UserFullInfo) *string { return &u.Address }},
+	{name: "city", ad: "l", openLDAP: "l", value: func(u *UserFullInfo) *string { return &u.City }},
+	{name: "index", ad: "postalCode", openLDAP: "postalCode", value: func(u *UserFullInfo) *string { return &u.Index }},
+	{name: "country", ad: "co", openLDAP: "co", value: func(u *UserFullInfo) *string { return &u.Country }},
+	{name: "room", ad: "physicalDeliveryOfficeName", openLDAP: "physicalDeliveryOfficeName", value: func(u *UserFullInfo) *string { return &u.Room }},
+	{name: "phone", ad: "telephoneNumber", openLDAP: "telephoneNumber", value: func(u *UserFullInfo) *string { return &u.Phone }},
+	{name: "manager", ad: "manager", openLDAP: "manager", value: func(u *UserFullInfo) *string { return &u.Manager }},
+}
+
+// userFieldByName - get profile field by json name
+func userFieldByName(name string) (userField, bool) {
+	for _, f := range userProfile {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return userField{}, false
+}
+
+// attr - get field attr for conn server type
+func (f userField) attr(openLDAP bool) string {
+	if openLDAP {
+		return f.openLDAP
+	}
+	return f.ad
+}
+
+// readUserProfile - fill UserFullInfo profile fields from entry
+func (conn *LdapConn) readUserProfile(entry *ldap.Entry, u *UserFullInfo) {
+	for _, f := range userProfile {
+		*f.value(u) = entry.GetAttributeValue(f.attr(conn.options.OpenLDAP))
+	}
+}
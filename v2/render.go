@@ -0,0 +1,224 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Struct tree rendering
+
+// WriteDOT Write struct tree (GetStruct result) as Graphviz DOT digraph
+func WriteDOT(w io.Writer, groups []GroupInfo, opts ...RenderOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph ADStruct {\n\trankdir=LR;\n\tnode [shape=box];\n")
+
+	walkRenderTree(newRenderTree(groups, getRenderOptions(opts)), func(n, parent *renderNode) {
+		attrs := []string{"label=" + strconv.Quote(n.label)}
+		switch {
+		case n.collapsed:
+			attrs = append(attrs, "style=dashed")
+		case n.group:
+			attrs = append(attrs, "shape=ellipse")
+		}
+		fmt.Fprintf(bw, "\t%s [%s];\n", n.id, strings.Join(attrs, ", "))
+		if parent != nil {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", parent.id, n.id)
+		}
+	})
+
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// WriteMermaid Write struct tree (GetStruct result) as Mermaid flowchart
+func WriteMermaid(w io.Writer, groups []GroupInfo, opts ...RenderOptions) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("flowchart LR\n")
+
+	walkRenderTree(newRenderTree(groups, getRenderOptions(opts)), func(n, parent *renderNode) {
+		label := `"` + escapeMermaid(n.label) + `"`
+		switch {
+		case n.collapsed:
+			fmt.Fprintf(bw, "\t%s[%s]\n\tstyle %s stroke-dasharray: 5 5\n", n.id, label, n.id)
+		case n.group:
+			fmt.Fprintf(bw, "\t%s(%s)\n", n.id, label)
+		default:
+			fmt.Fprintf(bw, "\t%s[%s]\n", n.id, label)
+		}
+		if parent != nil {
+			fmt.Fprintf(bw, "\t%s --> %s\n", parent.id, n.id)
+		}
+	})
+
+	return bw.Flush()
+}
+
+// WriteTree Write struct tree (GetStruct result) as tree(1) style indented text
+func WriteTree(w io.Writer, groups []GroupInfo, opts ...RenderOptions) error {
+	bw := bufio.NewWriter(w)
+	for _, root := range newRenderTree(groups, getRenderOptions(opts)) {
+		bw.WriteString(root.label + "\n")
+		writeTreeChildren(bw, root.children, "")
+	}
+
+	return bw.Flush()
+}
+
+// writeTreeChildren - write children lines with tree branches
+func writeTreeChildren(bw *bufio.Writer, children []renderNode, prefix string) {
+	for i, n := range children {
+		branch, indent := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		bw.WriteString(prefix + branch + n.label + "\n")
+		writeTreeChildren(bw, n.children, prefix+indent)
+	}
+}
+
+// CountUsers Count users placed directly in tree nodes (groups are skipped),
+// returns counts by node dn for RenderOptions.UserCounts
+func (conn *LdapConn) CountUsers(ctx context.Context, groups []GroupInfo) (map[string]int, error) {
+	res := make(map[string]int)
+	filter := conn.userFilter()
+
+	var count func(groups []GroupInfo) error
+	count = func(groups []GroupInfo) error {
+		for _, g := range groups {
+			if g.Kind.IsGroup() {
+				continue
+			}
+
+			searchRequest := ldap.NewSearchRequest(
+				g.DName,
+				ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+				filter,
+				[]string{"1.1"},
+				nil,
+			)
+
+			n := 0
+			err := conn.searchPaged(ctx, searchRequest, func(*ldap.Entry) error {
+				n++
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("bad search: %s", err.Error())
+			}
+			res[g.DName] = n
+
+			if err = count(g.Has); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := count(groups); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+////////////////////////////////////////////// Render tree
+
+// renderNode - tree node prepared for rendering
+type renderNode struct {
+	id        string // n1, n2, ... in walk order
+	label     string
+	group     bool
+	collapsed bool // "+N more" node in place of children deeper than RenderOptions.MaxDepth
+	children  []renderNode
+}
+
+func getRenderOptions(opts []RenderOptions) RenderOptions {
+	if len(opts) > 0 {
+		// set only 1st options object
+		return opts[0]
+	}
+	return RenderOptions{}
+}
+
+// newRenderTree - make render nodes from groups (labels with user counts, deep nodes collapsed)
+func newRenderTree(groups []GroupInfo, opts RenderOptions) []renderNode {
+	id := 0
+	nextID := func() string {
+		id++
+		return "n" + strconv.Itoa(id)
+	}
+
+	var build func(groups []GroupInfo, level int) []renderNode
+	build = func(groups []GroupInfo, level int) []renderNode {
+		res := make([]renderNode, 0, len(groups))
+		for _, g := range groups {
+			n := renderNode{id: nextID(), label: renderLabel(g, opts.UserCounts), group: g.Kind.IsGroup()}
+
+			if opts.MaxDepth > 0 && level >= opts.MaxDepth {
+				if more := countGroups(g.Has); more > 0 {
+					n.children = []renderNode{{id: nextID(), label: fmt.Sprintf("+%d more", more), collapsed: true}}
+				}
+			} else {
+				n.children = build(g.Has, level+1)
+			}
+			res = append(res, n)
+		}
+		return res
+	}
+
+	return build(groups, 1)
+}
+
+// walkRenderTree - call fn for every node (parents first), parent is nil for roots
+func walkRenderTree(nodes []renderNode, fn func(n, parent *renderNode)) {
+	var walk func(nodes []renderNode, parent *renderNode)
+	walk = func(nodes []renderNode, parent *renderNode) {
+		for i := range nodes {
+			fn(&nodes[i], parent)
+			walk(nodes[i].children, &nodes[i])
+		}
+	}
+	walk(nodes, nil)
+}
+
+// renderLabel - node label: name, users count and truncation mark
+func renderLabel(g GroupInfo, counts map[string]int) string {
+	label := g.Name
+	if label == "" {
+		label = g.DName
+	}
+
+	if n, ok := counts[g.DName]; ok {
+		if n == 1 {
+			label += " (1 user)"
+		} else {
+			label += fmt.Sprintf(" (%d users)", n)
+		}
+	}
+	if g.Truncated {
+		label += " …"
+	}
+
+	return label
+}
+
+// countGroups - count all groups in subtree
+func countGroups(groups []GroupInfo) int {
+	n := len(groups)
+	for _, g := range groups {
+		n += countGroups(g.Has)
+	}
+	return n
+}
+
+// escapeMermaid - escape label chars breaking mermaid quoted text
+func escapeMermaid(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(s)
+}
@@ -0,0 +1,78 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testRenderTree = []GroupInfo{
+	{Name: "Sales", DName: "OU=Sales,DC=test", Kind: GroupKindOU, Has: []GroupInfo{
+		{Name: "East", DName: "OU=East,OU=Sales,DC=test", Kind: GroupKindOU, Has: []GroupInfo{
+			{Name: "Moscow", DName: "OU=Moscow,OU=East,OU=Sales,DC=test", Kind: GroupKindOU, Has: []GroupInfo{
+				{Name: "Office", DName: "OU=Office,OU=Moscow,OU=East,OU=Sales,DC=test", Kind: GroupKindOU},
+			}},
+		}},
+		{Name: `Sales "VIP"`, DName: "CN=VIP,OU=Sales,DC=test", Kind: GroupKindSecurity},
+	}},
+	{Name: "IT", DName: "OU=IT,DC=test", Kind: GroupKindOU, Truncated: true},
+}
+
+func TestWriteTree(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteTree(&buf, testRenderTree, RenderOptions{
+		UserCounts: map[string]int{"OU=Sales,DC=test": 3, "OU=East,OU=Sales,DC=test": 1},
+	}))
+	require.Equal(t, `Sales (3 users)
+├── East (1 user)
+│   └── Moscow
+│       └── Office
+└── Sales "VIP"
+IT …
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteTree(&buf, testRenderTree, RenderOptions{MaxDepth: 2}))
+	require.Equal(t, `Sales
+├── East
+│   └── +2 more
+└── Sales "VIP"
+IT …
+`, buf.String())
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteDOT(&buf, testRenderTree, RenderOptions{MaxDepth: 2}))
+	require.Equal(t, `digraph ADStruct {
+	rankdir=LR;
+	node [shape=box];
+	n1 [label="Sales"];
+	n2 [label="East"];
+	n1 -> n2;
+	n3 [label="+2 more", style=dashed];
+	n2 -> n3;
+	n4 [label="Sales \"VIP\"", shape=ellipse];
+	n1 -> n4;
+	n5 [label="IT …"];
+}
+`, buf.String())
+}
+
+func TestWriteMermaid(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteMermaid(&buf, testRenderTree, RenderOptions{MaxDepth: 2}))
+	require.Equal(t, `flowchart LR
+	n1["Sales"]
+	n2["East"]
+	n1 --> n2
+	n3["+2 more"]
+	style n3 stroke-dasharray: 5 5
+	n2 --> n3
+	n4("Sales #quot;VIP#quot;")
+	n1 --> n4
+	n5["IT …"]
+`, buf.String())
+}
@@ -0,0 +1,89 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Search helpers
+
+// search - run search request with context (request is abandoned on context cancel)
+func (conn *LdapConn) search(ctx context.Context, searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	res := &ldap.SearchResult{
+		Entries:   make([]*ldap.Entry, 0),
+		Referrals: make([]string, 0),
+		Controls:  make([]ldap.Control, 0),
+	}
+
+	response := conn.Connection.SearchAsync(ctx, searchRequest, 0)
+	for response.Next() {
+		switch {
+		case response.Entry() != nil:
+			res.Entries = append(res.Entries, response.Entry())
+		case response.Referral() != "":
+			res.Referrals = append(res.Referrals, response.Referral())
+		default:
+			res.Controls = append(res.Controls, response.Controls()...)
+		}
+	}
+
+	if err := response.Err(); err != nil {
+		return res, err
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// searchPaged - run search request with paging control and context, fn is called for every found entry
+func (conn *LdapConn) searchPaged(ctx context.Context, searchRequest *ldap.SearchRequest,
+	fn func(entry *ldap.Entry) error) error {
+
+	paging := ldap.NewControlPaging(pagingSize)
+	searchRequest.Controls = append(searchRequest.Controls, paging)
+
+	for {
+		searchResult, err := conn.search(ctx, searchRequest)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range searchResult.Entries {
+			if err = fn(entry); err != nil {
+				return err
+			}
+		}
+
+		pagingResult, ok := ldap.FindControl(searchResult.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(pagingResult.Cookie) == 0 {
+			return nil
+		}
+		paging.SetCookie(pagingResult.Cookie)
+	}
+}
+
+// readEntry - read one entry by dn (ErrNotFound if there is no such entry)
+func (conn *LdapConn) readEntry(ctx context.Context, dn string, attributes []string) (*ldap.Entry, error) {
+	searchRequest := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		filterAny,
+		attributes,
+		nil,
+	)
+
+	searchResult, err := conn.search(ctx, searchRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) || (err == nil && len(searchResult.Entries) == 0) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, dn)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	return searchResult.Entries[0], nil
+}
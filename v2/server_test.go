@@ -0,0 +1,296 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Fake ldap server
+
+// testRequest - request got by fake server
+type testRequest struct {
+	Op       *ber.Packet
+	Controls []ldap.Control
+}
+
+// testMessage - response message of fake server
+type testMessage struct {
+	op       *ber.Packet
+	controls []ldap.Control
+}
+
+// testHandler - answers request, nil result means success response of request type
+type testHandler func(req testRequest) []testMessage
+
+// testServer - fake ldap server on the other side of conn pipe
+type testServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+// Requests - descriptions of got requests (see describeRequest)
+func (s *testServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// newTestConn - conn to fake server answering requests by handler
+func newTestConn(t *testing.T, options LdapConnOptions, handler testHandler) (*LdapConn, *testServer) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	srv := &testServer{}
+	go srv.serve(server, handler)
+
+	ldapConn := ldap.NewConn(client, false)
+	ldapConn.Start()
+	t.Cleanup(func() {
+		_ = ldapConn.Close()
+		_ = server.Close()
+	})
+
+	return &LdapConn{options: options, Connection: ldapConn}, srv
+}
+
+// serve - read requests and write responses till conn is closed
+func (s *testServer) serve(conn net.Conn, handler testHandler) {
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+
+		msgID := packet.Children[0].Value.(int64)
+		req := testRequest{Op: packet.Children[1]}
+		if req.Op.Tag == ldap.ApplicationUnbindRequest || req.Op.Tag == ldap.ApplicationAbandonRequest {
+			continue
+		}
+		if len(packet.Children) > 2 {
+			for _, child := range packet.Children[2].Children {
+				if control, err := ldap.DecodeControl(child); err == nil {
+					req.Controls = append(req.Controls, control)
+				}
+			}
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, describeRequest(req.Op))
+		s.mu.Unlock()
+
+		messages := handler(req)
+		if messages == nil {
+			messages = []testMessage{resultMessage(responseTag(req.Op.Tag), ldap.LDAPResultSuccess)}
+		}
+		for _, message := range messages {
+			if _, err = conn.Write(message.encode(msgID).Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// encode - ldap message with id
+func (m testMessage) encode(msgID int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "MessageID"))
+	packet.AppendChild(m.op)
+	if len(m.controls) > 0 {
+		controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, control := range m.controls {
+			controls.AppendChild(control.Encode())
+		}
+		packet.AppendChild(controls)
+	}
+
+	return packet
+}
+
+// responseTag - response tag for request tag
+func responseTag(tag ber.Tag) ber.Tag {
+	switch tag {
+	case ldap.ApplicationSearchRequest:
+		return ldap.ApplicationSearchResultDone
+	case ldap.ApplicationExtendedRequest:
+		return ldap.ApplicationExtendedResponse
+	default:
+		// modify, add, del, modify dn, compare: response goes right after request
+		return tag + 1
+	}
+}
+
+// resultMessage - ldap result of given tag and code
+func resultMessage(tag ber.Tag, code uint16, controls ...ldap.Control) testMessage {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+
+	return testMessage{op: op, controls: controls}
+}
+
+// searchDone - search result done message
+func searchDone(code uint16, controls ...ldap.Control) testMessage {
+	return resultMessage(ldap.ApplicationSearchResultDone, code, controls...)
+}
+
+// entryMessage - search result entry (attrs are sent in name order)
+func entryMessage(dn string, attrs map[string][]string, controls ...ldap.Control) testMessage {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, name := range names {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range attrs[name] {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attr.AppendChild(values)
+		list.AppendChild(attr)
+	}
+	op.AppendChild(list)
+
+	return testMessage{op: op, controls: controls}
+}
+
+// intermediateMessage - intermediate response with name and raw value
+func intermediateMessage(name string, value *ber.Packet) testMessage {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationIntermediateResponse, nil, "Intermediate Response")
+	op.AppendChild(ber.NewString(ber.ClassContext, ber.TypePrimitive, 0, name, "Response Name"))
+	data := ber.Encode(ber.ClassContext, ber.TypePrimitive, 1, nil, "Response Value")
+	data.Data.Write(value.Bytes())
+	op.AppendChild(data)
+
+	return testMessage{op: op}
+}
+
+// testControl - response control with raw value (go-ldap doesn't encode syncrepl controls)
+type testControl struct {
+	oid   string
+	value *ber.Packet
+}
+
+func (c testControl) GetControlType() string {
+	return c.oid
+}
+
+func (c testControl) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, c.oid, "Control Type"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString,
+		string(c.value.Bytes()), "Control Value"))
+
+	return packet
+}
+
+func (c testControl) String() string {
+	return c.oid
+}
+
+// pagingCookie - paging control cookie of request ("" for the first page)
+func pagingCookie(req testRequest) string {
+	paging, ok := ldap.FindControl(req.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+	if !ok {
+		return ""
+	}
+
+	return string(paging.Cookie)
+}
+
+// nextPage - paging control of response with cookie of next page
+func nextPage(cookie string) ldap.Control {
+	paging := ldap.NewControlPaging(0)
+	paging.SetCookie([]byte(cookie))
+
+	return paging
+}
+
+////////////////////////////////////////////// Requests description
+
+// describeRequest - short text form of request, e.g. "modify cn=user,dc=test: replace mail [a@test]"
+func describeRequest(op *ber.Packet) string {
+	switch op.Tag {
+	case ldap.ApplicationSearchRequest:
+		filter, err := ldap.DecompileFilter(op.Children[6])
+		if err != nil {
+			filter = "?"
+		}
+		var attrs []string
+		for _, attr := range op.Children[7].Children {
+			attrs = append(attrs, packetString(attr))
+		}
+		return fmt.Sprintf("search %s %s %v", packetString(op.Children[0]), filter, attrs)
+	case ldap.ApplicationModifyRequest:
+		var changes []string
+		for _, change := range op.Children[1].Children {
+			changes = append(changes, fmt.Sprintf("%s %s",
+				modifyOperations[change.Children[0].Value.(int64)], describeAttribute(change.Children[1])))
+		}
+		return fmt.Sprintf("modify %s: %s", packetString(op.Children[0]), strings.Join(changes, "; "))
+	case ldap.ApplicationAddRequest:
+		var attrs []string
+		for _, attr := range op.Children[1].Children {
+			attrs = append(attrs, describeAttribute(attr))
+		}
+		return fmt.Sprintf("add %s: %s", packetString(op.Children[0]), strings.Join(attrs, "; "))
+	case ldap.ApplicationDelRequest:
+		return "delete " + op.Data.String()
+	case ldap.ApplicationModifyDNRequest:
+		res := fmt.Sprintf("modify dn %s: %s", packetString(op.Children[0]), packetString(op.Children[1]))
+		if len(op.Children) > 3 {
+			res += " under " + op.Children[3].Data.String()
+		}
+		return res
+	case ldap.ApplicationExtendedRequest:
+		return "extended " + op.Children[0].Data.String()
+	default:
+		return fmt.Sprintf("request %d", op.Tag)
+	}
+}
+
+// modifyOperations - modify request operation names
+var modifyOperations = map[int64]string{
+	ldap.AddAttribute:       "add",
+	ldap.DeleteAttribute:    "delete",
+	ldap.ReplaceAttribute:   "replace",
+	ldap.IncrementAttribute: "increment",
+}
+
+// describeAttribute - "name [values]" of attribute packet
+func describeAttribute(attr *ber.Packet) string {
+	var values []string
+	for _, value := range attr.Children[1].Children {
+		values = append(values, packetString(value))
+	}
+
+	return fmt.Sprintf("%s %v", packetString(attr.Children[0]), values)
+}
+
+// packetString - string value of primitive packet
+func packetString(packet *ber.Packet) string {
+	if value, ok := packet.Value.(string); ok {
+		return value
+	}
+
+	return packet.Data.String()
+}
@@ -0,0 +1,423 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Tree options
+
+// getTreeOptions - get only 1st options object (default options if not passed)
+func getTreeOptions(opts []TreeOptions) TreeOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return TreeOptions{}
+}
+
+// depthAllowed - check if groups of passed tree level may be read
+func (o TreeOptions) depthAllowed(level int) bool {
+	switch {
+	case o.MaxDepth == UnlimitedDepth:
+		return true
+	case o.MaxDepth <= 0:
+		return level <= DepthOfLdapSearch
+	default:
+		return level <= o.MaxDepth
+	}
+}
+
+// compileDNPatterns - make case-insensitive regexps from dn patterns (* - any chars)
+func compileDNPatterns(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSpace(p)), `\*`, ".*")
+		res = append(res, regexp.MustCompile("(?i)^"+expr+"$"))
+	}
+	return res
+}
+
+// matchAny - check if dn matches one of patterns
+func matchAny(patterns []*regexp.Regexp, dn string) bool {
+	for _, p := range patterns {
+		if p.MatchString(dn) {
+			return true
+		}
+	}
+	return false
+}
+
+// kindsFilter - make search filter for passed node kinds (ou only if empty, unknown kinds are skipped)
+func kindsFilter(kinds []GroupKind) string {
+	var b strings.Builder
+	for _, k := range kinds {
+		b.WriteString(groupKindFilters[k])
+	}
+
+	if b.Len() == 0 {
+		return filterGroup
+	}
+
+	return "(|" + b.String() + ")"
+}
+
+// groupKindOf - get node kind by entry objectClass (and groupType for AD groups)
+func groupKindOf(entry *ldap.Entry) GroupKind {
+	classes := make(map[string]bool)
+	for _, c := range entry.GetAttributeValues("objectClass") {
+		classes[strings.ToLower(c)] = true
+	}
+
+	switch {
+	case classes["organizationalunit"]:
+		return GroupKindOU
+	case classes["group"]:
+		groupType, _ := strconv.ParseInt(entry.GetAttributeValue("groupType"), 10, 64)
+		if uint32(groupType)&groupTypeSecurity != 0 {
+			return GroupKindSecurity
+		}
+		return GroupKindDistribution
+	case classes["posixgroup"]:
+		return GroupKindPosix
+	case classes["groupofnames"], classes["groupofuniquenames"]:
+		return GroupKindGroupOfNames
+	case classes["domaindns"], classes["domain"]:
+		return GroupKindDomain
+	case classes["container"], classes["builtindomain"]:
+		return GroupKindContainer
+	default:
+		// filterGroup searches only ou
+		return GroupKindOU
+	}
+}
+
+////////////////////////////////////////////// Tree walker
+
+// treeFilter - applying TreeOptions limits to read tree levels
+type treeFilter struct {
+	opts    TreeOptions
+	search  string // ldap search filter for node kinds
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func newTreeFilter(opts TreeOptions) *treeFilter {
+	return &treeFilter{
+		opts:    opts,
+		search:  kindsFilter(opts.Kinds),
+		include: compileDNPatterns(opts.Include),
+		exclude: compileDNPatterns(opts.Exclude),
+	}
+}
+
+// level - drop excluded groups, sort and cut level by MaxChildren (returns true if level was cut)
+func (f *treeFilter) level(groups []GroupInfo) ([]GroupInfo, bool) {
+	res := make([]GroupInfo, 0, len(groups))
+	for _, g := range groups {
+		if matchAny(f.exclude, g.DName) {
+			continue
+		}
+		res = append(res, g)
+	}
+	sortGroups(res)
+
+	if f.opts.MaxChildren > 0 && len(res) > f.opts.MaxChildren {
+		return res[:f.opts.MaxChildren], true
+	}
+
+	return res, false
+}
+
+// prune - keep only groups matching Include patterns (and their parents)
+func (f *treeFilter) prune(groups []GroupInfo) []GroupInfo {
+	if len(f.include) == 0 {
+		return groups
+	}
+
+	res := make([]GroupInfo, 0)
+	for _, g := range groups {
+		g.Has = f.prune(g.Has)
+		if len(g.Has) > 0 || matchAny(f.include, g.DName) {
+			res = append(res, g)
+		}
+	}
+
+	return res
+}
+
+// sortGroups - sort groups by name (and dn for equal names)
+func sortGroups(groups []GroupInfo) {
+	sort.SliceStable(groups, func(i, j int) bool {
+		ni, nj := strings.ToLower(groups[i].Name), strings.ToLower(groups[j].Name)
+		if ni != nj {
+			return ni < nj
+		}
+		return strings.ToLower(groups[i].DName) < strings.ToLower(groups[j].DName)
+	})
+}
+
+////////////////////////////////////////////// Level by level tree reading
+
+// connSource - source of conns for tree reading (ConnPool or single conn)
+type connSource interface {
+	Get(ctx context.Context) (*LdapConn, error)
+	Put(c *LdapConn)
+}
+
+// singleConn - conn source with only one conn
+type singleConn struct {
+	conn *LdapConn
+}
+
+func (s singleConn) Get(ctx context.Context) (*LdapConn, error) {
+	return s.conn, ctx.Err()
+}
+
+func (s singleConn) Put(*LdapConn) {}
+
+// readTree - read groups tree level by level, subgroups of one level are read concurrently (one worker per conn).
+// The first error (conn, search or context one) stops reading and is returned
+func readTree(ctx context.Context, conns connSource, firstLevel []GroupInfo, filter *treeFilter) error {
+	workers := 1
+	if pool, ok := conns.(*ConnPool); ok {
+		workers = pool.Size()
+	}
+
+	frontier := make([]*GroupInfo, 0, len(firstLevel))
+	for i := range firstLevel {
+		frontier = append(frontier, &firstLevel[i])
+	}
+
+	for level := 2; len(frontier) > 0; level++ {
+		err := readTreeLevel(ctx, conns, workers, frontier, level, filter)
+		if err != nil {
+			return err
+		}
+
+		next := make([]*GroupInfo, 0)
+		for _, g := range frontier {
+			for i := range g.Has {
+				next = append(next, &g.Has[i])
+			}
+		}
+		frontier = next
+	}
+
+	return nil
+}
+
+// readTreeLevel - read children of all passed groups (children level number is passed)
+func readTreeLevel(ctx context.Context, conns connSource, workers int,
+	groups []*GroupInfo, level int, filter *treeFilter) error {
+
+	// workers are stopped by the first error
+	levelCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+
+	tasks := make(chan *GroupInfo)
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for g := range tasks {
+				if err := readGroupChildren(levelCtx, conns, g, level, filter); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for _, g := range groups {
+		if levelCtx.Err() != nil {
+			break
+		}
+		tasks <- g
+	}
+	close(tasks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// readGroupChildren - read children of one group (or only check if they exist on depth limit)
+func readGroupChildren(ctx context.Context, conns connSource, g *GroupInfo, level int, filter *treeFilter) error {
+	g.Has = make([]GroupInfo, 0)
+	if g.Kind.IsGroup() {
+		// groups have members, not children
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn, err := conns.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer conns.Put(conn)
+
+	if !filter.opts.depthAllowed(level) {
+		g.Truncated, err = conn.hasSubGroups(ctx, g.DName, filter.search)
+		return err
+	}
+
+	children, err := conn.getSubGroups(ctx, g.DName, ldap.ScopeSingleLevel, filter.search)
+	if err != nil {
+		return err
+	}
+	g.Has, g.Truncated = filter.level(children)
+	return nil
+}
+
+////////////////////////////////////////////// Subtree tree builder
+
+// treeNode - group with its children while assembling tree in memory
+type treeNode struct {
+	info     GroupInfo
+	children []*treeNode
+}
+
+// GetStructBySubtree Reading full AD structure with one paged subtree search (tree is assembled in memory).
+// Result is the same as GetStruct result, but it needs only one search for any tree size
+func (conn *LdapConn) GetStructBySubtree(baseDn string, opts ...TreeOptions) (res ADStruct, err error) {
+	treeOpts := getTreeOptions(opts)
+	if treeOpts.StartDN != "" {
+		baseDn = treeOpts.StartDN
+	}
+	baseDn, err = conn.resolveDN(context.Background(), baseDn)
+	if err != nil {
+		return ADStruct{}, err
+	}
+
+	filter := newTreeFilter(treeOpts)
+
+	groups, err := conn.getAllGroups(baseDn, filter.search)
+	if err != nil {
+		return ADStruct{}, err
+	}
+
+	res.AD, err = buildTree(baseDn, groups, filter)
+	if err != nil {
+		return ADStruct{}, err
+	}
+
+	return res, nil
+}
+
+// getAllGroups - read all groups matching search filter under baseDn with one paged subtree search
+func (conn *LdapConn) getAllGroups(baseDn, search string) (res []GroupInfo, err error) {
+	res = make([]GroupInfo, 0)
+
+	var attributes = make([]string, 0)
+	if conn.options.OpenLDAP {
+		attributes = openLDAPGroupAttrs
+	} else {
+		attributes = ADGroupAttrs
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDn,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		search,
+		attributes,
+		nil,
+	)
+
+	searchResult, err := conn.Connection.SearchWithPaging(searchRequest, pagingSize)
+	if err != nil {
+		return res, fmt.Errorf("bad search: %s", err.Error())
+	}
+
+	for _, entry := range searchResult.Entries {
+		inf := conn.groupInfoFromEntry(entry)
+		if inf.DName == "" {
+			inf.DName = entry.DN
+		}
+		res = append(res, inf)
+	}
+
+	return res, nil
+}
+
+// buildTree - assemble groups tree under baseDn by groups parent DNs.
+// Groups with unknown parents (not found in passed list) are dropped like in per-level search
+func buildTree(baseDn string, groups []GroupInfo, filter *treeFilter) ([]GroupInfo, error) {
+	base, err := ldap.ParseDN(baseDn)
+	if err != nil {
+		return nil, fmt.Errorf("bad base dn: %s", err.Error())
+	}
+
+	nodes := make(map[string]*treeNode, len(groups))
+	parents := make(map[*treeNode]*ldap.DN, len(groups))
+	order := make([]*treeNode, 0, len(groups))
+	for _, g := range groups {
+		dn, err := ldap.ParseDN(g.DName)
+		if err != nil || len(dn.RDNs) == 0 || dn.EqualFold(base) {
+			continue
+		}
+
+		node := &treeNode{info: g}
+		nodes[canonicalDN(dn)] = node
+		parents[node] = &ldap.DN{RDNs: dn.RDNs[1:]}
+		order = append(order, node)
+	}
+
+	roots := make([]*treeNode, 0)
+	for _, node := range order {
+		parent := parents[node]
+		if parent.EqualFold(base) {
+			roots = append(roots, node)
+			continue
+		}
+		if p, ok := nodes[canonicalDN(parent)]; ok {
+			p.children = append(p.children, node)
+		}
+	}
+
+	res, _ := filter.materialize(roots, 1)
+
+	return filter.prune(res), nil
+}
+
+// materialize - make groups tree level from assembled nodes with TreeOptions limits (returns true if level was cut)
+func (f *treeFilter) materialize(nodes []*treeNode, level int) ([]GroupInfo, bool) {
+	byDN := make(map[string]*treeNode, len(nodes))
+	infos := make([]GroupInfo, 0, len(nodes))
+	for _, node := range nodes {
+		byDN[node.info.DName] = node
+		infos = append(infos, node.info)
+	}
+
+	res, truncated := f.level(infos)
+	for k, v := range res {
+		children := byDN[v.DName].children
+		if !f.opts.depthAllowed(level + 1) {
+			res[k].Has = make([]GroupInfo, 0)
+			res[k].Truncated = len(children) > 0
+			continue
+		}
+
+		res[k].Has, res[k].Truncated = f.materialize(children, level+1)
+	}
+
+	return res, truncated
+}
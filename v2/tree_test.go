@@ -0,0 +1,258 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeOptionsDepthAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    TreeOptions
+		level   int
+		allowed bool
+	}{
+		{name: "default in", opts: TreeOptions{}, level: DepthOfLdapSearch, allowed: true},
+		{name: "default out", opts: TreeOptions{}, level: DepthOfLdapSearch + 1, allowed: false},
+		{name: "custom in", opts: TreeOptions{MaxDepth: 2}, level: 2, allowed: true},
+		{name: "custom out", opts: TreeOptions{MaxDepth: 2}, level: 3, allowed: false},
+		{name: "unlimited", opts: TreeOptions{MaxDepth: UnlimitedDepth}, level: 100, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.allowed, tt.opts.depthAllowed(tt.level))
+		})
+	}
+}
+
+func TestTreeFilter(t *testing.T) {
+	level := []GroupInfo{
+		{Name: "Sales", DName: "OU=Sales,DC=test,DC=ru"},
+		{Name: "IT", DName: "OU=IT,DC=test,DC=ru"},
+		{Name: "Old", DName: "OU=Old,DC=test,DC=ru"},
+	}
+
+	tests := []struct {
+		name      string
+		opts      TreeOptions
+		expected  []string
+		truncated bool
+	}{
+		{
+			name:     "no limits",
+			opts:     TreeOptions{},
+			expected: []string{"IT", "Old", "Sales"},
+		},
+		{
+			name:     "exclude",
+			opts:     TreeOptions{Exclude: []string{"ou=old,*"}},
+			expected: []string{"IT", "Sales"},
+		},
+		{
+			name:      "max children",
+			opts:      TreeOptions{MaxChildren: 2},
+			expected:  []string{"IT", "Old"},
+			truncated: true,
+		},
+		{
+			name:     "exclude before max children",
+			opts:     TreeOptions{MaxChildren: 2, Exclude: []string{"OU=Sales,*"}},
+			expected: []string{"IT", "Old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, truncated := newTreeFilter(tt.opts).level(level)
+			names := make([]string, 0)
+			for _, g := range res {
+				names = append(names, g.Name)
+			}
+			require.Equal(t, tt.expected, names)
+			require.Equal(t, tt.truncated, truncated)
+		})
+	}
+}
+
+func TestTreeFilterPrune(t *testing.T) {
+	tree := []GroupInfo{
+		{Name: "Sales", DName: "OU=Sales,DC=test,DC=ru", Has: []GroupInfo{
+			{Name: "East", DName: "OU=East,OU=Sales,DC=test,DC=ru"},
+			{Name: "West", DName: "OU=West,OU=Sales,DC=test,DC=ru"},
+		}},
+		{Name: "IT", DName: "OU=IT,DC=test,DC=ru"},
+	}
+
+	res := newTreeFilter(TreeOptions{Include: []string{"OU=East,*"}}).prune(tree)
+	require.Len(t, res, 1)
+	require.Equal(t, "Sales", res[0].Name)
+	require.Len(t, res[0].Has, 1)
+	require.Equal(t, "East", res[0].Has[0].Name)
+}
+
+func TestBuildTree(t *testing.T) {
+	groups := []GroupInfo{
+		{Name: "East", DName: "OU=East,OU=Sales,DC=test,DC=ru"},
+		{Name: "Sales", DName: "OU=Sales,DC=test,DC=ru"},
+		{Name: "North", DName: "ou=North, ou=East, ou=Sales, dc=test, dc=ru"},
+		{Name: "IT", DName: "OU=IT,DC=test,DC=ru"},
+		{Name: "Lost", DName: "OU=Lost,CN=Users,DC=test,DC=ru"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     TreeOptions
+		expected []GroupInfo
+	}{
+		{
+			name: "default depth",
+			opts: TreeOptions{},
+			expected: []GroupInfo{
+				{Name: "IT", DName: "OU=IT,DC=test,DC=ru", Has: []GroupInfo{}},
+				{Name: "Sales", DName: "OU=Sales,DC=test,DC=ru", Has: []GroupInfo{
+					{Name: "East", DName: "OU=East,OU=Sales,DC=test,DC=ru", Has: []GroupInfo{
+						{Name: "North", DName: "ou=North, ou=East, ou=Sales, dc=test, dc=ru", Has: []GroupInfo{}},
+					}},
+				}},
+			},
+		},
+		{
+			name: "depth 2",
+			opts: TreeOptions{MaxDepth: 2},
+			expected: []GroupInfo{
+				{Name: "IT", DName: "OU=IT,DC=test,DC=ru", Has: []GroupInfo{}},
+				{Name: "Sales", DName: "OU=Sales,DC=test,DC=ru", Has: []GroupInfo{
+					{Name: "East", DName: "OU=East,OU=Sales,DC=test,DC=ru", Has: []GroupInfo{}, Truncated: true},
+				}},
+			},
+		},
+		{
+			name: "max children",
+			opts: TreeOptions{MaxChildren: 1},
+			expected: []GroupInfo{
+				{Name: "IT", DName: "OU=IT,DC=test,DC=ru", Has: []GroupInfo{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := buildTree("dc=TEST,dc=ru", groups, newTreeFilter(tt.opts))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, res)
+		})
+	}
+}
+
+func TestGroupKindOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		attrs    map[string][]string
+		expected GroupKind
+	}{
+		{
+			name:     "ou",
+			attrs:    map[string][]string{"objectClass": {"top", "organizationalUnit"}},
+			expected: GroupKindOU,
+		},
+		{
+			name:     "users container",
+			attrs:    map[string][]string{"objectClass": {"top", "container"}},
+			expected: GroupKindContainer,
+		},
+		{
+			name:     "builtin",
+			attrs:    map[string][]string{"objectClass": {"top", "builtinDomain"}},
+			expected: GroupKindContainer,
+		},
+		{
+			name:     "security group",
+			attrs:    map[string][]string{"objectClass": {"top", "group"}, "groupType": {"-2147483646"}},
+			expected: GroupKindSecurity,
+		},
+		{
+			name:     "distribution group",
+			attrs:    map[string][]string{"objectClass": {"top", "group"}, "groupType": {"8"}},
+			expected: GroupKindDistribution,
+		},
+		{
+			name:     "posix group",
+			attrs:    map[string][]string{"objectClass": {"posixGroup"}},
+			expected: GroupKindPosix,
+		},
+		{
+			name:     "group of names",
+			attrs:    map[string][]string{"objectClass": {"groupOfUniqueNames"}},
+			expected: GroupKindGroupOfNames,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := ldap.NewEntry("CN=test,DC=test,DC=ru", tt.attrs)
+			require.Equal(t, tt.expected, groupKindOf(entry))
+		})
+	}
+}
+
+func TestKindsFilter(t *testing.T) {
+	require.Equal(t, filterGroup, kindsFilter(nil))
+	require.Equal(t,
+		"(|(objectClass=organizationalUnit)(objectClass=posixGroup))",
+		kindsFilter([]GroupKind{GroupKindOU, GroupKindPosix}))
+
+	for kind := range groupKindFilters {
+		_, err := ldap.CompileFilter(kindsFilter([]GroupKind{kind}))
+		require.NoError(t, err, kind)
+	}
+}
+
+// failingConns - conn source that fails every Get
+type failingConns struct {
+	err error
+}
+
+func (f failingConns) Get(context.Context) (*LdapConn, error) {
+	return nil, f.err
+}
+
+func (f failingConns) Put(*LdapConn) {}
+
+func TestReadTree(t *testing.T) {
+	newLevel := func(kind GroupKind, count int) []GroupInfo {
+		level := make([]GroupInfo, 0, count)
+		for i := range count {
+			level = append(level, GroupInfo{Name: fmt.Sprint(i), DName: fmt.Sprintf("OU=%d,DC=test,DC=ru", i), Kind: kind})
+		}
+		return level
+	}
+	filter := newTreeFilter(TreeOptions{})
+	ctx := context.Background()
+
+	// groups have no children, conns are not used
+	level := newLevel(GroupKindSecurity, 3)
+	require.NoError(t, readTree(ctx, failingConns{err: errors.New("no conn")}, level, filter))
+	require.Equal(t, []GroupInfo{}, level[0].Has)
+
+	// conn errors are not skipped
+	connErr := errors.New("no conn")
+	require.ErrorIs(t, readTree(ctx, failingConns{err: connErr}, newLevel(GroupKindOU, 3), filter), connErr)
+
+	// concurrent workers of pool stop on the first error (nothing listens on port 1)
+	pool := NewConnPool(&LdapConn{host: "127.0.0.1", port: 1}, 4)
+	defer pool.Close()
+	err := readTree(ctx, pool, newLevel(GroupKindOU, 20), filter)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, context.Canceled)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.ErrorIs(t, readTree(canceled, pool, newLevel(GroupKindOU, 20), filter), context.Canceled)
+}
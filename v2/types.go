@@ -1,6 +1,12 @@
 // Copyright 2020-2024 NGR Softlab
 package ldapper
 
+import (
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
 // UserFullInfo User full info from AD struct
 type UserFullInfo struct {
 	CN         string `json:"cn"` // full name
@@ -18,6 +24,39 @@ type UserFullInfo struct {
 	Room    string `json:"room"`
 	Phone   string `json:"phone"`
 	Manager string `json:"manager"`
+
+	DName string `json:"distinguishedName,omitempty"`
+	GUID  string `json:"objectGUID,omitempty"` // AD objectGUID (openLdap entryUUID) string form
+	SID   string `json:"objectSid,omitempty"`  // AD objectSid (openLdap sambaSID) string form
+
+	Status AccountStatus `json:"status"` // account state (enabled, locked, expired, etc.)
+}
+
+// NewUser User to create
+type NewUser struct {
+	DN        string // user dn, "cn=<Info.CN>,<parent ou dn>" (parent must exist)
+	Login     string // AD sAMAccountName, openLdap uid
+	UPNSuffix string // AD userPrincipalName suffix (login@suffix), userPrincipalName is not set if empty
+	Surname   string // sn (rdn value is used if empty, openLdap inetOrgPerson requires it)
+	GivenName string
+
+	Info UserFullInfo // profile attrs (cn is taken from dn if empty, DName, GUID, SID and Status are ignored)
+}
+
+// AccountStatus User account state (AD userAccountControl and related attrs, openLdap ppolicy)
+type AccountStatus struct {
+	Enabled              bool `json:"enabled"`
+	Locked               bool `json:"locked"`  // locked out (too many bad passwords)
+	Expired              bool `json:"expired"` // account expiry date has passed
+	PasswordExpired      bool `json:"passwordExpired"`
+	PasswordNeverExpires bool `json:"passwordNeverExpires"`
+	SmartcardRequired    bool `json:"smartcardRequired"`
+	MustChangePassword   bool `json:"mustChangePassword"` // password must be changed at next logon
+
+	Flags           uint32     `json:"flags"`                     // raw AD userAccountControl
+	LockoutTime     *time.Time `json:"lockoutTime,omitempty"`     // lockout start (nil if not locked)
+	AccountExpires  *time.Time `json:"accountExpires,omitempty"`  // account expiry date (nil - never)
+	PasswordLastSet *time.Time `json:"passwordLastSet,omitempty"` // last password change (nil - never or must change)
 }
 
 // UserShortInfo Short info for showing somewhere in lists (light info list)
@@ -27,17 +66,319 @@ type UserShortInfo struct {
 	Mail       string `json:"mail"`
 	Title      string `json:"title"`
 	Department string `json:"department"`
+
+	DName string     `json:"distinguishedName,omitempty"`
+	Kind  MemberKind `json:"kind,omitempty"`       // member kind (for group members)
+	GUID  string     `json:"objectGUID,omitempty"` // AD objectGUID (openLdap entryUUID) string form
 }
 
+// OrgNode Org chart node (user with direct reports)
+type OrgNode struct {
+	UserShortInfo
+	Reports []OrgNode `json:"reports"` // direct reports (sorted by name)
+
+	Truncated bool `json:"truncated,omitempty"` // reports were cut off by max depth
+}
+
+// MemberKind Kind of group member
+type MemberKind string
+
 // GroupInfo Department obj from AD struct.
 type GroupInfo struct {
 	Name  string      `json:"name"`
 	DName string      `json:"distinguishedName"` // long department name
 	Ou    string      `json:"ou"`
-	Has   []GroupInfo `json:"has"` // list of subdirs (group children)
+	Has   []GroupInfo `json:"has"`                  // list of subdirs (group children)
+	Kind  GroupKind   `json:"kind"`                 // node kind (ou, container, group, etc.)
+	GUID  string      `json:"objectGUID,omitempty"` // AD objectGUID (openLdap entryUUID) string form
+
+	Truncated bool `json:"truncated,omitempty"` // some children were cut off by TreeOptions limits
 }
 
 // ADStruct Full AD struct.
 type ADStruct struct {
 	AD []GroupInfo `json:"ad_map"`
 }
+
+// TreeOptions Options for reading AD struct (depth, breadth, filters)
+type TreeOptions struct {
+	StartDN     string      // dn to start reading from (baseDn is used if empty)
+	MaxDepth    int         // max tree depth, root groups are level 1 (0 - DepthOfLdapSearch, UnlimitedDepth - no limit)
+	MaxChildren int         // max children per node (0 - no limit)
+	Kinds       []GroupKind // node kinds to read (only GroupKindOU if empty)
+	Include     []string    // dn patterns (* - any chars, case-insensitive), only matching nodes and their parents are kept
+	Exclude     []string    // dn patterns, matching nodes are dropped with all their children
+	Concurrency int         // max concurrent searches while reading tree level (0, 1 - sequential reading)
+	Pool        *ConnPool   // pool for concurrent reading (new pool of Concurrency conns is opened if nil)
+}
+
+// DiffKind Kind of change between two snapshots
+type DiffKind string
+
+// StructChange OU tree node change (for renamed and moved nodes both dns are set)
+type StructChange struct {
+	Kind    DiffKind `json:"kind"`
+	GUID    string   `json:"objectGUID,omitempty"`
+	OldDN   string   `json:"oldDN,omitempty"`
+	NewDN   string   `json:"newDN,omitempty"`
+	OldName string   `json:"oldName,omitempty"`
+	NewName string   `json:"newName,omitempty"`
+}
+
+// StructDiff Changes between two struct snapshots (removed nodes first, then others in new tree order)
+type StructDiff struct {
+	Changes []StructChange `json:"changes"`
+}
+
+// FieldChange User field change (field is json name of UserShortInfo field)
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// UserChange User list change (fields are set for modified users)
+type UserChange struct {
+	Kind   DiffKind      `json:"kind"`
+	Login  string        `json:"login"`
+	DN     string        `json:"distinguishedName"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// UsersDiff Changes between two user lists
+type UsersDiff struct {
+	Changes []UserChange `json:"changes"`
+}
+
+// Watermark Change tracking high-water mark (json serializable, persist it between runs)
+type Watermark struct {
+	// USN AD highestCommittedUSN by DC dnsHostName (USNs are local to every DC)
+	USN map[string]int64 `json:"usn,omitempty"`
+	// ModifyTimestamp openLdap max modifyTimestamp of read entries (generalized time)
+	ModifyTimestamp string `json:"modifyTimestamp,omitempty"`
+	// EntryCSN openLdap suffix contextCSN read before search, max entryCSN of read entries if there is
+	// no contextCSN (used instead of ModifyTimestamp if set)
+	EntryCSN string `json:"entryCSN,omitempty"`
+}
+
+// ChangeTrackerOptions Options for ChangeTracker
+type ChangeTrackerOptions struct {
+	Filter    string    // entries search filter (users and ous by default)
+	Watermark Watermark // watermark of previous run (all entries are read on the first run)
+}
+
+// ChangeSet Entries created or modified since previous watermark (deleted entries are not tracked)
+type ChangeSet struct {
+	Users     []UserFullInfo `json:"users"`
+	Groups    []GroupInfo    `json:"groups"`    // ous, containers, groups (without children)
+	Watermark Watermark      `json:"watermark"` // watermark for the next run
+}
+
+// DirSyncOptions Options for AD DirSync
+type DirSyncOptions struct {
+	Filter         string   // entries filter (users, groups and ous by default)
+	Attributes     []string // attrs to sync (all attrs by default)
+	ObjectSecurity bool     // only objects and attrs readable by user (no "Replicating Directory Changes" right needed)
+}
+
+// DirSyncEntry Changed AD entry (only changed attrs are returned)
+type DirSyncEntry struct {
+	DN         string              `json:"distinguishedName"`
+	GUID       string              `json:"objectGUID"`
+	Attributes map[string][]string `json:"attributes"`        // changed attrs with all their values (binary values are base64 encoded)
+	Added      map[string][]string `json:"added,omitempty"`   // added values of multi-valued attrs (e.g. member)
+	Removed    map[string][]string `json:"removed,omitempty"` // removed values of multi-valued attrs
+}
+
+// DeletedObject Deleted AD object (tombstone)
+type DeletedObject struct {
+	DN              string `json:"distinguishedName"` // dn in Deleted Objects container
+	GUID            string `json:"objectGUID"`
+	LastKnownParent string `json:"lastKnownParent,omitempty"`
+}
+
+// DirSyncResult AD DirSync changes
+type DirSyncResult struct {
+	Changed []DirSyncEntry  `json:"changed"`
+	Deleted []DeletedObject `json:"deleted"`
+	Cookie  []byte          `json:"cookie"` // cookie for the next DirSync call
+}
+
+// WatchMode Watch changes notification method
+type WatchMode int
+
+// WatchEventType Watch event type
+type WatchEventType string
+
+// WatchOptions Options for Watch
+type WatchOptions struct {
+	Mode       WatchMode // server supported method is chosen by default (syncrepl, then persistent search)
+	Cookie     []byte    // syncrepl cookie of last received event (to resume after reconnect)
+	Attributes []string  // attrs of events entries (all user attrs by default)
+	BufferSize int       // events channel buffer size
+}
+
+// WatchEvent Directory change event
+type WatchEvent struct {
+	Type   WatchEventType
+	DN     string
+	OldDN  string      // previous dn of moved (renamed) entry, if known
+	UUID   string      // entryUUID (syncrepl only)
+	Entry  *ldap.Entry // added or modified entry (nil for delete)
+	Cookie []byte      // syncrepl cookie after this event (persist it to resume watch)
+	Err    error       // watch error (WatchError event is the last one)
+	// Present entryUUIDs of all watched entries (WatchPresent only), entries missing from it are deleted
+	Present []string
+}
+
+// CacheOptions Options for Cache (zero values are replaced by defaults)
+type CacheOptions struct {
+	MaxEntries  int           // max cached results, least recently used ones are evicted
+	UserTTL     time.Duration // users results ttl (GetUserInfo, GetByGUID, GetBySID)
+	GroupTTL    time.Duration // groups results ttl (GetGroupUsers, GetGroupMembers, GetUserGroups)
+	NegativeTTL time.Duration // not found results ttl
+	LoadTimeout time.Duration // timeout of server lookup shared by concurrent callers (not bound to their contexts)
+}
+
+// CacheStats Cache hit/miss statistics
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`         // results taken from cache (with negative ones)
+	NegativeHits uint64 `json:"negativeHits"` // not found results taken from cache
+	Misses       uint64 `json:"misses"`       // results read from server
+	Shared       uint64 `json:"shared"`       // lookups joined to identical in-flight lookup
+	Evictions    uint64 `json:"evictions"`    // results evicted by MaxEntries limit
+	Entries      int    `json:"entries"`      // cached results count
+}
+
+// RenderOptions Options for struct tree rendering (WriteDOT, WriteMermaid, WriteTree)
+type RenderOptions struct {
+	MaxDepth   int            // nodes deeper than MaxDepth are collapsed into "+N more" node (0 - no limit), root groups are level 1
+	UserCounts map[string]int // users count by node dn (see CountUsers), shown in node labels
+}
+
+// GroupKind Kind of AD struct node
+type GroupKind string
+
+// IsGroup Check if node is a group (groups have members, not children)
+func (k GroupKind) IsGroup() bool {
+	switch k {
+	case GroupKindSecurity, GroupKindDistribution, GroupKindGroupOfNames, GroupKindPosix:
+		return true
+	default:
+		return false
+	}
+}
+
+// UserGroups Groups of user (direct, nested and primary)
+type UserGroups struct {
+	Direct  []GroupInfo `json:"direct"`            // groups user is a direct member of
+	All     []GroupInfo `json:"all"`               // all groups including nested ones (transitive closure) and primary
+	Primary *GroupInfo  `json:"primary,omitempty"` // primary group (AD primaryGroupID, openLdap posix gidNumber)
+}
+
+// MembersChange Applied group members change (member attr values: dns or posix uids)
+type MembersChange struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// ExportFormat Users export format
+type ExportFormat int
+
+// UsersExportOptions Options for users export
+type UsersExportOptions struct {
+	Format    ExportFormat // ExportCSV by default
+	Filter    string       // users search filter (all users by default)
+	Columns   []string     // UserFullInfo json names, "name", "login", "enabled", etc. or raw attrs (UserShortInfo fields by default)
+	Headers   []string     // CSV header titles (column names by default), one per column
+	NoHeader  bool         // don't write CSV header
+	Separator string       // CSV multi-valued attrs join separator ("; " by default), JSON Lines have arrays for raw attr columns
+	Comma     rune         // CSV fields delimiter (',' by default)
+	ExcelSafe bool         // CSV: prefix values starting with =, +, -, @ with ' (formula injection protection)
+}
+
+// CSVMapping CSV users import mapping
+type CSVMapping struct {
+	// Columns CSV header title -> field: "login", "surname", "givenName", UserFullInfo json names (e.g. "mail")
+	// or raw attr names (e.g. "employeeType")
+	Columns map[string]string
+	// DNTemplate user dn template with fields placeholders, e.g. "CN={{cn}},OU={{department}},DC=test,DC=ru"
+	// (values are dn escaped)
+	DNTemplate string
+	// Required fields which must be not empty ("login" and template fields are always required)
+	Required []string
+}
+
+// CSVImportOptions Options for CSV users import
+type CSVImportOptions struct {
+	Apply     bool   // write changes (only plan them by default)
+	Update    bool   // update changed mapped attrs of existing users (they are skipped by default)
+	CreateOUs bool   // create missing parent ous of user dns
+	UPNSuffix string // AD userPrincipalName suffix of created users (login@suffix)
+	Comma     rune   // CSV fields delimiter (',' by default)
+}
+
+// CSVRowStatus CSV users import row status
+type CSVRowStatus string
+
+// CSVRowResult CSV users import row result (in plan mode status is the planned action)
+type CSVRowResult struct {
+	Row    int          `json:"row"` // CSV line (header is line 1)
+	DN     string       `json:"dn"`
+	Status CSVRowStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// CSVImportResult CSV users import report
+type CSVImportResult struct {
+	Rows       []CSVRowResult `json:"rows"`
+	Created    int            `json:"created"`
+	Updated    int            `json:"updated"`
+	Skipped    int            `json:"skipped"`
+	Failed     int            `json:"failed"`
+	CreatedOUs []string       `json:"createdOUs"` // created (or planned) parent ous
+}
+
+// ImportOptions Options for LDIF import
+type ImportOptions struct {
+	DryRun          bool // validate records and check targets after earlier records without changes (records get ImportPlanned status)
+	ContinueOnError bool // apply next records after failed one (import stops on first error by default)
+	Rollback        bool // undo applied records in reverse order if some record fails (not with ContinueOnError)
+}
+
+// ImportStatus LDIF record import status
+type ImportStatus string
+
+// RecordResult LDIF record import result
+type RecordResult struct {
+	Line   int          `json:"line"` // record line in LDIF
+	DN     string       `json:"dn"`
+	Type   string       `json:"changetype"`
+	Status ImportStatus `json:"status"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// ImportResult LDIF import report
+type ImportResult struct {
+	Records    []RecordResult `json:"records"`
+	Applied    int            `json:"applied"` // applied (planned for dry-run) records
+	Failed     int            `json:"failed"`
+	RolledBack int            `json:"rolledBack"`
+}
+
+// NestedGroupsMethod Method of nested groups resolving
+type NestedGroupsMethod int
+
+// UserGroupsOptions Options for user groups resolving
+type UserGroupsOptions struct {
+	BaseDN     string             // groups search base (domain root of user dn is used if empty)
+	Method     NestedGroupsMethod // nested groups resolving method (NestedGroupsAuto by default)
+	SkipNested bool               // resolve only direct and primary groups
+	MaxDepth   int                // max nesting depth for NestedGroupsClientSide (0 - no limit)
+}
+
+// GroupUsersOptions Options for group users reading
+type GroupUsersOptions struct {
+	ExcludeDisabled bool // skip disabled accounts
+	ExcludeExpired  bool // skip accounts with passed expiry date
+}
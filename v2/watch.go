@@ -0,0 +1,343 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Watch
+
+// Watch Watch add/modify/delete/moddn changes of entries under baseDN matching filter (any entry if empty).
+// Events channel is closed on ctx cancel or after WatchError event (e.g. connection lost): reconnect
+// and call Watch with WatchOptions.Cookie of the last event to resume syncrepl. Server may resume it
+// by present phase instead of sending deletes: then WatchPresent event lists uuids of all entries,
+// entries missing from it are deleted since cookie.
+// Watch uses conn exclusively (one long search), open dedicated conn for it
+func (conn *LdapConn) Watch(ctx context.Context, baseDN, filter string, opts ...WatchOptions) (<-chan WatchEvent, error) {
+	var watchOpts WatchOptions
+	if len(opts) > 0 {
+		// set only 1st options object
+		watchOpts = opts[0]
+	}
+
+	if err := validateDN(baseDN); err != nil {
+		return nil, err
+	}
+	if filter == "" {
+		filter = filterAny
+	}
+	if _, err := ldap.CompileFilter(filter); err != nil {
+		return nil, fmt.Errorf("%w: bad filter %q: %s", ErrInvalidInput, filter, err.Error())
+	}
+	baseDN, err := conn.resolveDN(ctx, baseDN)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := watchOpts.Mode
+	if mode == WatchAuto {
+		if mode, err = conn.watchMode(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if mode == WatchPersistentSearch && len(watchOpts.Cookie) > 0 {
+		return nil, fmt.Errorf("%w: persistent search can't be resumed by cookie", ErrInvalidInput)
+	}
+
+	searchRequest := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		watchOpts.Attributes,
+		nil,
+	)
+
+	var response ldap.Response
+	var handle func(entry *ldap.Entry, controls []ldap.Control) []WatchEvent
+	var state *syncreplState
+	switch mode {
+	case WatchSyncrepl:
+		state = newSyncreplState(watchOpts.Cookie)
+		handle = state.events
+		response = conn.Connection.Syncrepl(ctx, searchRequest, 0,
+			ldap.SyncRequestModeRefreshAndPersist, watchOpts.Cookie, false)
+	case WatchPersistentSearch:
+		handle = persistentSearchEvents
+		searchRequest.Controls = append(searchRequest.Controls, NewControlPersistentSearch())
+		response = conn.Connection.SearchAsync(ctx, searchRequest, 0)
+	default:
+		return nil, fmt.Errorf("%w: unknown watch mode %d", ErrInvalidInput, mode)
+	}
+
+	events := make(chan WatchEvent, watchOpts.BufferSize)
+	go func() {
+		defer close(events)
+
+		send := func(e WatchEvent) bool {
+			select {
+			case events <- e:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for response.Next() {
+			for _, e := range handle(response.Entry(), response.Controls()) {
+				if !send(e) {
+					return
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := response.Err()
+		if err == nil {
+			// persistent searches are never done by server normally
+			err = errors.New("watch search is done by server")
+		}
+
+		// cookie can be moved by messages without events (e.g. Sync Info new cookie)
+		var cookie []byte
+		if state != nil {
+			cookie = state.cookie
+		}
+		send(WatchEvent{Type: WatchError, Err: fmt.Errorf("bad watch: %w", err), Cookie: cookie})
+	}()
+
+	return events, nil
+}
+
+// watchMode - choose watch mode by rootDSE supportedControl
+func (conn *LdapConn) watchMode(ctx context.Context) (WatchMode, error) {
+	entry, err := conn.readEntry(ctx, "", []string{"supportedControl"})
+	if err != nil {
+		return WatchAuto, err
+	}
+
+	controls := entry.GetAttributeValues("supportedControl")
+	switch {
+	case slices.Contains(controls, ldap.ControlTypeSyncRequest):
+		return WatchSyncrepl, nil
+	case slices.Contains(controls, ControlTypePersistentSearch):
+		return WatchPersistentSearch, nil
+	default:
+		return WatchAuto, fmt.Errorf("%w: neither syncrepl nor persistent search", ErrNotSupported)
+	}
+}
+
+////////////////////////////////////////////// Syncrepl
+
+// syncreplState - syncrepl session state: last cookie, entries dns by entryUUID (for moddn detection)
+// and uuids of present entries of current refresh present phase
+type syncreplState struct {
+	cookie  []byte
+	dns     map[string]string
+	present []string
+}
+
+func newSyncreplState(cookie []byte) *syncreplState {
+	return &syncreplState{cookie: cookie, dns: make(map[string]string)}
+}
+
+// events - make events of syncrepl message (entry with Sync State control or Sync Info/Done message).
+// Present entries (unchanged ones of refresh present phase) are collected for WatchPresent event
+func (s *syncreplState) events(entry *ldap.Entry, controls []ldap.Control) []WatchEvent {
+	res := make([]WatchEvent, 0, 1)
+	for _, c := range controls {
+		switch c := c.(type) {
+		case *ldap.ControlSyncState:
+			if entry == nil {
+				continue
+			}
+			if len(c.Cookie) > 0 {
+				s.cookie = c.Cookie
+			}
+
+			uuid := c.EntryUUID.String()
+			e := WatchEvent{DN: entry.DN, UUID: uuid, Entry: entry, Cookie: s.cookie}
+			switch c.State {
+			case ldap.SyncStatePresent:
+				s.dns[uuid] = entry.DN
+				s.present = append(s.present, uuid)
+				continue
+			case ldap.SyncStateAdd:
+				e.Type = WatchAdd
+			case ldap.SyncStateModify:
+				e.Type = WatchModify
+				if old, ok := s.dns[uuid]; ok && !isSameDN(old, entry.DN) {
+					e.Type, e.OldDN = WatchModDN, old
+				}
+			case ldap.SyncStateDelete:
+				e.Type, e.Entry = WatchDelete, nil
+			}
+
+			if e.Type == WatchDelete {
+				delete(s.dns, uuid)
+			} else {
+				s.dns[uuid] = entry.DN
+			}
+			res = append(res, e)
+
+		case *ldap.ControlSyncInfo:
+			res = append(res, s.syncInfoEvents(c)...)
+
+		case *ldap.ControlSyncDone:
+			if len(c.Cookie) > 0 {
+				s.cookie = c.Cookie
+			}
+		}
+	}
+
+	return res
+}
+
+// syncInfoEvents - update cookie by Sync Info message, make delete events of deleted uuids set
+// and WatchPresent event at the end of refresh present phase (present uuids sets are collected before)
+func (s *syncreplState) syncInfoEvents(c *ldap.ControlSyncInfo) []WatchEvent {
+	var cookie []byte
+	switch {
+	case c.NewCookie != nil:
+		cookie = c.NewCookie.Cookie
+	case c.RefreshDelete != nil:
+		cookie = c.RefreshDelete.Cookie
+	case c.RefreshPresent != nil:
+		cookie = c.RefreshPresent.Cookie
+	case c.SyncIdSet != nil:
+		cookie = c.SyncIdSet.Cookie
+	}
+	if len(cookie) > 0 {
+		s.cookie = cookie
+	}
+
+	res := make([]WatchEvent, 0)
+	switch {
+	case c.RefreshPresent != nil:
+		present := s.present
+		if present == nil {
+			present = make([]string, 0)
+		}
+		s.present = nil
+		res = append(res, WatchEvent{Type: WatchPresent, Present: present, Cookie: s.cookie})
+	case c.SyncIdSet != nil && c.SyncIdSet.RefreshDeletes:
+		for _, id := range c.SyncIdSet.SyncUUIDs {
+			uuid := id.String()
+			res = append(res, WatchEvent{Type: WatchDelete, DN: s.dns[uuid], UUID: uuid, Cookie: s.cookie})
+			delete(s.dns, uuid)
+		}
+	case c.SyncIdSet != nil:
+		for _, id := range c.SyncIdSet.SyncUUIDs {
+			s.present = append(s.present, id.String())
+		}
+	}
+
+	return res
+}
+
+////////////////////////////////////////////// Persistent search
+
+// persistentSearchEvents - make event of persistent search entry (by Entry Change Notification control)
+func persistentSearchEvents(entry *ldap.Entry, controls []ldap.Control) []WatchEvent {
+	if entry == nil {
+		return nil
+	}
+
+	e := WatchEvent{Type: WatchModify, DN: entry.DN, Entry: entry}
+	if c := ldap.FindControl(controls, ControlTypeEntryChangeNotification); c != nil {
+		changeType, previousDN, err := parseEntryChangeNotification(c)
+		if err == nil {
+			switch changeType {
+			case psearchAdd:
+				e.Type = WatchAdd
+			case psearchDelete:
+				e.Type, e.Entry = WatchDelete, nil
+			case psearchModDN:
+				e.Type, e.OldDN = WatchModDN, previousDN
+			}
+		}
+	}
+
+	return []WatchEvent{e}
+}
+
+// parseEntryChangeNotification - decode Entry Change Notification control value:
+// SEQUENCE { changeType ENUMERATED, previousDN LDAPDN OPTIONAL, changeNumber INTEGER OPTIONAL }
+func parseEntryChangeNotification(c ldap.Control) (int64, string, error) {
+	s, ok := c.(*ldap.ControlString)
+	if !ok {
+		return 0, "", fmt.Errorf("bad entry change notification control type %T", c)
+	}
+
+	packet, err := ber.DecodePacketErr([]byte(s.ControlValue))
+	if err != nil {
+		return 0, "", fmt.Errorf("bad entry change notification: %s", err.Error())
+	}
+	if len(packet.Children) == 0 {
+		return 0, "", fmt.Errorf("bad entry change notification: no change type")
+	}
+
+	changeType, ok := packet.Children[0].Value.(int64)
+	if !ok {
+		return 0, "", fmt.Errorf("bad entry change notification: bad change type")
+	}
+
+	var previousDN string
+	if len(packet.Children) > 1 && packet.Children[1].Tag == ber.TagOctetString {
+		previousDN = packet.Children[1].Data.String()
+	}
+
+	return changeType, previousDN, nil
+}
+
+// ControlPersistentSearch Persistent search request control (2.16.840.1.113730.3.4.3)
+type ControlPersistentSearch struct {
+	ChangeTypes int64 // add (1), delete (2), modify (4), moddn (8) flags
+	ChangesOnly bool  // don't return existing entries
+	ReturnECs   bool  // return Entry Change Notification controls
+}
+
+// NewControlPersistentSearch Create persistent search control for all changes only with notifications
+func NewControlPersistentSearch() *ControlPersistentSearch {
+	return &ControlPersistentSearch{
+		ChangeTypes: psearchAdd | psearchDelete | psearchModify | psearchModDN,
+		ChangesOnly: true,
+		ReturnECs:   true,
+	}
+}
+
+// GetControlType Control OID
+func (c *ControlPersistentSearch) GetControlType() string {
+	return ControlTypePersistentSearch
+}
+
+// Encode Control ber packet
+func (c *ControlPersistentSearch) Encode() *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString,
+		ControlTypePersistentSearch, "Control Type (Persistent Search)"))
+	packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Criticality"))
+
+	value := ber.Encode(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, nil, "Control Value (Persistent Search)")
+	seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "PersistentSearch")
+	seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, c.ChangeTypes, "Change Types"))
+	seq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.ChangesOnly, "Changes Only"))
+	seq.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, c.ReturnECs, "Return ECs"))
+	value.AppendChild(seq)
+	packet.AppendChild(value)
+
+	return packet
+}
+
+// String Control description
+func (c *ControlPersistentSearch) String() string {
+	return fmt.Sprintf("Control Type: Persistent Search (%q) ChangeTypes: %d ChangesOnly: %t ReturnECs: %t",
+		ControlTypePersistentSearch, c.ChangeTypes, c.ChangesOnly, c.ReturnECs)
+}
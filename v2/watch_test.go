@@ -0,0 +1,209 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchValidation(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	ctx := context.Background()
+
+	_, err := conn.Watch(ctx, "bad dn", "")
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.Watch(ctx, "dc=test", "(cn=a", WatchOptions{Mode: WatchSyncrepl})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.Watch(ctx, "dc=test", "", WatchOptions{Mode: WatchPersistentSearch, Cookie: []byte("rid=001")})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	_, err = conn.Watch(ctx, "dc=test", "", WatchOptions{Mode: WatchMode(10)})
+	require.ErrorIs(t, err, ErrInvalidInput)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestSyncreplEvents(t *testing.T) {
+	state := newSyncreplState([]byte("rid=000,csn=1"))
+	entry := func(dn string) *ldap.Entry { return ldap.NewEntry(dn, nil) }
+	syncState := func(state ldap.ControlSyncStateState, id byte, cookie string) []ldap.Control {
+		return []ldap.Control{&ldap.ControlSyncState{State: state, EntryUUID: [16]byte{id}, Cookie: []byte(cookie)}}
+	}
+	uuid1 := "01000000-0000-0000-0000-000000000000"
+
+	events := state.events(entry("cn=a,dc=test"), syncState(ldap.SyncStateAdd, 1, ""))
+	require.Len(t, events, 1)
+	require.Equal(t, WatchAdd, events[0].Type)
+	require.Equal(t, uuid1, events[0].UUID)
+	require.Equal(t, []byte("rid=000,csn=1"), events[0].Cookie)
+
+	require.Empty(t, state.events(entry("cn=b,dc=test"), syncState(ldap.SyncStatePresent, 2, "")))
+
+	events = state.events(entry("cn=a,dc=test"), syncState(ldap.SyncStateModify, 1, "rid=000,csn=2"))
+	require.Equal(t, WatchModify, events[0].Type)
+	require.Equal(t, []byte("rid=000,csn=2"), events[0].Cookie)
+
+	events = state.events(entry("cn=c,ou=new,dc=test"), syncState(ldap.SyncStateModify, 1, ""))
+	require.Equal(t, WatchModDN, events[0].Type)
+	require.Equal(t, "cn=a,dc=test", events[0].OldDN)
+
+	events = state.events(entry("cn=c,ou=new,dc=test"), syncState(ldap.SyncStateDelete, 1, ""))
+	require.Equal(t, WatchDelete, events[0].Type)
+	require.Nil(t, events[0].Entry)
+
+	// deleted uuids set of refresh delete phase
+	idSet := &ldap.ControlSyncInfoSyncIdSet{Cookie: []byte("rid=000,csn=3"), RefreshDeletes: true}
+	idSet.SyncUUIDs = append(idSet.SyncUUIDs, [16]byte{2})
+	events = state.events(nil, []ldap.Control{&ldap.ControlSyncInfo{SyncIdSet: idSet}})
+	require.Len(t, events, 1)
+	require.Equal(t, WatchEvent{Type: WatchDelete, DN: "cn=b,dc=test", UUID: "02000000-0000-0000-0000-000000000000",
+		Cookie: []byte("rid=000,csn=3")}, events[0])
+
+	require.Empty(t, state.events(nil, []ldap.Control{&ldap.ControlSyncInfo{NewCookie: &ldap.ControlSyncInfoNewCookie{
+		Cookie: []byte("rid=000,csn=4"),
+	}}}))
+	require.Equal(t, []byte("rid=000,csn=4"), state.cookie)
+
+	// refresh present phase: present entries and uuids set, then missing ones are deleted by client
+	require.Empty(t, state.events(entry("cn=a,dc=test"), syncState(ldap.SyncStatePresent, 1, "")))
+	idSet = &ldap.ControlSyncInfoSyncIdSet{Cookie: []byte("rid=000,csn=5")}
+	idSet.SyncUUIDs = append(idSet.SyncUUIDs, [16]byte{3})
+	require.Empty(t, state.events(nil, []ldap.Control{&ldap.ControlSyncInfo{SyncIdSet: idSet}}))
+	events = state.events(nil, []ldap.Control{&ldap.ControlSyncInfo{RefreshPresent: &ldap.ControlSyncInfoRefreshPresent{
+		Cookie: []byte("rid=000,csn=6"), RefreshDone: true,
+	}}})
+	// (present entry of the start is in the same phase)
+	require.Equal(t, []WatchEvent{{Type: WatchPresent, Cookie: []byte("rid=000,csn=6"), Present: []string{
+		"02000000-0000-0000-0000-000000000000", uuid1, "03000000-0000-0000-0000-000000000000",
+	}}}, events)
+
+	// next present phase starts from scratch
+	events = state.events(nil, []ldap.Control{&ldap.ControlSyncInfo{RefreshPresent: &ldap.ControlSyncInfoRefreshPresent{}}})
+	require.Equal(t, []string{}, events[0].Present)
+}
+
+// syncStateControl - Sync State control of entry: SEQUENCE { state, entryUUID }
+func syncStateControl(state ldap.ControlSyncStateState, id byte) ldap.Control {
+	value := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Sync State")
+	value.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(state), "State"))
+	uuid := [16]byte{id}
+	value.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(uuid[:]), "Entry UUID"))
+
+	return testControl{oid: ldap.ControlTypeSyncState, value: value}
+}
+
+func TestWatch(t *testing.T) {
+	uuid := func(id byte) string { return fmt.Sprintf("%02x000000-0000-0000-0000-000000000000", id) }
+
+	// syncrepl: present phase, change, cookie moved without events, then server error
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		idSet := ber.Encode(ber.ClassContext, ber.TypeConstructed, 3, nil, "Sync Id Set")
+		idSet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "rid=000,csn=2", "Cookie"))
+		idSet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, false, "Refresh Deletes"))
+		uuids := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "UUIDs")
+		uuid2 := [16]byte{2}
+		uuids.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(uuid2[:]), "UUID"))
+		idSet.AppendChild(uuids)
+
+		present := ber.Encode(ber.ClassContext, ber.TypeConstructed, 2, nil, "Refresh Present")
+		present.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "rid=000,csn=3", "Cookie"))
+		present.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Refresh Done"))
+
+		deleteDone := ber.Encode(ber.ClassContext, ber.TypeConstructed, 1, nil, "Refresh Delete")
+		deleteDone.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "rid=000,csn=4", "Cookie"))
+
+		return []testMessage{
+			entryMessage("cn=a,dc=test", nil, syncStateControl(ldap.SyncStatePresent, 1)),
+			intermediateMessage(ldap.ControlTypeSyncInfo, idSet),
+			intermediateMessage(ldap.ControlTypeSyncInfo, present),
+			entryMessage("cn=c,dc=test", map[string][]string{"cn": {"c"}}, syncStateControl(ldap.SyncStateAdd, 3)),
+			intermediateMessage(ldap.ControlTypeSyncInfo, deleteDone),
+			searchDone(ldap.LDAPResultBusy),
+		}
+	})
+
+	events, err := conn.Watch(context.Background(), "dc=test", "", WatchOptions{
+		Mode: WatchSyncrepl, Cookie: []byte("rid=000,csn=1"),
+	})
+	require.NoError(t, err)
+
+	var got []WatchEvent
+	for e := range events {
+		got = append(got, e)
+	}
+	require.Len(t, got, 3)
+	require.Equal(t, WatchEvent{Type: WatchPresent, Present: []string{uuid(1), uuid(2)},
+		Cookie: []byte("rid=000,csn=3")}, got[0])
+	require.Equal(t, WatchAdd, got[1].Type)
+	require.Equal(t, "cn=c,dc=test", got[1].DN)
+	require.Equal(t, uuid(3), got[1].UUID)
+	require.Equal(t, WatchError, got[2].Type)
+	require.Error(t, got[2].Err)
+	require.Equal(t, []byte("rid=000,csn=4"), got[2].Cookie)
+	require.Equal(t, []string{"search dc=test (objectClass=*) []"}, srv.Requests())
+
+	// persistent search: channel is closed on ctx cancel
+	conn, _ = newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		if req.Op.Children[0].Data.String() == "" {
+			return []testMessage{
+				entryMessage("", map[string][]string{"supportedControl": {ControlTypePersistentSearch}}),
+				searchDone(ldap.LDAPResultSuccess),
+			}
+		}
+		require.NotNil(t, ldap.FindControl(req.Controls, ControlTypePersistentSearch))
+		// changes only, search is never done
+		return []testMessage{entryMessage("cn=a,dc=test", nil)}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err = conn.Watch(ctx, "dc=test", "(cn=a)")
+	require.NoError(t, err)
+
+	e := <-events
+	require.Equal(t, WatchModify, e.Type)
+	require.Equal(t, "cn=a,dc=test", e.DN)
+
+	cancel()
+	for e = range events {
+		require.NotEqual(t, WatchError, e.Type)
+	}
+}
+
+func TestPersistentSearchEvents(t *testing.T) {
+	ecn := func(changeType int64, previousDN string) ldap.Control {
+		seq := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "EntryChangeNotification")
+		seq.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, changeType, "changeType"))
+		if previousDN != "" {
+			seq.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, previousDN, "previousDN"))
+		}
+		return ldap.NewControlString(ControlTypeEntryChangeNotification, false, string(seq.Bytes()))
+	}
+	entry := ldap.NewEntry("cn=a,dc=test", nil)
+
+	events := persistentSearchEvents(entry, []ldap.Control{ecn(psearchAdd, "")})
+	require.Equal(t, WatchAdd, events[0].Type)
+
+	events = persistentSearchEvents(entry, []ldap.Control{ecn(psearchModDN, "cn=b,dc=test")})
+	require.Equal(t, WatchModDN, events[0].Type)
+	require.Equal(t, "cn=b,dc=test", events[0].OldDN)
+
+	events = persistentSearchEvents(entry, []ldap.Control{ecn(psearchDelete, "")})
+	require.Equal(t, WatchDelete, events[0].Type)
+	require.Nil(t, events[0].Entry)
+
+	// no notification control
+	events = persistentSearchEvents(entry, nil)
+	require.Equal(t, WatchModify, events[0].Type)
+
+	require.Nil(t, persistentSearchEvents(nil, nil))
+
+	require.Equal(t, ControlTypePersistentSearch, NewControlPersistentSearch().GetControlType())
+	require.NotNil(t, NewControlPersistentSearch().Encode())
+}
@@ -0,0 +1,360 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+////////////////////////////////////////////// Write operations
+
+// CreateUser Create user entry with profile attrs (AD users are created disabled until password is set)
+func (conn *LdapConn) CreateUser(ctx context.Context, user NewUser) error {
+	addRequest, err := conn.newUserAddRequest(user)
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = conn.Connection.Add(addRequest)
+	if err != nil {
+		return fmt.Errorf("bad add: %s", err.Error())
+	}
+
+	return nil
+}
+
+// UpdateUser Change user profile attrs listed in fields mask (json names of UserFullInfo fields, e.g. "mail").
+// Empty values remove attrs
+func (conn *LdapConn) UpdateUser(ctx context.Context, dn string, info UserFullInfo, fields []string) error {
+	modifyRequest, err := conn.newUserModifyRequest(dn, info, fields)
+	if err != nil {
+		return err
+	}
+	if modifyRequest.DN, err = conn.resolveDN(ctx, dn); err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = conn.Connection.Modify(modifyRequest)
+	if err != nil {
+		return fmt.Errorf("bad modify: %s", err.Error())
+	}
+
+	return nil
+}
+
+// DeleteEntry Delete entry (with all its children by subtree delete control if subtree)
+func (conn *LdapConn) DeleteEntry(ctx context.Context, dn string, subtree bool) error {
+	if err := validateDN(dn); err != nil {
+		return err
+	}
+	dn, err := conn.resolveDN(ctx, dn)
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	var controls []ldap.Control
+	if subtree {
+		controls = append(controls, ldap.NewControlSubtreeDelete())
+	}
+
+	err = conn.Connection.Del(ldap.NewDelRequest(dn, controls))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return fmt.Errorf("%w: %s", ErrNotFound, dn)
+	}
+	if err != nil {
+		return fmt.Errorf("bad delete: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Move Move entry under new parent (rdn is kept), returns new dn
+func (conn *LdapConn) Move(ctx context.Context, dn, newParentDN string) (string, error) {
+	if err := validateDN(dn); err != nil {
+		return "", err
+	}
+	if err := validateDN(newParentDN); err != nil {
+		return "", err
+	}
+
+	// new dn is made of real dns, so extended forms are resolved before
+	dn, err := conn.resolveDN(ctx, dn)
+	if err != nil {
+		return "", err
+	}
+	if newParentDN, err = conn.resolveDN(ctx, newParentDN); err != nil {
+		return "", err
+	}
+	parsed, err := parseWriteDN(dn)
+	if err != nil {
+		return "", err
+	}
+	if isSubDN(dn, newParentDN) || isSameDN(dn, newParentDN) {
+		return "", fmt.Errorf("%w: can't move %q under itself", ErrInvalidInput, dn)
+	}
+
+	rdn := parsed.RDNs[0].String()
+
+	err = conn.modifyDN(ctx, ldap.NewModifyDNRequest(dn, rdn, true, newParentDN))
+	if err != nil {
+		return "", err
+	}
+
+	return rdn + "," + newParentDN, nil
+}
+
+// Rename Change entry rdn (e.g. "cn=New Name"), entry stays under the same parent, returns new dn
+func (conn *LdapConn) Rename(ctx context.Context, dn, newRDN string) (string, error) {
+	if err := validateDN(dn); err != nil {
+		return "", err
+	}
+	rdn, err := ldap.ParseDN(newRDN)
+	if err != nil || len(rdn.RDNs) != 1 || isExtendedDNForm(newRDN) {
+		return "", fmt.Errorf("%w: bad rdn %q", ErrInvalidInput, newRDN)
+	}
+	if dn, err = conn.resolveDN(ctx, dn); err != nil {
+		return "", err
+	}
+	parsed, err := parseWriteDN(dn)
+	if err != nil {
+		return "", err
+	}
+
+	parent := ldap.DN{RDNs: parsed.RDNs[1:]}
+	newDN := rdn.String()
+	if len(parent.RDNs) > 0 {
+		newDN += "," + parent.String()
+	}
+
+	err = conn.modifyDN(ctx, ldap.NewModifyDNRequest(dn, rdn.String(), true, ""))
+	if err != nil {
+		return "", err
+	}
+
+	return newDN, nil
+}
+
+// CreateOU Create organizational unit under parent, returns its dn
+func (conn *LdapConn) CreateOU(ctx context.Context, parentDN, name, description string) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", fmt.Errorf("%w: empty ou name", ErrInvalidInput)
+	}
+	if err := validateDN(parentDN); err != nil {
+		return "", err
+	}
+	parentDN, err := conn.resolveDN(ctx, parentDN)
+	if err != nil {
+		return "", err
+	}
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+
+	dn := "ou=" + ldap.EscapeDN(name) + "," + parentDN
+
+	addRequest := ldap.NewAddRequest(dn, nil)
+	addRequest.Attribute("objectClass", OUClasses)
+	addRequest.Attribute("ou", []string{name})
+	if description != "" {
+		addRequest.Attribute("description", []string{description})
+	}
+
+	err = conn.Connection.Add(addRequest)
+	if err != nil {
+		return "", fmt.Errorf("bad add: %s", err.Error())
+	}
+
+	return dn, nil
+}
+
+// modifyDN - run modify dn request
+func (conn *LdapConn) modifyDN(ctx context.Context, modifyDNRequest *ldap.ModifyDNRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := conn.Connection.ModifyDN(modifyDNRequest)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return fmt.Errorf("%w: %s", ErrNotFound, modifyDNRequest.DN)
+	}
+	if err != nil {
+		return fmt.Errorf("bad modify dn: %s", err.Error())
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////// Write requests
+
+// newUserAddRequest - validate new user and make add request for it
+func (conn *LdapConn) newUserAddRequest(user NewUser) (*ldap.AddRequest, error) {
+	parsed, err := parseWriteDN(user.DN)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed.RDNs) < 2 {
+		return nil, fmt.Errorf("%w: user dn %q has no parent", ErrInvalidInput, user.DN)
+	}
+
+	rdn := parsed.RDNs[0].Attributes[0]
+	info := user.Info
+	if info.CN == "" {
+		info.CN = rdn.Value
+	}
+	if strings.EqualFold(rdn.Type, "cn") && info.CN != rdn.Value {
+		return nil, fmt.Errorf("%w: cn %q doesn't match dn %q", ErrInvalidInput, info.CN, user.DN)
+	}
+	if err = validateLogin(user.Login, conn.options.OpenLDAP); err != nil {
+		return nil, err
+	}
+	if err = validateUserInfo(info); err != nil {
+		return nil, err
+	}
+
+	surname := user.Surname
+	if surname == "" {
+		surname = rdn.Value
+	}
+
+	addRequest := ldap.NewAddRequest(user.DN, nil)
+	if conn.options.OpenLDAP {
+		addRequest.Attribute("objectClass", openLDAPUserClasses)
+		addRequest.Attribute("uid", []string{user.Login})
+	} else {
+		addRequest.Attribute("objectClass", ADUserClasses)
+		addRequest.Attribute("sAMAccountName", []string{user.Login})
+		if user.UPNSuffix != "" {
+			addRequest.Attribute("userPrincipalName", []string{user.Login + "@" + user.UPNSuffix})
+		}
+	}
+	addRequest.Attribute("sn", []string{surname})
+	if user.GivenName != "" {
+		addRequest.Attribute("givenName", []string{user.GivenName})
+	}
+
+	for _, f := range userProfile {
+		value := *f.value(&info)
+		if value == "" || (f.name == "cn" && !conn.options.OpenLDAP) {
+			// AD sets cn from rdn itself
+			continue
+		}
+		addRequest.Attribute(f.attr(conn.options.OpenLDAP), []string{value})
+	}
+
+	return addRequest, nil
+}
+
+// newUserModifyRequest - validate fields mask and make modify request for it
+func (conn *LdapConn) newUserModifyRequest(dn string, info UserFullInfo, fields []string) (*ldap.ModifyRequest, error) {
+	if err := validateDN(dn); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: empty fields mask", ErrInvalidInput)
+	}
+	if err := validateUserInfo(info); err != nil {
+		return nil, err
+	}
+
+	modifyRequest := ldap.NewModifyRequest(dn, nil)
+	seen := make(map[string]bool)
+	for _, name := range fields {
+		f, ok := userFieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidInput, name)
+		}
+		if f.readOnly {
+			return nil, fmt.Errorf("%w: field %q can't be updated", ErrInvalidInput, name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		var values []string
+		if value := *f.value(&info); value != "" {
+			values = []string{value}
+		}
+		modifyRequest.Replace(f.attr(conn.options.OpenLDAP), values)
+	}
+
+	return modifyRequest, nil
+}
+
+////////////////////////////////////////////// Validation
+
+// parseWriteDN - parse dn of write operation (not empty, extended dn forms are not allowed)
+func parseWriteDN(dn string) (*ldap.DN, error) {
+	if isExtendedDNForm(dn) {
+		// ldap.ParseDN takes "<GUID=...>" as escaped rdn
+		return nil, fmt.Errorf("%w: extended dn %q is not allowed here", ErrInvalidInput, dn)
+	}
+
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad dn %q: %s", ErrInvalidInput, dn, err.Error())
+	}
+	if len(parsed.RDNs) == 0 {
+		return nil, fmt.Errorf("%w: empty dn", ErrInvalidInput)
+	}
+
+	return parsed, nil
+}
+
+// validateDN - check dn of write operation (AD "<GUID=...>" forms are allowed, they are resolved by resolveDN)
+func validateDN(dn string) error {
+	if isExtendedDNForm(dn) {
+		return nil
+	}
+
+	_, err := parseWriteDN(dn)
+	return err
+}
+
+// isSameDN - check if dns are equal (false for bad dns)
+func isSameDN(dn, other string) bool {
+	ok, err := EqualDN(dn, other)
+	return err == nil && ok
+}
+
+// validateLogin - check user login (AD sAMAccountName rules, openLdap uid)
+func validateLogin(login string, openLDAP bool) error {
+	if strings.TrimSpace(login) == "" {
+		return fmt.Errorf("%w: empty login", ErrInvalidInput)
+	}
+	if openLDAP {
+		return nil
+	}
+
+	if len([]rune(login)) > maxSAMAccountNameLen {
+		return fmt.Errorf("%w: login %q is longer than %d chars", ErrInvalidInput, login, maxSAMAccountNameLen)
+	}
+	if strings.ContainsAny(login, samAccountNameBadChars) || strings.HasSuffix(login, ".") {
+		return fmt.Errorf("%w: login %q has bad chars", ErrInvalidInput, login)
+	}
+
+	return nil
+}
+
+// validateUserInfo - check user profile values
+func validateUserInfo(info UserFullInfo) error {
+	if info.Manager != "" {
+		if _, err := ldap.ParseDN(info.Manager); err != nil {
+			return fmt.Errorf("%w: bad manager dn %q", ErrInvalidInput, info.Manager)
+		}
+	}
+
+	return nil
+}
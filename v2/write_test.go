@@ -0,0 +1,196 @@
+// Copyright 2020-2024 NGR Softlab
+package ldapper
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewUserAddRequest(t *testing.T) {
+	user := NewUser{
+		DN:        "CN=Ivan Ivanov,OU=Sales,DC=test,DC=ru",
+		Login:     "i.ivanov",
+		UPNSuffix: "test.ru",
+		Surname:   "Ivanov",
+		Info:      UserFullInfo{Mail: "i.ivanov@test.ru", Department: "Sales"},
+	}
+
+	conn := &LdapConn{}
+	req, err := conn.newUserAddRequest(user)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"objectClass":       ADUserClasses,
+		"sAMAccountName":    {"i.ivanov"},
+		"userPrincipalName": {"i.ivanov@test.ru"},
+		"sn":                {"Ivanov"},
+		"mail":              {"i.ivanov@test.ru"},
+		"department":        {"Sales"},
+	}, addRequestAttrs(req))
+
+	conn = &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+	req, err = conn.newUserAddRequest(user)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"objectClass":      openLDAPUserClasses,
+		"uid":              {"i.ivanov"},
+		"sn":               {"Ivanov"},
+		"cn":               {"Ivan Ivanov"},
+		"mail":             {"i.ivanov@test.ru"},
+		"departmentNumber": {"Sales"},
+	}, addRequestAttrs(req))
+}
+
+func TestWriteValidation(t *testing.T) {
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage { return nil })
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{name: "user bad dn", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "bad dn", Login: "user"})
+		}},
+		{name: "user without parent", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "CN=user", Login: "user"})
+		}},
+		{name: "user cn mismatch", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "CN=user,DC=test,DC=ru", Login: "user", Info: UserFullInfo{CN: "other"}})
+		}},
+		{name: "user empty login", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "CN=user,DC=test,DC=ru"})
+		}},
+		{name: "user long login", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "CN=user,DC=test,DC=ru", Login: "very.long.login.for.ad"})
+		}},
+		{name: "user bad login", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "CN=user,DC=test,DC=ru", Login: "user[1]"})
+		}},
+		{name: "user bad manager", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "CN=user,DC=test,DC=ru", Login: "user", Info: UserFullInfo{Manager: "boss"}})
+		}},
+		{name: "update empty mask", run: func() error {
+			return conn.UpdateUser(ctx, "CN=user,DC=test,DC=ru", UserFullInfo{}, nil)
+		}},
+		{name: "update unknown field", run: func() error {
+			return conn.UpdateUser(ctx, "CN=user,DC=test,DC=ru", UserFullInfo{}, []string{"password"})
+		}},
+		{name: "update read only field", run: func() error {
+			return conn.UpdateUser(ctx, "CN=user,DC=test,DC=ru", UserFullInfo{CN: "new"}, []string{"cn"})
+		}},
+		{name: "delete empty dn", run: func() error {
+			return conn.DeleteEntry(ctx, "", false)
+		}},
+		{name: "move under itself", run: func() error {
+			_, err := conn.Move(ctx, "OU=Sales,DC=test,DC=ru", "OU=East,OU=Sales,DC=test,DC=ru")
+			return err
+		}},
+		{name: "user extended dn", run: func() error {
+			return conn.CreateUser(ctx, NewUser{DN: "<GUID=33221100-5544-7766-8899-aabbccddeeff>", Login: "user"})
+		}},
+		{name: "rename to extended dn", run: func() error {
+			_, err := conn.Rename(ctx, "OU=Sales,DC=test,DC=ru", "<SID=S-1-5-32-544>")
+			return err
+		}},
+		{name: "rename to dn", run: func() error {
+			_, err := conn.Rename(ctx, "OU=Sales,DC=test,DC=ru", "OU=New,DC=test")
+			return err
+		}},
+		{name: "ou empty name", run: func() error {
+			_, err := conn.CreateOU(ctx, "DC=test,DC=ru", " ", "")
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.ErrorIs(t, tt.run(), ErrInvalidInput)
+		})
+	}
+
+	// new dn is made of real dns only: extended parent must be resolved (openLdap has no such forms)
+	openLDAP := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+	newDN, err := openLDAP.Move(ctx, "CN=user,DC=test,DC=ru", "<GUID=33221100-5544-7766-8899-aabbccddeeff>")
+	require.ErrorIs(t, err, ErrInvalidInput)
+	require.Empty(t, newDN)
+
+	require.Empty(t, srv.Requests())
+}
+
+func TestWriteRequests(t *testing.T) {
+	const guid = "<GUID=33221100-5544-7766-8899-aabbccddeeff>"
+	ctx := context.Background()
+
+	var deleteControls []ldap.Control
+	conn, srv := newTestConn(t, LdapConnOptions{}, func(req testRequest) []testMessage {
+		switch describeRequest(req.Op) {
+		case "search " + guid + " (objectClass=*) [1.1]":
+			return []testMessage{entryMessage("OU=IT,DC=test,DC=ru", nil), searchDone(ldap.LDAPResultSuccess)}
+		case "delete OU=Old,DC=test,DC=ru":
+			return []testMessage{resultMessage(ldap.ApplicationDelResponse, ldap.LDAPResultNoSuchObject)}
+		case "delete OU=Sales,DC=test,DC=ru":
+			deleteControls = req.Controls
+		}
+		return nil
+	})
+
+	newDN, err := conn.Move(ctx, "CN=user,OU=Sales,DC=test,DC=ru", guid)
+	require.NoError(t, err)
+	require.Equal(t, "cn=user,OU=IT,DC=test,DC=ru", newDN)
+
+	newDN, err = conn.Rename(ctx, "CN=user,OU=IT,DC=test,DC=ru", "CN=new user")
+	require.NoError(t, err)
+	require.Equal(t, "cn=new user,ou=IT,dc=test,dc=ru", newDN)
+
+	ouDN, err := conn.CreateOU(ctx, guid, "R&D, East", "research")
+	require.NoError(t, err)
+	require.Equal(t, `ou=R&D\, East,OU=IT,DC=test,DC=ru`, ouDN)
+
+	require.NoError(t, conn.UpdateUser(ctx, "CN=user,OU=IT,DC=test,DC=ru",
+		UserFullInfo{Mail: "user@test.ru"}, []string{"mail", "phone"}))
+
+	require.NoError(t, conn.DeleteEntry(ctx, "OU=Sales,DC=test,DC=ru", true))
+	require.Len(t, deleteControls, 1)
+	require.Equal(t, ldap.ControlTypeSubtreeDelete, deleteControls[0].GetControlType())
+
+	require.ErrorIs(t, conn.DeleteEntry(ctx, "OU=Old,DC=test,DC=ru", false), ErrNotFound)
+
+	require.Equal(t, []string{
+		"search " + guid + " (objectClass=*) [1.1]",
+		"modify dn CN=user,OU=Sales,DC=test,DC=ru: cn=user under OU=IT,DC=test,DC=ru",
+		"modify dn CN=user,OU=IT,DC=test,DC=ru: cn=new user",
+		"search " + guid + " (objectClass=*) [1.1]",
+		`add ou=R&D\, East,OU=IT,DC=test,DC=ru: objectClass [top organizationalUnit]; ou [R&D, East]; description [research]`,
+		"modify CN=user,OU=IT,DC=test,DC=ru: replace mail [user@test.ru]; replace telephoneNumber []",
+		"delete OU=Sales,DC=test,DC=ru",
+		"delete OU=Old,DC=test,DC=ru",
+	}, srv.Requests())
+}
+
+func TestNewUserModifyRequest(t *testing.T) {
+	conn := &LdapConn{options: LdapConnOptions{OpenLDAP: true}}
+
+	req, err := conn.newUserModifyRequest("cn=user,dc=test,dc=ru",
+		UserFullInfo{Mail: "user@test.ru", Department: "IT"},
+		[]string{"mail", "department", "phone", "mail"})
+	require.NoError(t, err)
+	require.Len(t, req.Changes, 3)
+	require.Equal(t, "mail", req.Changes[0].Modification.Type)
+	require.Equal(t, "departmentNumber", req.Changes[1].Modification.Type)
+	// empty value removes attr
+	require.Equal(t, "telephoneNumber", req.Changes[2].Modification.Type)
+	require.Empty(t, req.Changes[2].Modification.Vals)
+	require.Equal(t, uint(ldap.ReplaceAttribute), req.Changes[2].Operation)
+}
+
+// addRequestAttrs - add request attrs as map
+func addRequestAttrs(req *ldap.AddRequest) map[string][]string {
+	res := make(map[string][]string)
+	for _, attr := range req.Attributes {
+		res[attr.Type] = attr.Vals
+	}
+	return res
+}